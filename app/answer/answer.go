@@ -0,0 +1,420 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package answer
+
+import (
+	"bytes"
+	"fmt"
+	"installer/app/install"
+	"installer/app/utility"
+	"installer/lib"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion — версия схемы файла ответов, которую понимает установщик.
+const CurrentVersion = 1
+
+// File — файл ответов для автоматической установки.
+type File struct {
+	Version     int        `yaml:"version" toml:"version"`
+	Image       string     `yaml:"image" toml:"image"`
+	ImageSource string     `yaml:"imageSource,omitempty" toml:"imageSource,omitempty"`
+	Disk        Disk       `yaml:"disk" toml:"disk"`
+	Filesystem  string     `yaml:"filesystem" toml:"filesystem"`
+	Boot        string     `yaml:"boot" toml:"boot"`
+	Encryption  Encryption `yaml:"encryption" toml:"encryption"`
+	Lvm         *Lvm       `yaml:"lvm,omitempty" toml:"lvm,omitempty"`
+	// BtrfsSubvolumes — подтомы корневой btrfs; если не заданы, используются подтомы из конфигурации
+	BtrfsSubvolumes []SubVolume `yaml:"btrfsSubvolumes,omitempty" toml:"btrfsSubvolumes,omitempty"`
+	// Snapshots — исходный и ежедневные снимки подтомов /home и /var
+	Snapshots bool  `yaml:"snapshots,omitempty" toml:"snapshots,omitempty"`
+	Swap      *Swap `yaml:"swap,omitempty" toml:"swap,omitempty"`
+	// Keymap — раскладка консоли (имя kbd, например ru), в том числе для ввода пароля LUKS при загрузке;
+	// если не задана, остаётся раскладка образа
+	Keymap string `yaml:"keymap,omitempty" toml:"keymap,omitempty"`
+	User   User   `yaml:"user" toml:"user"`
+	// Resume — продолжить прерванную установку с теми же параметрами с последнего завершённого этапа
+	Resume bool `yaml:"resume,omitempty" toml:"resume,omitempty"`
+}
+
+// Disk — целевой диск установки: конкретное устройство или правило выбора.
+type Disk struct {
+	Device    string  `yaml:"device,omitempty" toml:"device,omitempty"`
+	Rule      string  `yaml:"rule,omitempty" toml:"rule,omitempty"`
+	MinSizeGB float64 `yaml:"minSizeGB,omitempty" toml:"minSizeGB,omitempty"`
+	// Mode — erase (по умолчанию), alongside для установки рядом с существующей системой
+	// или manual для установки в заранее созданные разделы
+	Mode       string      `yaml:"mode,omitempty" toml:"mode,omitempty"`
+	Shrink     *Shrink     `yaml:"shrink,omitempty" toml:"shrink,omitempty"`
+	Partitions []Partition `yaml:"partitions,omitempty" toml:"partitions,omitempty"`
+	// Mirrors — дополнительные диски для btrfs RAID1
+	Mirrors []string `yaml:"mirrors,omitempty" toml:"mirrors,omitempty"`
+	// Wipe — очистка диска перед разметкой: quick (по умолчанию), discard или luks
+	Wipe string `yaml:"wipe,omitempty" toml:"wipe,omitempty"`
+}
+
+// Partition — роль существующего раздела при ручной разметке.
+type Partition struct {
+	Role   string `yaml:"role" toml:"role"`
+	Path   string `yaml:"path" toml:"path"`
+	Format bool   `yaml:"format" toml:"format"`
+}
+
+// Shrink — раздел, уменьшаемый перед установкой рядом с существующей системой.
+type Shrink struct {
+	Partition string  `yaml:"partition" toml:"partition"`
+	SizeGB    float64 `yaml:"sizeGB" toml:"sizeGB"`
+}
+
+// Lvm — размещение root в группе томов LVM. Размеры томов задаются как в схеме разметки (например, 20GiB или 30%).
+type Lvm struct {
+	Enabled  bool   `yaml:"enabled" toml:"enabled"`
+	VarSize  string `yaml:"varSize,omitempty" toml:"varSize,omitempty"`
+	HomeSize string `yaml:"homeSize,omitempty" toml:"homeSize,omitempty"`
+	SwapSize string `yaml:"swapSize,omitempty" toml:"swapSize,omitempty"`
+}
+
+// Swap — способ подкачки: none, zram, partition или file (только btrfs).
+type Swap struct {
+	Mode      string `yaml:"mode" toml:"mode"`
+	Hibernate bool   `yaml:"hibernate,omitempty" toml:"hibernate,omitempty"`
+	RandomKey bool   `yaml:"randomKey,omitempty" toml:"randomKey,omitempty"`
+}
+
+// SubVolume — подтом корневой btrfs и параметры его монтирования.
+type SubVolume struct {
+	Name       string `yaml:"name" toml:"name"`
+	MountPoint string `yaml:"mountPoint" toml:"mountPoint"`
+	Options    string `yaml:"options,omitempty" toml:"options,omitempty"`
+	NoCow      bool   `yaml:"nocow,omitempty" toml:"nocow,omitempty"`
+}
+
+// Encryption — параметры шифрования LUKS.
+type Encryption struct {
+	Enabled  bool   `yaml:"enabled" toml:"enabled"`
+	Password string `yaml:"password,omitempty" toml:"password,omitempty"`
+	Tpm2     *Tpm2  `yaml:"tpm2,omitempty" toml:"tpm2,omitempty"`
+	Luks     *Luks  `yaml:"luks,omitempty" toml:"luks,omitempty"`
+	// RecoveryKey — добавить второй слот со случайным ключом восстановления
+	RecoveryKey bool `yaml:"recoveryKey,omitempty" toml:"recoveryKey,omitempty"`
+	// ExportDir — каталог (например, на внешнем носителе) для ключа восстановления и резервных копий заголовков LUKS.
+	// Если не задан, ключ выводится в stdout после установки.
+	ExportDir string `yaml:"exportDir,omitempty" toml:"exportDir,omitempty"`
+}
+
+// Tpm2 — автоматическая разблокировка через TPM2. Пароль остаётся запасным способом разблокировки.
+type Tpm2 struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Pcrs — регистры PCR через «+», по умолчанию 7
+	Pcrs string `yaml:"pcrs,omitempty" toml:"pcrs,omitempty"`
+	// Device — устройство TPM2: auto (по умолчанию), /dev/tpmrm0 или, например, swtpm:path=/run/swtpm.sock
+	Device string `yaml:"device,omitempty" toml:"device,omitempty"`
+}
+
+// Luks — экспертные параметры luksFormat; незаданные выбираются cryptsetup.
+type Luks struct {
+	Cipher  string `yaml:"cipher,omitempty" toml:"cipher,omitempty"`
+	KeySize int    `yaml:"keySize,omitempty" toml:"keySize,omitempty"`
+	Pbkdf   string `yaml:"pbkdf,omitempty" toml:"pbkdf,omitempty"`
+	// PbkdfMemory — память argon2 в КиБ
+	PbkdfMemory   int `yaml:"pbkdfMemory,omitempty" toml:"pbkdfMemory,omitempty"`
+	PbkdfParallel int `yaml:"pbkdfParallel,omitempty" toml:"pbkdfParallel,omitempty"`
+	// IterTime — время вычисления ключа в миллисекундах
+	IterTime   int `yaml:"iterTime,omitempty" toml:"iterTime,omitempty"`
+	SectorSize int `yaml:"sectorSize,omitempty" toml:"sectorSize,omitempty"`
+}
+
+// User — создаваемый пользователь.
+type User struct {
+	Login        string `yaml:"login" toml:"login"`
+	Password     string `yaml:"password,omitempty" toml:"password,omitempty"`
+	PasswordHash string `yaml:"passwordHash,omitempty" toml:"passwordHash,omitempty"`
+}
+
+// Load читает файл ответов в формате YAML или TOML (по расширению).
+// Неизвестные ключи считаются ошибкой, чтобы опечатки не проходили молча.
+func Load(path string) (*File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read answer file %s: %w", path, err)
+	}
+
+	var file File
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&file); err != nil {
+			return nil, fmt.Errorf("failed to parse answer file %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(content), &file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse answer file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, key := range undecoded {
+				keys = append(keys, key.String())
+			}
+			return nil, fmt.Errorf("failed to parse answer file %s: unknown keys: %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported answer file format %q, expected .yml, .yaml or .toml", filepath.Ext(path))
+	}
+
+	return &file, nil
+}
+
+// FromInstallerData собирает файл ответов из выбранных в мастере параметров.
+// Пароль пользователя сохраняется только в виде хэша, пароль LUKS не сохраняется.
+// Если diskRule пуст, в файл записывается выбранное устройство.
+func FromInstallerData(data install.InstallerData, diskRule string) (*File, error) {
+	file := &File{
+		Version:     CurrentVersion,
+		Image:       data.Image,
+		ImageSource: data.ImageSource,
+		Filesystem:  data.TypeFilesystem,
+		Boot:        data.TypeBoot,
+		Keymap:      data.Keymap,
+		Encryption:  Encryption{Enabled: data.IsCryptoFilesystem, RecoveryKey: data.RecoveryKey},
+		User:        User{Login: data.User.Login, PasswordHash: data.User.PasswordHash},
+	}
+
+	if diskRule != "" {
+		file.Disk = Disk{Rule: diskRule, MinSizeGB: utility.MinDiskSizeGB}
+	} else {
+		file.Disk = Disk{Device: data.Disk}
+	}
+	file.Disk.Mirrors = data.Partitioning.Mirrors
+	if data.Partitioning.Wipe != install.WipeQuick {
+		file.Disk.Wipe = data.Partitioning.Wipe
+	}
+	file.Snapshots = data.Snapshots
+	if data.Luks != (install.LuksOptions{}) {
+		file.Encryption.Luks = &Luks{
+			Cipher:        data.Luks.Cipher,
+			KeySize:       data.Luks.KeySize,
+			Pbkdf:         data.Luks.Pbkdf,
+			PbkdfMemory:   data.Luks.PbkdfMemory,
+			PbkdfParallel: data.Luks.PbkdfParallel,
+			IterTime:      data.Luks.IterTime,
+			SectorSize:    data.Luks.SectorSize,
+		}
+	}
+	if data.Tpm.Enabled {
+		file.Encryption.Tpm2 = &Tpm2{Enabled: true, Pcrs: data.Tpm.PCRs, Device: data.Tpm.Device}
+	}
+	if data.Swap.Mode != "" && data.Swap.Mode != install.SwapNone {
+		file.Swap = &Swap{Mode: data.Swap.Mode, Hibernate: data.Swap.Hibernate, RandomKey: data.Swap.RandomKey}
+	}
+	for _, subVol := range data.SubVolumes {
+		file.BtrfsSubvolumes = append(file.BtrfsSubvolumes, SubVolume{
+			Name:       subVol.Name,
+			MountPoint: subVol.MountPoint,
+			Options:    subVol.Options,
+			NoCow:      subVol.NoCow,
+		})
+	}
+	if data.Lvm.Enabled {
+		file.Lvm = &Lvm{
+			Enabled:  true,
+			VarSize:  data.Lvm.VarSize,
+			HomeSize: data.Lvm.HomeSize,
+			SwapSize: data.Lvm.SwapSize,
+		}
+	}
+	if data.Partitioning.Manual() {
+		// Разделы относятся к конкретному диску, поэтому правило выбора диска не применяется
+		file.Disk = Disk{Device: data.Disk, Mode: install.ModeManual}
+		for _, assignment := range data.Partitioning.Assignments {
+			file.Disk.Partitions = append(file.Disk.Partitions, Partition{
+				Role:   assignment.Role,
+				Path:   assignment.Path,
+				Format: assignment.Format,
+			})
+		}
+	}
+	if data.Partitioning.Alongside() {
+		file.Disk.Mode = install.ModeAlongside
+		if data.Partitioning.ShrinkPartition != "" {
+			file.Disk.Shrink = &Shrink{
+				Partition: data.Partitioning.ShrinkPartition,
+				SizeGB:    float64(data.Partitioning.ShrinkToMiB) / 1024,
+			}
+		}
+	}
+
+	if file.User.PasswordHash == "" {
+		hash, err := utility.HashPassword(data.User.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash user password: %w", err)
+		}
+		file.User.PasswordHash = hash
+	}
+
+	return file, nil
+}
+
+// Save записывает файл ответов в формате TOML для расширения .toml и в YAML для остальных.
+func (f *File) Save(path string) error {
+	var buf bytes.Buffer
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		if err := toml.NewEncoder(&buf).Encode(f); err != nil {
+			return fmt.Errorf("failed to encode answer file: %w", err)
+		}
+	} else {
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(f); err != nil {
+			return fmt.Errorf("failed to encode answer file: %w", err)
+		}
+	}
+
+	// Файл содержит хэш пароля, поэтому доступен только владельцу
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write answer file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ToInstallerData преобразует файл ответов в параметры установки, выбирая диск по правилу при необходимости.
+// Файл должен быть предварительно проверен через Validate.
+func (f *File) ToInstallerData() (install.InstallerData, error) {
+	disk := f.Disk.Device
+	if disk == "" {
+		selected, err := utility.SelectDisk(f.Disk.Rule, f.minSizeGB())
+		if err != nil {
+			return install.InstallerData{}, err
+		}
+		lib.Log.Infof("Disk %s (%s) selected by rule %q", selected.Path, selected.Size, f.Disk.Rule)
+		disk = selected.Path
+	}
+
+	partitioning := install.Partitioning{Mode: strings.ToLower(f.Disk.Mode), Mirrors: f.Disk.Mirrors, Wipe: strings.ToLower(f.Disk.Wipe)}
+	if f.Disk.Shrink != nil {
+		partitioning.ShrinkPartition = f.Disk.Shrink.Partition
+		partitioning.ShrinkToMiB = int64(f.Disk.Shrink.SizeGB * 1024)
+	}
+	for _, part := range f.Disk.Partitions {
+		partitioning.Assignments = append(partitioning.Assignments, install.PartitionAssignment{
+			Role:   strings.ToLower(part.Role),
+			Path:   part.Path,
+			Format: part.Format,
+		})
+	}
+
+	var lvm install.LvmOptions
+	if f.Lvm != nil {
+		lvm = install.LvmOptions{
+			Enabled:  f.Lvm.Enabled,
+			VarSize:  f.Lvm.VarSize,
+			HomeSize: f.Lvm.HomeSize,
+			SwapSize: f.Lvm.SwapSize,
+		}
+	}
+
+	return install.InstallerData{
+		Image:              f.Image,
+		ImageSource:        f.ImageSource,
+		Disk:               disk,
+		TypeFilesystem:     strings.ToLower(f.Filesystem),
+		TypeBoot:           strings.ToUpper(f.Boot),
+		IsCryptoFilesystem: f.Encryption.Enabled,
+		LuksPassword:       f.Encryption.Password,
+		Tpm:                f.tpm(),
+		Luks:               f.luks(),
+		Keymap:             f.Keymap,
+		RecoveryKey:        f.Encryption.Enabled && f.Encryption.RecoveryKey,
+		User: install.User{
+			Login:        f.User.Login,
+			Password:     f.User.Password,
+			PasswordHash: f.User.PasswordHash,
+		},
+		Partitioning: partitioning,
+		Lvm:          lvm,
+		SubVolumes:   f.subVolumes(),
+		Snapshots:    f.Snapshots,
+		Swap:         f.swap(),
+	}, nil
+}
+
+// luks возвращает параметры luksFormat.
+func (f *File) luks() install.LuksOptions {
+	if f.Encryption.Luks == nil {
+		return install.LuksOptions{}
+	}
+	return install.LuksOptions{
+		Cipher:        f.Encryption.Luks.Cipher,
+		KeySize:       f.Encryption.Luks.KeySize,
+		Pbkdf:         f.Encryption.Luks.Pbkdf,
+		PbkdfMemory:   f.Encryption.Luks.PbkdfMemory,
+		PbkdfParallel: f.Encryption.Luks.PbkdfParallel,
+		IterTime:      f.Encryption.Luks.IterTime,
+		SectorSize:    f.Encryption.Luks.SectorSize,
+	}
+}
+
+// tpm возвращает параметры привязки к TPM2.
+func (f *File) tpm() install.TpmOptions {
+	if f.Encryption.Tpm2 == nil {
+		return install.TpmOptions{}
+	}
+	return install.TpmOptions{
+		Enabled: f.Encryption.Tpm2.Enabled,
+		PCRs:    f.Encryption.Tpm2.Pcrs,
+		Device:  f.Encryption.Tpm2.Device,
+	}
+}
+
+// swap возвращает параметры подкачки; без раздела swap подкачка не настраивается.
+func (f *File) swap() install.SwapOptions {
+	if f.Swap == nil {
+		return install.SwapOptions{Mode: install.SwapNone}
+	}
+	return install.SwapOptions{
+		Mode:      strings.ToLower(f.Swap.Mode),
+		Hibernate: f.Swap.Hibernate,
+		RandomKey: f.Swap.RandomKey,
+	}
+}
+
+func (f *File) minSizeGB() float64 {
+	if f.Disk.MinSizeGB > 0 {
+		return f.Disk.MinSizeGB
+	}
+	return utility.MinDiskSizeGB
+}
+
+// subVolumes возвращает подтомы корневой btrfs в формате установщика.
+func (f *File) subVolumes() []lib.SubVolumeSpec {
+	var subVolumes []lib.SubVolumeSpec
+	for _, subVol := range f.BtrfsSubvolumes {
+		subVolumes = append(subVolumes, lib.SubVolumeSpec{
+			Name:       subVol.Name,
+			MountPoint: subVol.MountPoint,
+			Options:    subVol.Options,
+			NoCow:      subVol.NoCow,
+		})
+	}
+	return subVolumes
+}
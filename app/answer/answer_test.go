@@ -0,0 +1,95 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package answer
+
+import (
+	"installer/app/install"
+	"installer/app/utility"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Сохранённый из мастера файл ответов не должен содержать ни пароля пользователя, ни пароля LUKS,
+// а загруженный обратно — должен давать установку с тем же хэшем пароля.
+func TestSavedProfileHasNoPlaintextPasswords(t *testing.T) {
+	const userPassword = "user-plaintext-secret"
+	const luksPassword = "luks-plaintext-secret"
+
+	data := install.InstallerData{
+		Image:              "ghcr.io/alt-atomic/example:latest",
+		Disk:               "/dev/vda",
+		TypeFilesystem:     "btrfs",
+		TypeBoot:           "UEFI",
+		IsCryptoFilesystem: true,
+		LuksPassword:       luksPassword,
+		User:               install.User{Login: "user", Password: userPassword},
+	}
+
+	for _, name := range []string{"profile.yaml", "profile.toml"} {
+		t.Run(name, func(t *testing.T) {
+			file, err := FromInstallerData(data, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !utility.IsPasswordHash(file.User.PasswordHash) {
+				t.Fatalf("passwordHash %q is not a crypt(3) hash", file.User.PasswordHash)
+			}
+
+			path := filepath.Join(t.TempDir(), name)
+			if err = file.Save(path); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range []string{userPassword, luksPassword} {
+				if strings.Contains(string(content), secret) {
+					t.Errorf("%s contains plaintext password %q:\n%s", name, secret, content)
+				}
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("%s permissions = %v, want 0600", name, info.Mode().Perm())
+			}
+
+			loaded, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.User.Password != "" || loaded.Encryption.Password != "" {
+				t.Errorf("loaded profile has plaintext passwords: user %q, encryption %q", loaded.User.Password, loaded.Encryption.Password)
+			}
+			if loaded.User.PasswordHash != file.User.PasswordHash {
+				t.Errorf("loaded passwordHash = %q, want %q", loaded.User.PasswordHash, file.User.PasswordHash)
+			}
+
+			restored, err := loaded.ToInstallerData()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored.User.Password != "" || restored.User.PasswordHash != file.User.PasswordHash {
+				t.Errorf("restored user = %+v, want only passwordHash %q", restored.User, file.User.PasswordHash)
+			}
+		})
+	}
+}
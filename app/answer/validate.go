@@ -0,0 +1,271 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package answer
+
+import (
+	"fmt"
+	"installer/app/install"
+	"installer/app/utility"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FieldError — ошибка проверки конкретного поля файла ответов.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors — все ошибки, найденные при проверке файла ответов.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	lines := make([]string, 0, len(v))
+	for _, e := range v {
+		lines = append(lines, e.Error())
+	}
+	return "invalid answer file:\n  " + strings.Join(lines, "\n  ")
+}
+
+// Validate проверяет файл ответов по схеме и возвращает ValidationErrors со всеми найденными проблемами.
+func (f *File) Validate() error {
+	var errs ValidationErrors
+	add := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case f.Version == 0:
+		add("version", "is required, current version is %d", CurrentVersion)
+	case f.Version < 0 || f.Version > CurrentVersion:
+		add("version", "unsupported version %d, this installer supports up to %d", f.Version, CurrentVersion)
+	}
+
+	if strings.TrimSpace(f.Image) == "" {
+		add("image", "is required")
+	}
+	if f.ImageSource != "" && !utility.IsOfflineSource(f.ImageSource) {
+		add("imageSource", "unsupported value %q, expected %s, %s or %s reference",
+			f.ImageSource, utility.TransportOCIArchive, utility.TransportOCI, utility.TransportContainersStorage)
+	}
+
+	switch {
+	case f.Disk.Device != "" && f.Disk.Rule != "":
+		add("disk", "device and rule are mutually exclusive")
+	case f.Disk.Device == "" && f.Disk.Rule == "":
+		add("disk", "either device or rule is required")
+	case f.Disk.Rule != "":
+		switch f.Disk.Rule {
+		case utility.DiskRuleFirst, utility.DiskRuleLargest, utility.DiskRuleSmallest:
+			if _, err := utility.SelectDisk(f.Disk.Rule, f.minSizeGB()); err != nil {
+				add("disk.rule", "%v", err)
+			}
+		default:
+			add("disk.rule", "unsupported value %q, expected %s, %s or %s",
+				f.Disk.Rule, utility.DiskRuleFirst, utility.DiskRuleLargest, utility.DiskRuleSmallest)
+		}
+		if f.Disk.MinSizeGB != 0 && f.Disk.MinSizeGB < utility.MinDiskSizeGB {
+			add("disk.minSizeGB", "must be at least %d", utility.MinDiskSizeGB)
+		}
+	default:
+		if f.Disk.MinSizeGB != 0 {
+			add("disk.minSizeGB", "is only used together with disk.rule")
+		}
+		if info, err := os.Stat(f.Disk.Device); err != nil {
+			add("disk.device", "%s not found", f.Disk.Device)
+		} else if info.Mode()&os.ModeDevice == 0 {
+			add("disk.device", "%s is not a block device", f.Disk.Device)
+		}
+	}
+
+	mode := strings.ToLower(f.Disk.Mode)
+	if f.Disk.Shrink != nil && mode != install.ModeAlongside {
+		add("disk.shrink", "is only used with mode %s", install.ModeAlongside)
+	}
+	if len(f.Disk.Partitions) > 0 && mode != install.ModeManual {
+		add("disk.partitions", "is only used with mode %s", install.ModeManual)
+	}
+	if len(f.Disk.Mirrors) > 0 {
+		if mode != "" && mode != install.ModeErase {
+			add("disk.mirrors", "is only used with mode %s", install.ModeErase)
+		}
+		if strings.ToLower(f.Filesystem) != "btrfs" {
+			add("filesystem", "disk.mirrors requires btrfs")
+		}
+		for idx, mirror := range f.Disk.Mirrors {
+			field := fmt.Sprintf("disk.mirrors[%d]", idx)
+			if mirror == f.Disk.Device || slices.Contains(f.Disk.Mirrors[:idx], mirror) {
+				add(field, "%s is listed more than once", mirror)
+			} else if info, err := os.Stat(mirror); err != nil {
+				add(field, "%s not found", mirror)
+			} else if info.Mode()&os.ModeDevice == 0 {
+				add(field, "%s is not a block device", mirror)
+			}
+		}
+	}
+
+	switch wipe := strings.ToLower(f.Disk.Wipe); wipe {
+	case "", install.WipeQuick:
+	case install.WipeDiscard, install.WipeLuks:
+		if mode != "" && mode != install.ModeErase {
+			add("disk.wipe", "%s is only used with mode %s", wipe, install.ModeErase)
+		}
+		if wipe == install.WipeDiscard && f.Disk.Device != "" && !install.DiscardSupported(f.Disk.Device) {
+			add("disk.wipe", "%s does not support discard", f.Disk.Device)
+		}
+	default:
+		add("disk.wipe", "unsupported value %q, expected %s, %s or %s", f.Disk.Wipe, install.WipeQuick, install.WipeDiscard, install.WipeLuks)
+	}
+
+	switch mode {
+	case "", install.ModeErase:
+	case install.ModeManual:
+		if f.Disk.Device == "" {
+			add("disk.device", "is required with mode %s", install.ModeManual)
+		}
+		if len(f.Disk.Partitions) == 0 {
+			add("disk.partitions", "is required with mode %s", install.ModeManual)
+		}
+		for idx, part := range f.Disk.Partitions {
+			field := fmt.Sprintf("disk.partitions[%d]", idx)
+			if !slices.Contains(install.ManualRoles, strings.ToLower(part.Role)) {
+				add(field+".role", "unsupported value %q, expected one of %s", part.Role, strings.Join(install.ManualRoles, ", "))
+			}
+			if part.Path == "" {
+				add(field+".path", "is required")
+			}
+		}
+	case install.ModeAlongside:
+		if f.Disk.Shrink != nil {
+			if f.Disk.Shrink.Partition == "" {
+				add("disk.shrink.partition", "is required")
+			}
+			if f.Disk.Shrink.SizeGB < 1 {
+				add("disk.shrink.sizeGB", "must be at least 1")
+			}
+		}
+	default:
+		add("disk.mode", "unsupported value %q, expected %s, %s or %s", f.Disk.Mode, install.ModeErase, install.ModeAlongside, install.ModeManual)
+	}
+
+	if f.Lvm != nil && f.Lvm.Enabled {
+		if mode == install.ModeManual || len(f.Disk.Mirrors) > 0 {
+			add("lvm", "cannot be combined with mode %s or disk.mirrors", install.ModeManual)
+		}
+		lvm := install.LvmOptions{VarSize: f.Lvm.VarSize, HomeSize: f.Lvm.HomeSize, SwapSize: f.Lvm.SwapSize}
+		if err := lvm.Validate(); err != nil {
+			add("lvm", "%v", err)
+		}
+	}
+
+	switch strings.ToLower(f.Filesystem) {
+	case "btrfs", "ext4", "xfs":
+	case "":
+		add("filesystem", "is required, expected btrfs, ext4 or xfs")
+	default:
+		add("filesystem", "unsupported value %q, expected btrfs, ext4 or xfs", f.Filesystem)
+	}
+
+	if f.Snapshots && !strings.EqualFold(f.Filesystem, "btrfs") {
+		add("snapshots", "requires filesystem btrfs")
+	}
+	if f.Swap != nil {
+		partitioning := install.Partitioning{Mode: mode, Mirrors: f.Disk.Mirrors}
+		if err := f.swap().Validate(strings.ToLower(f.Filesystem), partitioning); err != nil {
+			add("swap", "%v", err)
+		}
+	}
+	if len(f.BtrfsSubvolumes) > 0 {
+		if !strings.EqualFold(f.Filesystem, "btrfs") {
+			add("btrfsSubvolumes", "requires filesystem btrfs")
+		} else if err := install.ValidateSubVolumes(f.subVolumes()); err != nil {
+			add("btrfsSubvolumes", "%v", err)
+		}
+	}
+
+	switch strings.ToUpper(f.Boot) {
+	case "UEFI":
+		if !utility.CheckUEFISupport() {
+			add("boot", "UEFI is not supported on this system, use LEGACY")
+		}
+	case "LEGACY":
+	case "":
+		add("boot", "is required, expected UEFI or LEGACY")
+	default:
+		add("boot", "unsupported value %q, expected UEFI or LEGACY", f.Boot)
+	}
+
+	if f.Encryption.Enabled && len(f.Encryption.Password) < utility.MinPassphraseLen {
+		add("encryption.password", "must be at least %d characters when encryption is enabled (saved profiles do not contain it)", utility.MinPassphraseLen)
+	} else if f.Encryption.Enabled {
+		if level, _ := utility.CheckPassphrase(f.Encryption.Password); level == utility.PassphraseBlocked {
+			add("encryption.password", "is too easy to guess, use several unrelated words or a longer password")
+		}
+	}
+	if err := install.ValidateKeymap(f.Keymap); err != nil {
+		add("keymap", "%v", err)
+	}
+	if f.Encryption.Luks != nil {
+		if !f.Encryption.Enabled {
+			add("encryption.luks", "requires encryption.enabled")
+		}
+		if err := f.luks().Validate(); err != nil {
+			add("encryption.luks", "%v", err)
+		}
+	}
+	if f.Encryption.Tpm2 != nil && f.Encryption.Tpm2.Enabled {
+		if !f.Encryption.Enabled {
+			add("encryption.tpm2", "requires encryption.enabled")
+		}
+		if err := f.tpm().Validate(); err != nil {
+			add("encryption.tpm2.pcrs", "%v", err)
+		}
+	}
+	if f.Encryption.RecoveryKey && !f.Encryption.Enabled {
+		add("encryption.recoveryKey", "requires encryption.enabled")
+	}
+	if f.Encryption.ExportDir != "" && !filepath.IsAbs(f.Encryption.ExportDir) {
+		add("encryption.exportDir", "must be an absolute path, got %q", f.Encryption.ExportDir)
+	}
+
+	if f.User.Login == "" {
+		add("user.login", "is required")
+	} else if valid, tip := utility.IsValidUsername(f.User.Login, false); !valid {
+		add("user.login", "%s", tip)
+	}
+	switch {
+	case f.User.Password != "" && f.User.PasswordHash != "":
+		add("user", "password and passwordHash are mutually exclusive")
+	case f.User.PasswordHash != "":
+		if !utility.IsPasswordHash(f.User.PasswordHash) {
+			add("user.passwordHash", "is not a crypt(3) hash, expected $6$... (see `openssl passwd -6`)")
+		}
+	case f.User.Password == "":
+		add("user", "either password or passwordHash is required")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
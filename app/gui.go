@@ -20,7 +20,9 @@ import (
 	"fmt"
 	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"installer/app/install"
 	"installer/app/steps"
+	"installer/app/utility"
 	"installer/lib"
 	"os"
 	"unsafe"
@@ -106,15 +108,9 @@ func (i *InstallerViewService) OnActivate(app *adw.Application) {
 	navCenterBox.SetEndWidget(rightBox)
 	toolbarView.AddTopBar(navCenterBox)
 
-	var chosenImage string
-	var chosenDisk string
-	var chosenFilesystem string
-	var chosenBootMode string
-	var chosenUsername string
-	var chosenPassword string
+	// Параметры установки заполняются шагами мастера по мере прохождения
+	var data install.InstallerData
 	var chosenLang string
-	var chosenCrypto bool
-	var chosenLuksPassword string
 
 	stepDone := make([]bool, stepsCount)
 
@@ -186,8 +182,9 @@ func (i *InstallerViewService) OnActivate(app *adw.Application) {
 		// Шаг 2: Выбор образа
 		func() gtk.Widgetter {
 			return steps.CreateImageStep(
-				func(selected string) {
-					chosenImage = selected
+				func(selected utility.ImageChoice) {
+					data.Image = selected.Name
+					data.ImageSource = selected.Source
 					stepDone[2] = true
 					nextBtn.SetSensitive(true)
 					currentStep++
@@ -198,10 +195,15 @@ func (i *InstallerViewService) OnActivate(app *adw.Application) {
 		// Шаг 3: Выбор диска
 		func() gtk.Widgetter {
 			return steps.CreateDiskStep(
-				func(disk string, crypto bool, luksPassword string) {
-					chosenDisk = disk
-					chosenCrypto = crypto
-					chosenLuksPassword = luksPassword
+				func(disk string, partitioning install.Partitioning, crypto bool, luksPassword string, tpm install.TpmOptions, luks install.LuksOptions, keymap string) {
+					data.Disk = disk
+					data.Partitioning = partitioning
+					data.IsCryptoFilesystem = crypto
+					data.RecoveryKey = crypto
+					data.LuksPassword = luksPassword
+					data.Tpm = tpm
+					data.Luks = luks
+					data.Keymap = keymap
 					stepDone[3] = true
 					nextBtn.SetSensitive(true)
 					currentStep++
@@ -211,9 +213,19 @@ func (i *InstallerViewService) OnActivate(app *adw.Application) {
 		},
 		// Шаг 4: Выбор файловой системы
 		func() gtk.Widgetter {
+			var btrfsOnlyNote string
+			if data.Partitioning.Raid() {
+				btrfsOnlyNote = lib.T_("RAID1 requires btrfs")
+			}
 			return steps.CreateFilesystemStep(
-				func(fs string) {
-					chosenFilesystem = fs
+				btrfsOnlyNote,
+				data.Partitioning,
+				data.IsCryptoFilesystem,
+				func(fs string, lvm install.LvmOptions, snapshots bool, swap install.SwapOptions) {
+					data.TypeFilesystem = fs
+					data.Lvm = lvm
+					data.Snapshots = snapshots
+					data.Swap = swap
 					stepDone[4] = true
 					nextBtn.SetSensitive(true)
 					currentStep++
@@ -225,7 +237,7 @@ func (i *InstallerViewService) OnActivate(app *adw.Application) {
 		func() gtk.Widgetter {
 			return steps.CreateBootLoaderStep(
 				func(bootMode string) {
-					chosenBootMode = bootMode
+					data.TypeBoot = bootMode
 					stepDone[5] = true
 					nextBtn.SetSensitive(true)
 					currentStep++
@@ -237,8 +249,7 @@ func (i *InstallerViewService) OnActivate(app *adw.Application) {
 		func() gtk.Widgetter {
 			return steps.CreateUserStep(
 				func(username, password string) {
-					chosenUsername = username
-					chosenPassword = password
+					data.User = install.User{Login: username, Password: password}
 					stepDone[6] = true
 					nextBtn.SetSensitive(true)
 					currentStep++
@@ -249,14 +260,9 @@ func (i *InstallerViewService) OnActivate(app *adw.Application) {
 		// Шаг 7: Итог
 		func() gtk.Widgetter {
 			return steps.CreateSummaryStep(
+				window,
+				data,
 				chosenLang,
-				chosenImage,
-				chosenDisk,
-				chosenFilesystem,
-				chosenBootMode,
-				chosenUsername,
-				chosenPassword,
-				chosenCrypto,
 				func() {
 					stepDone[7] = true
 					nextBtn.SetSensitive(true)
@@ -269,15 +275,7 @@ func (i *InstallerViewService) OnActivate(app *adw.Application) {
 		func() gtk.Widgetter {
 			return steps.CreateInstallProgressStep(
 				window,
-				chosenLang,
-				chosenImage,
-				chosenDisk,
-				chosenFilesystem,
-				chosenBootMode,
-				chosenUsername,
-				chosenPassword,
-				chosenCrypto,
-				chosenLuksPassword,
+				data,
 				func() {
 					os.Exit(0)
 				},
@@ -0,0 +1,457 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"fmt"
+	"installer/app/utility"
+	"installer/lib"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Режимы разметки установочного диска
+const (
+	// ModeErase — диск очищается и размечается заново
+	ModeErase = "erase"
+	// ModeAlongside — существующие разделы сохраняются, система ставится в свободное место рядом с ними
+	ModeAlongside = "alongside"
+	// ModeManual — разделы созданы заранее, пользователь сам назначает им роли
+	ModeManual = "manual"
+)
+
+// minShrinkMiB — минимальный размер, до которого можно уменьшить существующий раздел.
+const minShrinkMiB = 1024
+
+// Partitioning — способ разметки установочного диска.
+type Partitioning struct {
+	// Mode — ModeErase (по умолчанию) или ModeAlongside
+	Mode string
+	// ShrinkPartition — раздел, который уменьшается перед установкой рядом, чтобы освободить место
+	ShrinkPartition string
+	// ShrinkToMiB — новый размер уменьшаемого раздела
+	ShrinkToMiB int64
+	// Assignments — роли существующих разделов при ручной разметке
+	Assignments []PartitionAssignment
+	// Mirrors — дополнительные диски, которые вместе с основным образуют btrfs RAID1 (только при очистке диска)
+	Mirrors []string
+	// Wipe — способ очистки диска перед разметкой: WipeQuick (по умолчанию), WipeDiscard или WipeLuks
+	Wipe string
+}
+
+// Alongside сообщает, что установка выполняется рядом с существующими разделами.
+func (p Partitioning) Alongside() bool {
+	return p.Mode == ModeAlongside
+}
+
+// Manual сообщает, что разделы назначены вручную.
+func (p Partitioning) Manual() bool {
+	return p.Mode == ModeManual
+}
+
+// Raid сообщает, что root размещается в btrfs RAID1 на нескольких дисках.
+func (p Partitioning) Raid() bool {
+	return len(p.Mirrors) > 0
+}
+
+// Erase сообщает, что диск очищается и получает новую таблицу разделов.
+func (p Partitioning) Erase() bool {
+	return !p.Alongside() && !p.Manual()
+}
+
+// ExistingPartition — раздел, уже существующий на диске.
+type ExistingPartition struct {
+	Number     int
+	Path       string
+	StartMiB   int64
+	EndMiB     int64
+	Filesystem string
+	Flags      []string
+}
+
+// SizeMiB возвращает размер раздела.
+func (p ExistingPartition) SizeMiB() int64 {
+	return p.EndMiB - p.StartMiB
+}
+
+// HasFlag сообщает, установлен ли у раздела флаг parted.
+func (p ExistingPartition) HasFlag(flag string) bool {
+	for _, f := range p.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// IsESP сообщает, что раздел является системным разделом EFI.
+func (p ExistingPartition) IsESP() bool {
+	return p.HasFlag("esp") || (p.HasFlag("boot") && p.Filesystem == "fat32")
+}
+
+// Shrinkable сообщает, что файловую систему раздела можно уменьшить.
+func (p ExistingPartition) Shrinkable() bool {
+	switch p.Filesystem {
+	case "ntfs", "ext4", "btrfs":
+		return true
+	}
+	return false
+}
+
+// DiskTable — таблица разделов диска.
+type DiskTable struct {
+	Disk       string
+	Label      string
+	SizeMiB    int64
+	Partitions []ExistingPartition
+}
+
+// Partition возвращает раздел таблицы по пути устройства.
+func (t *DiskTable) Partition(path string) (ExistingPartition, bool) {
+	for _, part := range t.Partitions {
+		if part.Path == path {
+			return part, true
+		}
+	}
+	return ExistingPartition{}, false
+}
+
+// ReadDiskTable читает таблицу разделов диска в системе.
+func ReadDiskTable(disk string) (*DiskTable, error) {
+	service := NewInstallerServiceWithExecutor(InstallerData{Disk: disk}, NewSystemExecutor())
+	return service.diskTable(context.Background())
+}
+
+// diskTable читает таблицу разделов установочного диска до изменения разметки.
+func (i *InstallerService) diskTable(ctx context.Context) (*DiskTable, error) {
+	if i.table != nil {
+		return i.table, nil
+	}
+	output, err := i.output(ctx, "parted", "-m", "-s", i.data.Disk, "unit", "MiB", "print")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения таблицы разделов %s: %v", i.data.Disk, err)
+	}
+	table, err := parsePartedTable(i.data.Disk, string(output))
+	if err != nil {
+		return nil, err
+	}
+	i.table = table
+	return table, nil
+}
+
+// parsePartedTable разбирает вывод `parted -m unit MiB print`:
+//
+//	BYT;
+//	/dev/sda:102400MiB:scsi:512:512:gpt:ATA VBOX HARDDISK:;
+//	1:1.00MiB:101MiB:100MiB:fat32:EFI system partition:boot, esp;
+func parsePartedTable(disk, output string) (*DiskTable, error) {
+	table := &DiskTable{Disk: disk}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSuffix(strings.TrimSpace(line), ";"), ":")
+		switch {
+		case len(fields) >= 6 && fields[0] == disk:
+			size, err := parseMiB(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("некорректный размер диска %s: %v", disk, err)
+			}
+			table.SizeMiB = int64(size)
+			table.Label = fields[5]
+		case len(fields) >= 5:
+			number, err := strconv.Atoi(fields[0])
+			if err != nil {
+				continue
+			}
+			start, errStart := parseMiB(fields[1])
+			end, errEnd := parseMiB(fields[2])
+			if errStart != nil || errEnd != nil {
+				return nil, fmt.Errorf("некорректные границы раздела %d на %s", number, disk)
+			}
+			part := ExistingPartition{
+				Number:     number,
+				Path:       partitionPath(disk, number),
+				StartMiB:   int64(math.Floor(start)),
+				EndMiB:     int64(math.Ceil(end)),
+				Filesystem: fields[4],
+			}
+			if len(fields) >= 7 {
+				for _, flag := range strings.Split(fields[6], ",") {
+					if flag = strings.TrimSpace(flag); flag != "" {
+						part.Flags = append(part.Flags, flag)
+					}
+				}
+			}
+			table.Partitions = append(table.Partitions, part)
+		}
+	}
+	if table.SizeMiB == 0 {
+		return nil, fmt.Errorf("не удалось прочитать таблицу разделов %s", disk)
+	}
+	sort.Slice(table.Partitions, func(a, b int) bool { return table.Partitions[a].StartMiB < table.Partitions[b].StartMiB })
+	return table, nil
+}
+
+func parseMiB(value string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSuffix(value, "MiB"), 64)
+}
+
+// freeRegion — непрерывная свободная область диска.
+type freeRegion struct {
+	StartMiB, EndMiB int64
+}
+
+// largestFreeRegion возвращает наибольшую свободную область таблицы с учётом уменьшения раздела.
+func (t *DiskTable) largestFreeRegion(p Partitioning) (freeRegion, error) {
+	partitions := append([]ExistingPartition(nil), t.Partitions...)
+	if p.ShrinkPartition != "" {
+		found := false
+		for idx, part := range partitions {
+			if part.Path != p.ShrinkPartition {
+				continue
+			}
+			if !part.Shrinkable() {
+				return freeRegion{}, fmt.Errorf("файловую систему %s раздела %s нельзя уменьшить", part.Filesystem, part.Path)
+			}
+			if p.ShrinkToMiB < minShrinkMiB || p.ShrinkToMiB >= part.SizeMiB() {
+				return freeRegion{}, fmt.Errorf("новый размер раздела %s должен быть от %d до %d MiB", part.Path, minShrinkMiB, part.SizeMiB()-1)
+			}
+			partitions[idx].EndMiB = part.StartMiB + p.ShrinkToMiB
+			found = true
+		}
+		if !found {
+			return freeRegion{}, fmt.Errorf("раздел %s не найден на диске %s", p.ShrinkPartition, t.Disk)
+		}
+	}
+
+	var best freeRegion
+	start := int64(layoutStartMiB)
+	// Последний MiB диска занимает резервная копия GPT
+	diskEnd := t.SizeMiB - 1
+	for _, part := range append(partitions, ExistingPartition{StartMiB: diskEnd, EndMiB: diskEnd}) {
+		if part.StartMiB-start > best.EndMiB-best.StartMiB {
+			best = freeRegion{StartMiB: start, EndMiB: part.StartMiB}
+		}
+		if part.EndMiB > start {
+			start = part.EndMiB
+		}
+	}
+	if best.EndMiB == 0 {
+		return freeRegion{}, fmt.Errorf("на диске %s нет свободного места", t.Disk)
+	}
+	return best, nil
+}
+
+// PlanAlongside размещает схему в наибольшей свободной области диска, не затрагивая существующие разделы.
+// Существующие системный раздел EFI и раздел bios_grub используются повторно, остальные разделы схемы создаются
+// с первыми свободными номерами.
+func (l Layout) PlanAlongside(table *DiskTable, p Partitioning, rootFS string) ([]plannedPartition, error) {
+	if table.Label != "gpt" {
+		return nil, fmt.Errorf("установка рядом с другой системой возможна только на диске с таблицей GPT, на %s: %s", table.Disk, table.Label)
+	}
+
+	region, err := table.largestFreeRegion(p)
+	if err != nil {
+		return nil, err
+	}
+	if minMiB := int64(utility.MinDiskSizeGB * 1024); region.EndMiB-region.StartMiB < minMiB {
+		return nil, fmt.Errorf("для установки нужно не менее %d MiB свободного места, на диске %s доступно %d MiB",
+			minMiB, table.Disk, region.EndMiB-region.StartMiB)
+	}
+
+	var planned []plannedPartition
+	var created Layout
+	for _, part := range l {
+		reused, ok := table.reusable(part.Role)
+		if !ok {
+			created = append(created, part)
+			continue
+		}
+		lib.Log.Infof("Используется существующий раздел %s для %s", reused.Path, part.Role)
+		planned = append(planned, plannedPartition{
+			PartitionSpec: part,
+			Number:        reused.Number,
+			Path:          reused.Path,
+			StartMiB:      reused.StartMiB,
+			EndMiB:        reused.EndMiB,
+			Existing:      true,
+		})
+	}
+
+	// Новые разделы размечаются так же, как на пустом диске того же размера, что и свободная область
+	regionPlan, err := created.Plan(table.Disk, region.EndMiB-region.StartMiB+layoutStartMiB+1, rootFS)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[int]bool)
+	for _, part := range table.Partitions {
+		used[part.Number] = true
+	}
+	number := 0
+	for _, part := range regionPlan {
+		for number++; used[number]; number++ {
+		}
+		part.Number = number
+		part.Path = partitionPath(table.Disk, number)
+		part.StartMiB += region.StartMiB - layoutStartMiB
+		if part.EndMiB == 0 {
+			part.EndMiB = region.EndMiB
+		} else {
+			part.EndMiB += region.StartMiB - layoutStartMiB
+		}
+		planned = append(planned, part)
+	}
+	return planned, nil
+}
+
+// reusable возвращает существующий раздел, который можно использовать для роли вместо создания нового.
+func (t *DiskTable) reusable(role string) (ExistingPartition, bool) {
+	for _, part := range t.Partitions {
+		if (role == RoleESP && part.IsESP()) || (role == RoleBiosGrub && part.HasFlag("bios_grub")) {
+			return part, true
+		}
+	}
+	return ExistingPartition{}, false
+}
+
+// shrinkPartition уменьшает файловую систему и раздел, освобождая место для установки рядом.
+func (i *InstallerService) shrinkPartition(ctx context.Context) error {
+	p := i.data.Partitioning
+	if p.ShrinkPartition == "" {
+		return nil
+	}
+	table, err := i.diskTable(ctx)
+	if err != nil {
+		return err
+	}
+	part, ok := table.Partition(p.ShrinkPartition)
+	if !ok {
+		return fmt.Errorf("раздел %s не найден на диске %s", p.ShrinkPartition, i.data.Disk)
+	}
+
+	lib.Log.Infof("Уменьшение раздела %s (%s) с %d до %d MiB", part.Path, part.Filesystem, part.SizeMiB(), p.ShrinkToMiB)
+	sizeBytes := strconv.FormatInt(p.ShrinkToMiB*1024*1024, 10)
+	switch part.Filesystem {
+	case "ntfs":
+		// ntfsresize запрашивает подтверждение, даже если проверка файловой системы отключена
+		resize := Command{Name: "ntfsresize", Args: []string{"--force", "--size", sizeBytes, part.Path}, Stdin: "y\n"}
+		if err = i.executor.Run(ctx, resize); err != nil {
+			return fmt.Errorf("ошибка уменьшения NTFS на %s: %v", part.Path, err)
+		}
+	case "ext4":
+		if err = i.run(ctx, "e2fsck", "-f", "-y", part.Path); err != nil {
+			return fmt.Errorf("ошибка проверки файловой системы ext4 на %s: %v", part.Path, err)
+		}
+		if err = i.run(ctx, "resize2fs", part.Path, fmt.Sprintf("%dM", p.ShrinkToMiB)); err != nil {
+			return fmt.Errorf("ошибка уменьшения ext4 на %s: %v", part.Path, err)
+		}
+	case "btrfs":
+		mountPoint := "/mnt/shrink"
+		if err = i.mountDisk(ctx, part.Path, mountPoint, ""); err != nil {
+			return fmt.Errorf("ошибка монтирования %s: %v", part.Path, err)
+		}
+		err = i.run(ctx, "btrfs", "filesystem", "resize", sizeBytes, mountPoint)
+		i.unmountDisk(ctx, mountPoint)
+		if err != nil {
+			return fmt.Errorf("ошибка уменьшения btrfs на %s: %v", part.Path, err)
+		}
+	default:
+		return fmt.Errorf("файловую систему %s раздела %s нельзя уменьшить", part.Filesystem, part.Path)
+	}
+
+	// В режиме скрипта parted отказывается уменьшать раздел, поэтому подтверждение передаётся через stdin
+	resizePart := Command{
+		Name:  "parted",
+		Args:  []string{"---pretend-input-tty", i.data.Disk, "unit", "MiB", "resizepart", strconv.Itoa(part.Number), fmt.Sprintf("%dMiB", part.StartMiB+p.ShrinkToMiB)},
+		Stdin: "Yes\n",
+	}
+	if err = i.executor.Run(ctx, resizePart); err != nil {
+		return fmt.Errorf("ошибка изменения размера раздела %s: %v", part.Path, err)
+	}
+	return nil
+}
+
+// DescribeLayout возвращает итоговую разметку диска по строке на раздел: сохраняемые, уменьшаемые и создаваемые разделы.
+// Используется для предпросмотра, таблица разделов читается из системы.
+func DescribeLayout(data InstallerData) ([]string, error) {
+	service := NewInstallerServiceWithExecutor(data, NewSystemExecutor())
+	ctx := context.Background()
+	plan, err := service.partitionPlan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type row struct {
+		start int64
+		text  string
+	}
+	var rows []row
+	planned := make(map[string]bool)
+	for _, part := range plan {
+		planned[part.Path] = true
+		state := lib.T_("new")
+		if part.Reformat {
+			state = lib.T_("formatted")
+		} else if part.Existing {
+			state = lib.T_("existing")
+		}
+		size := lib.T_("rest")
+		if part.EndMiB != 0 {
+			size = formatMiB(part.EndMiB - part.StartMiB)
+		}
+		rows = append(rows, row{part.StartMiB, fmt.Sprintf("%-16s %-10s %-10s %-8s %s", part.Path, size, part.Role, part.Filesystem, state)})
+	}
+
+	if service.table != nil {
+		for _, part := range service.table.Partitions {
+			if planned[part.Path] {
+				continue
+			}
+			size, state := formatMiB(part.SizeMiB()), lib.T_("kept")
+			if part.Path == data.Partitioning.ShrinkPartition {
+				size, state = formatMiB(data.Partitioning.ShrinkToMiB), fmt.Sprintf(lib.T_("shrunk from %s"), formatMiB(part.SizeMiB()))
+			}
+			rows = append(rows, row{part.StartMiB, fmt.Sprintf("%-16s %-10s %-10s %-8s %s", part.Path, size, "", part.Filesystem, state)})
+		}
+	}
+
+	sort.Slice(rows, func(a, b int) bool { return rows[a].start < rows[b].start })
+	lines := make([]string, 0, len(rows))
+	for _, r := range rows {
+		lines = append(lines, r.text)
+	}
+	return lines, nil
+}
+
+// PreviewLayout возвращает итоговую разметку диска на шаге выбора диска. Файловая система и режим загрузки
+// выбираются позже, поэтому берутся значения по умолчанию: btrfs и UEFI, если он поддерживается.
+func PreviewLayout(disk string, p Partitioning) ([]string, error) {
+	bootMode := "LEGACY"
+	if utility.CheckUEFISupport() {
+		bootMode = "UEFI"
+	}
+	return DescribeLayout(InstallerData{Disk: disk, TypeBoot: bootMode, TypeFilesystem: "btrfs", Partitioning: p})
+}
+
+func formatMiB(mib int64) string {
+	if mib >= 1024 {
+		return fmt.Sprintf("%.1f GiB", float64(mib)/1024)
+	}
+	return fmt.Sprintf("%d MiB", mib)
+}
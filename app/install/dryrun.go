@@ -0,0 +1,298 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Виды действий в плане установки
+const (
+	ActionCommand = "command"
+	ActionMount   = "mount"
+	ActionUnmount = "umount"
+	ActionWrite   = "write"
+	ActionMkdir   = "mkdir"
+	ActionRemove  = "remove"
+	ActionRmdir   = "rmdir"
+)
+
+// errDryRun возвращается запросами, на которые в режиме плана нет ответа.
+var errDryRun = errors.New("not available in dry-run mode")
+
+// PlanAction — одно действие установщика, записанное в режиме плана.
+type PlanAction struct {
+	Kind    string
+	Command Command
+	Path    string
+	Content string
+}
+
+// DryRunExecutor ничего не меняет в системе, а записывает все действия установщика в план.
+// Чтобы установка дошла до конца, он моделирует результат разметки: запоминает созданные
+// parted разделы и файловые системы и отвечает на запросы lsblk и blkid в соответствии с ними.
+type DryRunExecutor struct {
+	mu      sync.Mutex
+	actions []PlanAction
+
+	partitions  map[string][]int  // диск -> номера созданных разделов
+	filesystems map[string]string // устройство -> файловая система
+	mounts      map[string]bool   // точки монтирования, примонтированные по плану
+}
+
+// NewDryRunExecutor — конструктор исполнителя для режима плана
+func NewDryRunExecutor() *DryRunExecutor {
+	return &DryRunExecutor{
+		partitions:  make(map[string][]int),
+		filesystems: make(map[string]string),
+		mounts:      make(map[string]bool),
+	}
+}
+
+// Actions возвращает записанные действия в порядке выполнения.
+func (e *DryRunExecutor) Actions() []PlanAction {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]PlanAction(nil), e.actions...)
+}
+
+// PrintPlan выводит план установки в виде нумерованного списка.
+func (e *DryRunExecutor) PrintPlan(w io.Writer) {
+	for idx, action := range e.Actions() {
+		prefix := fmt.Sprintf("%3d. %-8s", idx+1, action.Kind)
+		switch action.Kind {
+		case ActionWrite:
+			_, _ = fmt.Fprintf(w, "%s %s\n", prefix, action.Path)
+			for _, line := range strings.Split(strings.TrimRight(action.Content, "\n"), "\n") {
+				_, _ = fmt.Fprintf(w, "%14s| %s\n", "", line)
+			}
+		case ActionMkdir, ActionRemove, ActionRmdir:
+			_, _ = fmt.Fprintf(w, "%s %s\n", prefix, action.Path)
+		default:
+			line := action.Command.String()
+			if action.Command.Stdin != "" {
+				line += "  < (hidden)"
+			}
+			_, _ = fmt.Fprintf(w, "%s %s\n", prefix, line)
+		}
+	}
+}
+
+func (e *DryRunExecutor) record(action PlanAction) {
+	e.mu.Lock()
+	e.actions = append(e.actions, action)
+	e.mu.Unlock()
+}
+
+func (e *DryRunExecutor) Run(_ context.Context, cmd Command) error {
+	kind := ActionCommand
+	switch cmd.Name {
+	case "mount":
+		kind = ActionMount
+	case "umount":
+		kind = ActionUnmount
+	}
+	e.record(PlanAction{Kind: kind, Command: cmd})
+	e.simulate(cmd)
+	return nil
+}
+
+func (e *DryRunExecutor) Output(ctx context.Context, cmd Command) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch cmd.Name {
+	case "lsblk":
+		disk := cmd.Args[len(cmd.Args)-1]
+		// Размер диска нужен для расчёта разметки, а список старых разделов LUKS — для стирания их заголовков;
+		// запросы только читают данные, поэтому выполняются в системе
+		if hasArgs(cmd.Args, "-o", "SIZE") || hasArgs(cmd.Args, "-o", "PATH,FSTYPE") {
+			return exec.CommandContext(ctx, cmd.Name, cmd.Args...).Output()
+		}
+		var out strings.Builder
+		out.WriteString(strings.TrimPrefix(disk, "/dev/") + " disk\n")
+		for _, number := range e.partitions[disk] {
+			out.WriteString(strings.TrimPrefix(partitionPath(disk, number), "/dev/") + " part\n")
+		}
+		return []byte(out.String()), nil
+	case "parted":
+		// Таблица разделов нужна для установки рядом с другой системой; запрос только читает данные,
+		// а номера существующих разделов запоминаются, чтобы новые разделы получили следующие свободные номера
+		if len(cmd.Args) > 2 && cmd.Args[len(cmd.Args)-1] == "print" {
+			disk := cmd.Args[2]
+			output, err := exec.CommandContext(ctx, cmd.Name, cmd.Args...).Output()
+			if err == nil {
+				if table, parseErr := parsePartedTable(disk, string(output)); parseErr == nil {
+					e.partitions[disk] = nil
+					for _, part := range table.Partitions {
+						e.partitions[disk] = append(e.partitions[disk], part.Number)
+					}
+				}
+			}
+			return output, err
+		}
+	case "mountpoint":
+		if e.mounts[cmd.Args[len(cmd.Args)-1]] {
+			return nil, nil
+		}
+		return nil, errDryRun
+	case "blkid":
+		device := cmd.Args[len(cmd.Args)-1]
+		if hasArgs(cmd.Args, "-s", "TYPE") {
+			return []byte(e.filesystems[device] + "\n"), nil
+		}
+		if hasArgs(cmd.Args, "-s", "PARTUUID") {
+			return []byte(fmt.Sprintf("<partuuid of %s>\n", device)), nil
+		}
+		return []byte(fmt.Sprintf("<uuid of %s>\n", device)), nil
+	case "pvs":
+		// Группы томов LVM в системе проверяются до установки; запрос только читает данные, поэтому выполняется в системе
+		return exec.CommandContext(ctx, cmd.Name, cmd.Args...).Output()
+	case "systemd-cryptenroll":
+		// Наличие TPM2 определяет, будет ли ключ привязан; запрос только читает данные, поэтому выполняется в системе
+		if slices.Contains(cmd.Args, "--tpm2-device=list") {
+			return exec.CommandContext(ctx, cmd.Name, cmd.Args...).Output()
+		}
+	case "podman":
+		// Дайджест образа известен только после его загрузки
+		if len(cmd.Args) > 1 && cmd.Args[0] == "image" && cmd.Args[1] == "inspect" {
+			return []byte(fmt.Sprintf("<digest of %s>\n", cmd.Args[len(cmd.Args)-1])), nil
+		}
+	case "btrfs":
+		// Смещение файла подкачки для resume_offset известно только после его создания
+		if len(cmd.Args) > 1 && cmd.Args[1] == "map-swapfile" {
+			return []byte(fmt.Sprintf("<offset of %s>\n", cmd.Args[len(cmd.Args)-1])), nil
+		}
+	}
+
+	// Прочие проверки состояния системы в плане не выполняются
+	return nil, errDryRun
+}
+
+func (e *DryRunExecutor) RunPTY(_ context.Context, cmd Command, _ func(line string)) error {
+	e.record(PlanAction{Kind: ActionCommand, Command: cmd})
+	return nil
+}
+
+func (e *DryRunExecutor) WriteFile(path string, content []byte, _ os.FileMode) error {
+	// Журнал установки — служебное состояние, а не часть плана, поэтому его содержимое не выводится
+	if path == journalPath {
+		content = []byte("(hidden)")
+	}
+	e.record(PlanAction{Kind: ActionWrite, Path: path, Content: string(content)})
+	return nil
+}
+
+func (e *DryRunExecutor) MkdirAll(path string, _ os.FileMode) error {
+	e.record(PlanAction{Kind: ActionMkdir, Path: path})
+	return nil
+}
+
+func (e *DryRunExecutor) RemoveAll(path string) error {
+	e.record(PlanAction{Kind: ActionRemove, Path: path})
+	return nil
+}
+
+func (e *DryRunExecutor) Remove(path string) error {
+	e.record(PlanAction{Kind: ActionRmdir, Path: path})
+	return nil
+}
+
+// ReadFile читает из системы только /proc и /sys: объём памяти нужен для расчёта размера подкачки,
+// а поддержка discard — для очистки диска.
+func (e *DryRunExecutor) ReadFile(path string) ([]byte, error) {
+	if strings.HasPrefix(path, "/proc/") || strings.HasPrefix(path, "/sys/") {
+		return os.ReadFile(path)
+	}
+	return nil, &fs.PathError{Op: "read", Path: path, Err: fs.ErrNotExist}
+}
+
+// ReadDir в каталоге ostree deploy возвращает условное имя развёртывания,
+// которое bootc создал бы при установке.
+func (e *DryRunExecutor) ReadDir(path string) ([]fs.DirEntry, error) {
+	if strings.HasSuffix(path, "ostree/deploy/default/deploy") {
+		return []fs.DirEntry{memEntry{name: "<deployment>.0", dir: true}}, nil
+	}
+	return nil, nil
+}
+
+func (e *DryRunExecutor) Stat(path string) (fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+}
+
+// simulate запоминает изменения разметки и монтирования, чтобы последующие запросы
+// lsblk, blkid и mountpoint видели их результат.
+func (e *DryRunExecutor) simulate(cmd Command) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	args := cmd.Args
+	switch {
+	case cmd.Name == "parted" && len(args) >= 3:
+		disk := args[1]
+		switch args[2] {
+		case "mklabel":
+			e.partitions[disk] = nil
+		case "mkpart":
+			// Как и parted, новому разделу GPT присваивается первый свободный номер
+			number := 1
+			for slices.Contains(e.partitions[disk], number) {
+				number++
+			}
+			e.partitions[disk] = append(e.partitions[disk], number)
+			slices.Sort(e.partitions[disk])
+		case "rm":
+			number, _ := strconv.Atoi(args[3])
+			kept := e.partitions[disk][:0]
+			for _, n := range e.partitions[disk] {
+				if n != number {
+					kept = append(kept, n)
+				}
+			}
+			e.partitions[disk] = kept
+		}
+	case strings.HasPrefix(cmd.Name, "mkfs.") && len(args) > 0:
+		fsType := strings.TrimPrefix(cmd.Name, "mkfs.")
+		if fsType == "fat" {
+			fsType = "vfat"
+		}
+		e.filesystems[args[len(args)-1]] = fsType
+	case cmd.Name == "mount" && len(args) > 0:
+		e.mounts[args[len(args)-1]] = true
+	case cmd.Name == "umount" && len(args) > 0:
+		delete(e.mounts, args[len(args)-1])
+	}
+}
+
+func hasArgs(args []string, flag, value string) bool {
+	for idx := 0; idx+1 < len(args); idx++ {
+		if args[idx] == flag && args[idx+1] == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"installer/lib"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// Command — внешняя команда, запускаемая установщиком.
+type Command struct {
+	Name string
+	Args []string
+	// Stdin передаётся команде на стандартный ввод (например, пароль LUKS) и никогда не выводится в план
+	Stdin string
+}
+
+// Cmd создаёт команду без стандартного ввода.
+func Cmd(name string, args ...string) Command {
+	return Command{Name: name, Args: args}
+}
+
+// String возвращает команду в виде строки для журнала и плана установки.
+func (c Command) String() string {
+	parts := []string{c.Name}
+	for _, arg := range c.Args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"$;&|<>()[]*?") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Executor — всё, что установщик делает с системой: запуск команд и работа с файлами.
+// Сервис установки обращается к системе только через него, что позволяет подменить
+// реальное выполнение записью плана.
+type Executor interface {
+	// Run выполняет команду, её вывод направляется в stdout/stderr установщика.
+	Run(ctx context.Context, cmd Command) error
+	// Output выполняет команду, не изменяющую систему, и возвращает её stdout.
+	Output(ctx context.Context, cmd Command) ([]byte, error)
+	// RunPTY выполняет команду в псевдотерминале и передаёт каждую строку вывода в onLine.
+	RunPTY(ctx context.Context, cmd Command, onLine func(line string)) error
+
+	WriteFile(path string, content []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	RemoveAll(path string) error
+	// Remove удаляет файл или пустой каталог; точки монтирования удаляются только им.
+	Remove(path string) error
+	ReadFile(path string) ([]byte, error)
+	ReadDir(path string) ([]fs.DirEntry, error)
+	Stat(path string) (fs.FileInfo, error)
+}
+
+// commandStopTimeout — сколько отменённая команда может завершаться после SIGTERM.
+const commandStopTimeout = 30 * time.Second
+
+// SystemExecutor выполняет команды и операции с файлами в реальной системе.
+type SystemExecutor struct{}
+
+// NewSystemExecutor — конструктор исполнителя для реальной системы
+func NewSystemExecutor() *SystemExecutor {
+	return &SystemExecutor{}
+}
+
+func (e *SystemExecutor) command(ctx context.Context, cmd Command) *exec.Cmd {
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	// При отмене команда получает SIGTERM, чтобы podman успел передать его контейнеру, а rsync и cryptsetup — завершиться;
+	// SIGKILL посылается, только если команда не завершилась за commandStopTimeout
+	c.Cancel = func() error {
+		return c.Process.Signal(syscall.SIGTERM)
+	}
+	c.WaitDelay = commandStopTimeout
+	if cmd.Stdin != "" {
+		c.Stdin = strings.NewReader(cmd.Stdin)
+	}
+	return c
+}
+
+func (e *SystemExecutor) Run(ctx context.Context, cmd Command) error {
+	c := e.command(ctx, cmd)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
+}
+
+func (e *SystemExecutor) Output(ctx context.Context, cmd Command) ([]byte, error) {
+	return e.command(ctx, cmd).Output()
+}
+
+func (e *SystemExecutor) RunPTY(ctx context.Context, cmd Command, onLine func(line string)) error {
+	c := e.command(ctx, cmd)
+
+	// Устанавливаем переменную окружения для поддержки TTY.
+	c.Env = append(os.Environ(), "TERM=xterm-256color")
+
+	ptmx, err := pty.Start(c)
+	if err != nil {
+		return fmt.Errorf("failed to run command from pty: %v", err)
+	}
+	defer func() { _ = ptmx.Close() }()
+
+	// Если после отмены потомки команды держат терминал открытым, чтение прерывается его закрытием
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(commandStopTimeout, func() { _ = ptmx.Close() })
+	})
+	defer stop()
+
+	// Устанавливаем размер терминала (опционально)
+	if err = pty.Setsize(ptmx, &pty.Winsize{
+		Rows: 40,
+		Cols: 120,
+	}); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(ptmx)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 100*1024*1024)
+	for scanner.Scan() {
+		onLine(scanner.Text())
+	}
+	if err = scanner.Err(); err != nil {
+		lib.Log.Errorf("error reading output: %v", err)
+	}
+
+	return c.Wait()
+}
+
+func (e *SystemExecutor) WriteFile(path string, content []byte, perm os.FileMode) error {
+	return os.WriteFile(path, content, perm)
+}
+
+func (e *SystemExecutor) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (e *SystemExecutor) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (e *SystemExecutor) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (e *SystemExecutor) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (e *SystemExecutor) ReadDir(path string) ([]fs.DirEntry, error) {
+	return os.ReadDir(path)
+}
+
+func (e *SystemExecutor) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
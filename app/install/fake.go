@@ -0,0 +1,300 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fakeResponse — заготовленный ответ на команды, строка которых начинается с prefix.
+type fakeResponse struct {
+	prefix string
+	output string
+	err    error
+	handle func(cmd Command) (string, error)
+}
+
+// errNotMounted — ответ mountpoint для путей, которые не были примонтированы.
+var errNotMounted = errors.New("not a mountpoint")
+
+// FakeExecutor — исполнитель для тестов. Он записывает все вызванные команды, отвечает на них
+// заранее заданным выводом и хранит файлы в памяти, не обращаясь к системе.
+// Как и exec.CommandContext, команды с отменённым контекстом не запускаются и не записываются.
+// Выполненные mount и umount запоминаются, и mountpoint отвечает в соответствии с ними.
+type FakeExecutor struct {
+	mu        sync.Mutex
+	calls     []Command
+	responses []fakeResponse
+
+	files  map[string][]byte
+	dirs   map[string]bool
+	mounts map[string]bool
+}
+
+// NewFakeExecutor — конструктор тестового исполнителя
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{
+		files:  make(map[string][]byte),
+		dirs:   map[string]bool{"/": true},
+		mounts: make(map[string]bool),
+	}
+}
+
+// On задаёт ответ для команд, строка которых (см. Command.String) начинается с prefix.
+// Для Output и RunPTY output возвращается как вывод команды. Если подходит несколько ответов,
+// используется последний заданный. Команды без ответа завершаются успешно с пустым выводом.
+func (e *FakeExecutor) On(prefix string, output string, err error) *FakeExecutor {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.responses = append(e.responses, fakeResponse{prefix: prefix, output: output, err: err})
+	return e
+}
+
+// OnCall задаёт ответ, который вычисляет handle в момент вызова команды,
+// например чтобы отменить установку посреди этапа.
+func (e *FakeExecutor) OnCall(prefix string, handle func(cmd Command) (string, error)) *FakeExecutor {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.responses = append(e.responses, fakeResponse{prefix: prefix, handle: handle})
+	return e
+}
+
+// Calls возвращает вызванные команды в порядке вызова.
+func (e *FakeExecutor) Calls() []Command {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Command(nil), e.calls...)
+}
+
+// Commands возвращает строки вызванных команд, удобные для сравнения в тестах.
+func (e *FakeExecutor) Commands() []string {
+	var result []string
+	for _, cmd := range e.Calls() {
+		result = append(result, cmd.String())
+	}
+	return result
+}
+
+// File возвращает содержимое файла, записанного через исполнитель.
+func (e *FakeExecutor) File(path string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	content, ok := e.files[filepath.Clean(path)]
+	return string(content), ok
+}
+
+func (e *FakeExecutor) call(ctx context.Context, cmd Command) fakeResponse {
+	if err := ctx.Err(); err != nil {
+		return fakeResponse{err: err}
+	}
+
+	response := e.response(cmd)
+	if response.handle != nil {
+		response.output, response.err = response.handle(cmd)
+	}
+	if response.err == nil && len(cmd.Args) > 0 {
+		target := filepath.Clean(cmd.Args[len(cmd.Args)-1])
+		e.mu.Lock()
+		switch cmd.Name {
+		case "mount":
+			e.mounts[target] = true
+		case "umount":
+			delete(e.mounts, target)
+		}
+		e.mu.Unlock()
+	}
+	return response
+}
+
+// response записывает команду и находит ответ на неё.
+func (e *FakeExecutor) response(cmd Command) fakeResponse {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.calls = append(e.calls, cmd)
+	line := cmd.String()
+	for idx := len(e.responses) - 1; idx >= 0; idx-- {
+		if strings.HasPrefix(line, e.responses[idx].prefix) {
+			return e.responses[idx]
+		}
+	}
+	if cmd.Name == "mountpoint" && len(cmd.Args) > 0 && !e.mounts[filepath.Clean(cmd.Args[len(cmd.Args)-1])] {
+		return fakeResponse{err: errNotMounted}
+	}
+	return fakeResponse{}
+}
+
+func (e *FakeExecutor) Run(ctx context.Context, cmd Command) error {
+	return e.call(ctx, cmd).err
+}
+
+func (e *FakeExecutor) Output(ctx context.Context, cmd Command) ([]byte, error) {
+	response := e.call(ctx, cmd)
+	return []byte(response.output), response.err
+}
+
+func (e *FakeExecutor) RunPTY(ctx context.Context, cmd Command, onLine func(line string)) error {
+	response := e.call(ctx, cmd)
+	if response.output != "" {
+		for _, line := range strings.Split(strings.TrimRight(response.output, "\n"), "\n") {
+			onLine(line)
+		}
+	}
+	return response.err
+}
+
+func (e *FakeExecutor) WriteFile(path string, content []byte, _ os.FileMode) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	path = filepath.Clean(path)
+	e.files[path] = append([]byte(nil), content...)
+	e.mkdirAll(filepath.Dir(path))
+	return nil
+}
+
+func (e *FakeExecutor) MkdirAll(path string, _ os.FileMode) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mkdirAll(filepath.Clean(path))
+	return nil
+}
+
+func (e *FakeExecutor) mkdirAll(path string) {
+	for ; !e.dirs[path]; path = filepath.Dir(path) {
+		e.dirs[path] = true
+	}
+}
+
+func (e *FakeExecutor) RemoveAll(path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	path = filepath.Clean(path)
+	for name := range e.files {
+		if name == path || strings.HasPrefix(name, path+"/") {
+			delete(e.files, name)
+		}
+	}
+	for name := range e.dirs {
+		if name == path || strings.HasPrefix(name, path+"/") {
+			delete(e.dirs, name)
+		}
+	}
+	return nil
+}
+
+// Remove, как и os.Remove, не удаляет каталог, в котором что-то есть.
+func (e *FakeExecutor) Remove(path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	path = filepath.Clean(path)
+	if _, ok := e.files[path]; ok {
+		delete(e.files, path)
+		return nil
+	}
+	if !e.dirs[path] {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	for name := range e.files {
+		if strings.HasPrefix(name, path+"/") {
+			return &fs.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+		}
+	}
+	for name := range e.dirs {
+		if strings.HasPrefix(name, path+"/") {
+			return &fs.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+		}
+	}
+	delete(e.dirs, path)
+	return nil
+}
+
+func (e *FakeExecutor) ReadFile(path string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	content, ok := e.files[filepath.Clean(path)]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: path, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), content...), nil
+}
+
+func (e *FakeExecutor) ReadDir(path string) ([]fs.DirEntry, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	path = filepath.Clean(path)
+	if !e.dirs[path] {
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	}
+
+	var entries []fs.DirEntry
+	for name := range e.dirs {
+		if name != path && filepath.Dir(name) == path {
+			entries = append(entries, memEntry{name: filepath.Base(name), dir: true})
+		}
+	}
+	for name, content := range e.files {
+		if filepath.Dir(name) == path {
+			entries = append(entries, memEntry{name: filepath.Base(name), size: int64(len(content))})
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Name() < entries[b].Name() })
+	return entries, nil
+}
+
+func (e *FakeExecutor) Stat(path string) (fs.FileInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	path = filepath.Clean(path)
+	if e.dirs[path] {
+		return memEntry{name: filepath.Base(path), dir: true}, nil
+	}
+	if content, ok := e.files[path]; ok {
+		return memEntry{name: filepath.Base(path), size: int64(len(content))}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+}
+
+// memEntry — файл или каталог, существующий только в памяти исполнителя.
+type memEntry struct {
+	name string
+	dir  bool
+	size int64
+}
+
+func (m memEntry) Name() string               { return m.name }
+func (m memEntry) IsDir() bool                { return m.dir }
+func (m memEntry) Type() fs.FileMode          { return m.Mode().Type() }
+func (m memEntry) Info() (fs.FileInfo, error) { return m, nil }
+func (m memEntry) Size() int64                { return m.size }
+func (m memEntry) ModTime() time.Time         { return time.Time{} }
+func (m memEntry) Sys() any                   { return nil }
+
+func (m memEntry) Mode() fs.FileMode {
+	if m.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,318 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"installer/lib"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Этапы установки в порядке выполнения
+const (
+	// PhaseDisk — очистка, разметка, шифрование и форматирование диска, монтирование хранилища контейнеров
+	PhaseDisk = "disk"
+	// PhaseImage — загрузка образа или копирование с установочного носителя в хранилище контейнеров
+	PhaseImage = "image"
+	// PhaseDeploy — развёртывание образа через bootc
+	PhaseDeploy = "deploy"
+	// PhaseConfigure — пользователь, часовой пояс, содержимое /var и /home, fstab
+	PhaseConfigure = "configure"
+	// PhaseFinalize — удаление хранилища контейнеров и расширение root
+	PhaseFinalize = "finalize"
+)
+
+var phases = []string{PhaseDisk, PhaseImage, PhaseDeploy, PhaseConfigure, PhaseFinalize}
+
+// journalPath — журнал установки в tmpfs установочной системы рядом с копиями заголовков LUKS:
+// он переживает перезапуск установщика, но не перезагрузку.
+var journalPath = filepath.Join(recoveryDir, "journal.json")
+
+// PhaseTitle возвращает название этапа установки для интерфейса.
+func PhaseTitle(phase string) string {
+	switch phase {
+	case PhaseDisk:
+		return lib.T_("Disk preparation")
+	case PhaseImage:
+		return lib.T_("Image download")
+	case PhaseDeploy:
+		return lib.T_("System deployment")
+	case PhaseConfigure:
+		return lib.T_("System configuration")
+	case PhaseFinalize:
+		return lib.T_("Finalization")
+	}
+	return phase
+}
+
+// Journal — журнал установки: завершённые этапы и состояние, без которого продолжение потребовало бы
+// повторной разметки диска и загрузки образа.
+type Journal struct {
+	// Fingerprint — хэш параметров установки; журнал другой установки не используется
+	Fingerprint string   `json:"fingerprint"`
+	Phases      []string `json:"phases"`
+	// Plan и Mirrors — план разметки: при установке рядом он зависит от таблицы разделов до разметки
+	Plan    []plannedPartition   `json:"plan"`
+	Mirrors [][]plannedPartition `json:"mirrors,omitempty"`
+	// Partitions — UUID созданных разделов по путям; по ним проверяется, что диск не изменился
+	Partitions map[string]string `json:"partitions"`
+	// ImageDigest — дайджест образа в хранилище контейнеров; по нему проверяется, что образ не нужно загружать заново
+	ImageDigest   string   `json:"imageDigest,omitempty"`
+	SwapOffset    string   `json:"swapOffset,omitempty"`
+	TpmEnrolled   bool     `json:"tpmEnrolled,omitempty"`
+	HeaderBackups []string `json:"headerBackups,omitempty"`
+}
+
+// done сообщает, завершён ли этап.
+func (j *Journal) done(phase string) bool {
+	return slices.Contains(j.Phases, phase)
+}
+
+// nextPhase возвращает первый незавершённый этап.
+func (j *Journal) nextPhase() string {
+	for _, phase := range phases {
+		if !j.done(phase) {
+			return phase
+		}
+	}
+	return ""
+}
+
+// reset отмечает этап и все следующие за ним незавершёнными.
+func (j *Journal) reset(phase string) {
+	idx := slices.Index(phases, phase)
+	j.Phases = slices.DeleteFunc(j.Phases, func(done string) bool {
+		return slices.Index(phases, done) >= idx
+	})
+}
+
+// fingerprint возвращает хэш параметров установки без паролей: пароли не попадают в журнал,
+// а неверный пароль LUKS всё равно не даст открыть разделы при продолжении.
+func fingerprint(data InstallerData) string {
+	data.LuksPassword = ""
+	data.User.Password = ""
+	data.User.PasswordHash = ""
+	content, _ := json.Marshal(data)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// ResumePhase возвращает этап, с которого можно продолжить прерванную установку с теми же параметрами,
+// или пустую строку, если продолжать нечего.
+func (i *InstallerService) ResumePhase() string {
+	journal := i.loadJournal()
+	if journal == nil || !journal.done(PhaseDisk) {
+		return ""
+	}
+	return journal.nextPhase()
+}
+
+// SetResume выбирает, продолжить ли установку по журналу прошлой попытки или начать её заново.
+func (i *InstallerService) SetResume(resume bool) {
+	i.resume = resume
+}
+
+// loadJournal читает журнал прошлой попытки; nil, если журнала нет или он записан для других параметров установки.
+func (i *InstallerService) loadJournal() *Journal {
+	content, err := i.executor.ReadFile(journalPath)
+	if err != nil {
+		return nil
+	}
+	var journal Journal
+	if err = json.Unmarshal(content, &journal); err != nil {
+		lib.Log.Warningf("Журнал установки %s повреждён: %v", journalPath, err)
+		return nil
+	}
+	if journal.Fingerprint != fingerprint(i.data) {
+		lib.Log.Infof("Журнал установки %s записан для других параметров установки", journalPath)
+		return nil
+	}
+	return &journal
+}
+
+// saveJournal записывает журнал. Ключ восстановления в него не попадает: при продолжении он заменяется новым.
+func (i *InstallerService) saveJournal() error {
+	content, err := json.Marshal(i.journal)
+	if err != nil {
+		return err
+	}
+	if err = i.executor.MkdirAll(recoveryDir, 0700); err != nil {
+		return fmt.Errorf("ошибка создания каталога %s: %v", recoveryDir, err)
+	}
+	if err = i.executor.WriteFile(journalPath, content, 0600); err != nil {
+		return fmt.Errorf("ошибка записи журнала установки %s: %v", journalPath, err)
+	}
+	return nil
+}
+
+// removeJournal удаляет журнал прошлой попытки, если он есть.
+func (i *InstallerService) removeJournal() {
+	if _, err := i.executor.Stat(journalPath); err != nil {
+		return
+	}
+	if err := i.executor.RemoveAll(journalPath); err != nil {
+		lib.Log.Warningf("Журнал установки %s не удалён: %v", journalPath, err)
+	}
+}
+
+// completePhase отмечает этап завершённым. После подготовки диска журнал создаётся заново с планом разметки
+// и UUID разделов, после загрузки образа в него добавляется дайджест. После последнего этапа журнал удаляется:
+// продолжать больше нечего.
+func (i *InstallerService) completePhase(ctx context.Context, phase string) error {
+	switch phase {
+	case PhaseDisk:
+		i.journal = &Journal{
+			Fingerprint:   fingerprint(i.data),
+			Plan:          i.plan,
+			Mirrors:       i.mirrors,
+			Partitions:    i.partitionUUIDs(ctx),
+			SwapOffset:    i.swapOffset,
+			TpmEnrolled:   i.tpmEnrolled,
+			HeaderBackups: i.headerBackups,
+		}
+	case PhaseImage:
+		digest, err := i.imageDigest(ctx)
+		if err != nil {
+			return err
+		}
+		i.journal.ImageDigest = digest
+	case PhaseFinalize:
+		i.journal = nil
+		i.removeJournal()
+		return nil
+	}
+	i.journal.Phases = append(i.journal.Phases, phase)
+	return i.saveJournal()
+}
+
+// partitionUUIDs возвращает UUID разделов плана, включая разделы дополнительных дисков RAID1.
+// Разделы без файловой системы (bios_grub, подкачка со случайным ключом) пропускаются.
+func (i *InstallerService) partitionUUIDs(ctx context.Context) map[string]string {
+	uuids := make(map[string]string)
+	for _, plan := range append([][]plannedPartition{i.plan}, i.mirrors...) {
+		for _, part := range plan {
+			if part.Logical {
+				continue
+			}
+			if uuid := i.partitionUUID(ctx, part.Path); uuid != "" {
+				uuids[part.Path] = uuid
+			}
+		}
+	}
+	return uuids
+}
+
+// partitionUUID возвращает UUID раздела или пустую строку, если на разделе нет файловой системы.
+func (i *InstallerService) partitionUUID(ctx context.Context, path string) string {
+	output, err := i.output(ctx, "blkid", "-s", "UUID", "-o", "value", path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// restoreJournal восстанавливает состояние прерванной установки: план разметки, открытые разделы LUKS,
+// активную группу томов LVM и смонтированное хранилище контейнеров.
+// Если разделы изменились после прошлой попытки, продолжать нельзя.
+func (i *InstallerService) restoreJournal(ctx context.Context) error {
+	journal := i.journal
+	i.Status.SetStatus(StatusResuming)
+	lib.Log.Infof("Продолжение прерванной установки с этапа %s", journal.nextPhase())
+
+	i.plan = journal.Plan
+	i.mirrors = journal.Mirrors
+	i.swapOffset = journal.SwapOffset
+	i.tpmEnrolled = journal.TpmEnrolled
+	i.headerBackups = journal.HeaderBackups
+
+	for path, uuid := range journal.Partitions {
+		if current := i.partitionUUID(ctx, path); current != uuid {
+			return fmt.Errorf("раздел %s изменился после прерванной установки, установку нужно начать заново", path)
+		}
+	}
+
+	i.unmountInstallPaths(ctx)
+
+	partitions, err := i.getNamedPartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения разделов: %v", err)
+	}
+	if i.data.IsCryptoFilesystem {
+		for idx, device := range partitions[RoleRoot].devices() {
+			if err = i.openLuks(ctx, device, cryptName(idx)); err != nil {
+				return err
+			}
+		}
+		if i.encryptedSwap(i.plan) && !i.data.Swap.RandomKey {
+			if err = i.openLuks(ctx, partitions[RoleSwap].Path, cryptSwapName); err != nil {
+				return err
+			}
+		}
+	}
+	if i.data.Lvm.Enabled {
+		if err = i.run(ctx, "vgchange", "-ay", LvmVolumeGroup); err != nil {
+			return fmt.Errorf("ошибка активации группы томов %s: %v", LvmVolumeGroup, err)
+		}
+		i.pushLvmDeactivate()
+	}
+	if i.data.Partitioning.Raid() {
+		if err = i.run(ctx, "btrfs", "device", "scan"); err != nil {
+			return fmt.Errorf("ошибка поиска устройств btrfs: %v", err)
+		}
+	}
+
+	if partitions, err = i.getNamedPartitionsWithCrypto(ctx); err != nil {
+		return fmt.Errorf("ошибка получения разделов: %v", err)
+	}
+	if i.data.IsCryptoFilesystem && i.data.RecoveryKey {
+		if err = i.replaceRecoveryKey(ctx, partitions); err != nil {
+			return err
+		}
+	}
+	if err = i.mountContainerStorage(ctx, i.plan, partitions); err != nil {
+		return err
+	}
+
+	// Образ мог быть удалён из хранилища или загружен не полностью
+	if journal.done(PhaseImage) {
+		if digest, err := i.imageDigest(ctx); err != nil || digest != journal.ImageDigest {
+			lib.Log.Warningf("Образ %s в хранилище контейнеров отличается от загруженного, он будет загружен заново", i.data.Image)
+			i.Status.Warn(fmt.Sprintf(lib.T_("Image %s will be downloaded again"), i.data.Image))
+			journal.reset(PhaseImage)
+		}
+	}
+	i.redeploy = !journal.done(PhaseDeploy)
+	return nil
+}
+
+// openLuks открывает раздел LUKS, если он не остался открытым после прошлой попытки.
+func (i *InstallerService) openLuks(ctx context.Context, device, name string) error {
+	if _, err := i.executor.Stat("/dev/mapper/" + name); err == nil {
+		i.pushLuksClose(name)
+		return nil
+	}
+	if err := i.luksOpen(ctx, device, name); err != nil {
+		return fmt.Errorf("ошибка открытия LUKS раздела %s: %v", device, err)
+	}
+	return nil
+}
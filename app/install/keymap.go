@@ -0,0 +1,105 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"installer/lib"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultKeymap — раскладка консоли, если другая не выбрана и не найдена в системе.
+const DefaultKeymap = "us"
+
+// Keymaps — раскладки консоли (имена kbd), предлагаемые в интерфейсе.
+var Keymaps = []string{"us", "ru", "ua", "by", "uk", "de", "fr"}
+
+var keymapPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// KeymapTitle возвращает название раскладки для интерфейса.
+func KeymapTitle(keymap string) string {
+	switch keymap {
+	case "us":
+		return lib.T_("English (US)")
+	case "ru":
+		return lib.T_("Russian")
+	case "ua":
+		return lib.T_("Ukrainian")
+	case "by":
+		return lib.T_("Belarusian")
+	case "uk":
+		return lib.T_("English (UK)")
+	case "de":
+		return lib.T_("German (QWERTZ)")
+	case "fr":
+		return lib.T_("French (AZERTY)")
+	}
+	return keymap
+}
+
+// ValidateKeymap проверяет имя раскладки: оно попадает в параметры ядра и vconsole.conf.
+func ValidateKeymap(keymap string) error {
+	if keymap != "" && !keymapPattern.MatchString(keymap) {
+		return fmt.Errorf("некорректное имя раскладки %q", keymap)
+	}
+	return nil
+}
+
+// DetectKeymap возвращает раскладку консоли установочной системы из /etc/vconsole.conf.
+// Если она не задана, раскладка выбирается по языку: пароль, набранный в живой сессии,
+// должен набираться так же и в initrd.
+func DetectKeymap(lang string) string {
+	if content, err := os.ReadFile("/etc/vconsole.conf"); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "KEYMAP=")
+			if value = strings.Trim(value, `"'`); ok && value != "" {
+				return value
+			}
+		}
+	}
+	if lang == "ru" {
+		return "ru"
+	}
+	return DefaultKeymap
+}
+
+// keymapKargs возвращает параметр ядра с раскладкой для запроса пароля LUKS в initrd.
+func (i *InstallerService) keymapKargs() []string {
+	if i.data.Keymap == "" {
+		return nil
+	}
+	return []string{"--karg=rd.vconsole.keymap=" + i.data.Keymap}
+}
+
+// configureKeymap записывает раскладку консоли в /etc/vconsole.conf развёртывания.
+func (i *InstallerService) configureKeymap(ostreeDeployPath string) error {
+	if i.data.Keymap == "" {
+		return nil
+	}
+	configPath := filepath.Join(ostreeDeployPath, "etc/vconsole.conf")
+	content := fmt.Sprintf("KEYMAP=%s\n", i.data.Keymap)
+	if err := i.executor.WriteFile(configPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("ошибка записи %s: %v", configPath, err)
+	}
+	return nil
+}
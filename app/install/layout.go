@@ -0,0 +1,544 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"fmt"
+	"installer/lib"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Роли разделов в схеме разметки
+const (
+	RoleBiosGrub = "bios_grub"
+	RoleESP      = "esp"
+	RoleBoot     = "boot"
+	RoleRoot     = "root"
+	RoleTemp     = "temp"
+	RoleSwap     = "swap"
+	// RoleVar и RoleHome назначаются только при ручной разметке
+	RoleVar  = "var"
+	RoleHome = "home"
+)
+
+// SizeRest — размер раздела, занимающего всё оставшееся место на диске.
+const SizeRest = "rest"
+
+// Layout — схема разметки диска: разделы в порядке их создания.
+type Layout []lib.PartitionSpec
+
+// minTempMiB — минимальный размер временного раздела, в который загружается образ.
+const minTempMiB = 10 * 1024
+
+// builtinLayouts используются, если в конфигурации не заданы layouts.
+// Хранилище контейнеров размещается на корневом разделе: в подтоме @install-containers на btrfs
+// и в каталоге .install-containers на ext4 и xfs, поэтому временный раздел не нужен.
+var builtinLayouts = map[string]Layout{
+	"UEFI": {
+		{Role: RoleESP, Size: "600MiB", Filesystem: "vfat", Flags: []string{"boot"}},
+		{Role: RoleBoot, Size: "2GiB", Filesystem: "ext4"},
+		{Role: RoleRoot, Size: SizeRest},
+	},
+	"LEGACY": {
+		{Role: RoleBiosGrub, Size: "2MiB", Flags: []string{"bios_grub"}},
+		{Role: RoleESP, Size: "1000MiB", Filesystem: "vfat", Flags: []string{"boot"}},
+		{Role: RoleBoot, Size: "2GiB", Filesystem: "ext4"},
+		{Role: RoleRoot, Size: SizeRest},
+	},
+}
+
+// filesystemCommands — утилиты, которые нужны только для выбранной файловой системы root.
+// Общие для всех установок команды проверяются при запуске установщика.
+var filesystemCommands = map[string][]string{
+	"xfs": {"mkfs.xfs", "xfs_growfs"},
+}
+
+// CheckFilesystemCommands проверяет, что в системе есть утилиты для создания и расширения файловой системы root.
+func CheckFilesystemCommands(filesystem string) error {
+	for _, cmd := range filesystemCommands[filesystem] {
+		if _, err := exec.LookPath(cmd); err != nil {
+			return fmt.Errorf(lib.T_("Command %s is required for %s but was not found"), cmd, filesystem)
+		}
+	}
+	return nil
+}
+
+// DefaultLayout возвращает схему разметки для режима загрузки и файловой системы root.
+// Сначала ищется схема "<режим>-<файловая система>" (например, "UEFI-ext4"), затем схема режима загрузки;
+// схемы из конфигурации имеют приоритет над встроенными.
+func DefaultLayout(bootMode, filesystem string) (Layout, error) {
+	for _, key := range []string{bootMode + "-" + filesystem, bootMode} {
+		if layout := lib.Env.Layouts[key]; len(layout) > 0 {
+			return layout, nil
+		}
+		if layout, ok := builtinLayouts[key]; ok {
+			return layout, nil
+		}
+	}
+	return nil, fmt.Errorf("неизвестный тип загрузки: %s", bootMode)
+}
+
+// Validate проверяет, что схема содержит обязательные разделы и корректные размеры.
+func (l Layout) Validate(bootMode string) error {
+	count := make(map[string]int)
+	rest := 0
+	for idx, part := range l {
+		switch part.Role {
+		case RoleBiosGrub, RoleESP, RoleBoot, RoleRoot, RoleTemp, RoleSwap:
+		default:
+			return fmt.Errorf("раздел %d: неизвестная роль %q", idx+1, part.Role)
+		}
+		count[part.Role]++
+		if count[part.Role] > 1 {
+			return fmt.Errorf("роль %s указана в схеме несколько раз", part.Role)
+		}
+
+		if part.Size == SizeRest {
+			rest++
+		} else if _, _, err := parseLayoutSize(part.Size); err != nil {
+			return fmt.Errorf("раздел %s: %v", part.Role, err)
+		}
+	}
+
+	if rest > 1 {
+		return fmt.Errorf("размер %q может быть указан только у одного раздела", SizeRest)
+	}
+
+	required := []string{RoleESP, RoleBoot, RoleRoot}
+	if bootMode == "LEGACY" {
+		required = append(required, RoleBiosGrub)
+	}
+	for _, role := range required {
+		if count[role] == 0 {
+			return fmt.Errorf("в схеме разметки отсутствует раздел %s", role)
+		}
+	}
+	return nil
+}
+
+// plannedPartition — раздел схемы с вычисленным номером, путём и положением на диске.
+type plannedPartition struct {
+	lib.PartitionSpec
+	Number   int
+	Path     string
+	StartMiB int64
+	// EndMiB равен 0 у последнего раздела размера rest: он занимает диск до конца
+	EndMiB int64
+	// Existing — раздел уже есть на диске (например, ESP другой системы): он не создаётся и не форматируется
+	Existing bool
+	// Reformat — существующий раздел форматируется заново (ручная разметка)
+	Reformat bool
+	// Logical — логический том LVM, а не раздел диска: он создаётся в группе томов на разделе root
+	Logical bool
+}
+
+// layoutStartMiB — отступ первого раздела от начала диска.
+const layoutStartMiB = 1
+
+// Plan вычисляет номера разделов и их границы для диска размером diskMiB.
+// Файловая система root берётся из rootFS, если в схеме она не задана.
+func (l Layout) Plan(disk string, diskMiB int64, rootFS string) ([]plannedPartition, error) {
+	// Последний MiB диска занимает резервная копия GPT
+	usable := diskMiB - layoutStartMiB - 1
+
+	sizes := make([]int64, len(l))
+	restIdx := -1
+	var total int64
+	for idx, part := range l {
+		if part.Size == SizeRest {
+			restIdx = idx
+			continue
+		}
+		value, percent, err := parseLayoutSize(part.Size)
+		if err != nil {
+			return nil, fmt.Errorf("раздел %s: %v", part.Role, err)
+		}
+		if percent {
+			value = usable * value / 100
+		}
+		sizes[idx] = value
+		total += value
+	}
+
+	if restIdx >= 0 {
+		sizes[restIdx] = usable - total
+		if sizes[restIdx] <= 0 {
+			return nil, fmt.Errorf("на диске %s не осталось места для раздела %s", disk, l[restIdx].Role)
+		}
+	} else if total > usable {
+		return nil, fmt.Errorf("схема разметки занимает %d MiB, а на диске %s доступно %d MiB", total, disk, usable)
+	}
+
+	for idx, part := range l {
+		if part.Role == RoleTemp && sizes[idx] < minTempMiB {
+			return nil, fmt.Errorf("временному разделу нужно не менее %d MiB, а на диске %s для него остаётся %d MiB",
+				minTempMiB, disk, sizes[idx])
+		}
+	}
+
+	planned := make([]plannedPartition, len(l))
+	start := int64(layoutStartMiB)
+	for idx, part := range l {
+		if part.Role == RoleRoot && part.Filesystem == "" {
+			part.Filesystem = rootFS
+		}
+		planned[idx] = plannedPartition{
+			PartitionSpec: part,
+			Number:        idx + 1,
+			Path:          partitionPath(disk, idx+1),
+			StartMiB:      start,
+			EndMiB:        start + sizes[idx],
+		}
+		start += sizes[idx]
+	}
+	if restIdx == len(l)-1 {
+		planned[restIdx].EndMiB = 0
+	}
+	return planned, nil
+}
+
+// parseLayoutSize разбирает размер раздела. Для процентов возвращает число процентов и percent=true,
+// иначе — размер в MiB.
+func parseLayoutSize(size string) (value int64, percent bool, err error) {
+	units := []struct {
+		suffix string
+		mib    int64
+	}{
+		{"%", 0},
+		{"TiB", 1024 * 1024},
+		{"GiB", 1024},
+		{"MiB", 1},
+		{"T", 1024 * 1024},
+		{"G", 1024},
+		{"M", 1},
+	}
+	for _, unit := range units {
+		if !strings.HasSuffix(size, unit.suffix) {
+			continue
+		}
+		number, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(size, unit.suffix)), 10, 64)
+		if err != nil || number <= 0 {
+			return 0, false, fmt.Errorf("некорректный размер %q", size)
+		}
+		if unit.suffix == "%" {
+			if number > 100 {
+				return 0, false, fmt.Errorf("некорректный размер %q", size)
+			}
+			return number, true, nil
+		}
+		return number * unit.mib, false, nil
+	}
+	return 0, false, fmt.Errorf("некорректный размер %q: ожидается MiB, GiB, TiB, %% или %s", size, SizeRest)
+}
+
+// rootIsLast сообщает, что за разделом root в плане следует только временный раздел,
+// то есть после его удаления root можно расширить до конца диска.
+func rootIsLast(planned []plannedPartition) bool {
+	for idx, part := range planned {
+		if part.Role != RoleRoot {
+			continue
+		}
+		for _, next := range planned[idx+1:] {
+			if next.Role != RoleTemp && !next.Logical {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// hasRole сообщает, есть ли в плане раздел с указанной ролью.
+func hasRole(planned []plannedPartition, role string) bool {
+	for _, part := range planned {
+		if part.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// partedFsType возвращает тип файловой системы в терминах parted.
+func partedFsType(filesystem string) string {
+	switch filesystem {
+	case "vfat":
+		return "fat32"
+	case "swap":
+		return "linux-swap"
+	}
+	return filesystem
+}
+
+// partedCommands возвращает команды разметки по плану. При wipe диск очищается и получает новую таблицу GPT,
+// иначе создаются только новые разделы плана.
+func partedCommands(disk string, planned []plannedPartition, wipe bool) [][]string {
+	var commands [][]string
+	if wipe {
+		commands = append(commands,
+			[]string{"wipefs", "--all", disk},
+			[]string{"parted", "-s", disk, "mklabel", "gpt"},
+		)
+	}
+	for _, part := range planned {
+		if part.Logical {
+			continue
+		}
+		if part.Existing {
+			// Флаги нужны и заново форматируемому разделу, например ESP, назначенному вручную
+			if part.Reformat {
+				for _, flag := range part.Flags {
+					commands = append(commands, []string{"parted", "-s", disk, "set", strconv.Itoa(part.Number), flag, "on"})
+				}
+			}
+			continue
+		}
+		end := "100%"
+		if part.EndMiB != 0 {
+			end = fmt.Sprintf("%dMiB", part.EndMiB)
+		}
+
+		mkpart := []string{"parted", "-s", disk, "mkpart", "primary"}
+		if fsType := partedFsType(part.Filesystem); fsType != "" {
+			mkpart = append(mkpart, fsType)
+		}
+		commands = append(commands, append(mkpart, fmt.Sprintf("%dMiB", part.StartMiB), end))
+
+		for _, flag := range part.Flags {
+			commands = append(commands, []string{"parted", "-s", disk, "set", strconv.Itoa(part.Number), flag, "on"})
+		}
+	}
+	return commands
+}
+
+// formatCommand возвращает команду создания файловой системы на устройстве.
+func formatCommand(filesystem, device string) ([]string, error) {
+	switch filesystem {
+	case "vfat":
+		return []string{"mkfs.vfat", "-F32", device}, nil
+	case "ext4":
+		return []string{"mkfs.ext4", device}, nil
+	case "btrfs":
+		return []string{"mkfs.btrfs", "-f", device}, nil
+	case "xfs":
+		return []string{"mkfs.xfs", "-f", device}, nil
+	case "swap":
+		return []string{"mkswap", device}, nil
+	case "":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("неизвестная файловая система: %s", filesystem)
+}
+
+// fstabEntry — строка /etc/fstab без устройства.
+type fstabEntry struct {
+	Role       string
+	MountPoint string
+	Filesystem string
+	Options    string
+	Dump, Pass int
+}
+
+// line возвращает строку fstab для устройства, заданного как UUID=... или путём.
+func (e fstabEntry) line(device string) string {
+	return fmt.Sprintf("%s %s %s %s %d %d\n", device, e.MountPoint, e.Filesystem, e.Options, e.Dump, e.Pass)
+}
+
+// fsckPass возвращает порядок проверки файловой системы при загрузке. fsck.xfs ничего не проверяет,
+// xfs восстанавливается по журналу при монтировании, поэтому для неё проверка отключается.
+func fsckPass(filesystem string, pass int) int {
+	if filesystem == "xfs" {
+		return 0
+	}
+	return pass
+}
+
+// fstabEntries возвращает записи fstab для разделов плана и подтомов корневой btrfs.
+// Разделы bios_grub и temp не монтируются.
+func fstabEntries(planned []plannedPartition, subVolumes []lib.SubVolumeSpec) []fstabEntry {
+	var entries []fstabEntry
+	for _, part := range planned {
+		switch part.Role {
+		case RoleRoot:
+			if part.Filesystem == "btrfs" {
+				for _, subVol := range rootSubVolumes(subVolumes, planned) {
+					entries = append(entries, fstabEntry{
+						Role:       part.Role,
+						MountPoint: subVol.MountPoint,
+						Filesystem: "btrfs",
+						Options:    subVolumeMountOptions(subVol),
+					})
+				}
+			} else {
+				entries = append(entries, fstabEntry{Role: part.Role, MountPoint: "/", Filesystem: part.Filesystem, Options: "defaults", Dump: 1, Pass: fsckPass(part.Filesystem, 1)})
+			}
+		case RoleBoot:
+			entries = append(entries, fstabEntry{Role: part.Role, MountPoint: "/boot", Filesystem: part.Filesystem, Options: "defaults", Dump: 1, Pass: 2})
+		case RoleESP:
+			entries = append(entries, fstabEntry{Role: part.Role, MountPoint: "/boot/efi", Filesystem: "vfat", Options: "umask=0077,shortname=winnt", Pass: 2})
+		case RoleVar, RoleHome:
+			entries = append(entries, fstabEntry{Role: part.Role, MountPoint: "/" + part.Role, Filesystem: part.Filesystem, Options: "defaults", Pass: fsckPass(part.Filesystem, 2)})
+		case RoleSwap:
+			entries = append(entries, fstabEntry{Role: part.Role, MountPoint: "none", Filesystem: "swap", Options: "defaults"})
+		}
+	}
+
+	// Точки монтирования упорядочиваются так, чтобы родительские каталоги шли раньше вложенных
+	sort.SliceStable(entries, func(a, b int) bool {
+		if entries[a].MountPoint == "none" || entries[b].MountPoint == "none" {
+			return entries[b].MountPoint == "none" && entries[a].MountPoint != "none"
+		}
+		return entries[a].MountPoint < entries[b].MountPoint
+	})
+	return entries
+}
+
+// partitionPlan возвращает план разметки установочного диска. План вычисляется один раз:
+// по нему создаются разделы, строится карта именованных разделов и генерируется fstab.
+// Планы дополнительных дисков RAID1 сохраняются в i.mirrors.
+func (i *InstallerService) partitionPlan(ctx context.Context) ([]plannedPartition, error) {
+	if i.plan != nil {
+		return i.plan, nil
+	}
+	if i.data.Partitioning.Raid() && !i.data.Partitioning.Erase() {
+		return nil, fmt.Errorf("RAID1 поддерживается только при очистке дисков")
+	}
+	if i.data.TypeFilesystem == "btrfs" {
+		if err := ValidateSubVolumes(i.subVolumeLayout()); err != nil {
+			return nil, fmt.Errorf("ошибка схемы подтомов: %v", err)
+		}
+	} else if i.data.Snapshots {
+		return nil, fmt.Errorf("снимки поддерживаются только для btrfs")
+	}
+	if err := i.data.Swap.Validate(i.data.TypeFilesystem, i.data.Partitioning); err != nil {
+		return nil, err
+	}
+	if err := i.data.Tpm.Validate(); err != nil {
+		return nil, err
+	}
+	if err := i.data.Luks.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ValidateKeymap(i.data.Keymap); err != nil {
+		return nil, err
+	}
+	if err := i.data.Partitioning.validateWipe(); err != nil {
+		return nil, err
+	}
+
+	// При ручной разметке план задаётся назначением ролей существующим разделам, схема не используется
+	if i.data.Partitioning.Manual() {
+		table, err := i.diskTable(ctx)
+		if err != nil {
+			return nil, err
+		}
+		plan, err := PlanManual(table, i.data.Partitioning.Assignments, i.data.TypeBoot, i.data.TypeFilesystem)
+		if err != nil {
+			return nil, err
+		}
+		i.plan = plan
+		return plan, nil
+	}
+
+	layout := Layout(i.data.Layout)
+	if len(layout) == 0 {
+		var err error
+		if layout, err = DefaultLayout(i.data.TypeBoot, i.data.TypeFilesystem); err != nil {
+			return nil, err
+		}
+	}
+	layout, err := i.withSwapPartition(layout)
+	if err != nil {
+		return nil, err
+	}
+	if err := layout.Validate(i.data.TypeBoot); err != nil {
+		return nil, fmt.Errorf("ошибка схемы разметки: %v", err)
+	}
+
+	var plan []plannedPartition
+	if i.data.Partitioning.Alongside() {
+		table, err := i.diskTable(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if plan, err = layout.PlanAlongside(table, i.data.Partitioning, i.data.TypeFilesystem); err != nil {
+			return nil, err
+		}
+	} else {
+		diskMiB, err := i.diskSizeMiB(ctx, i.data.Disk)
+		if err != nil {
+			return nil, err
+		}
+		if plan, err = layout.Plan(i.data.Disk, diskMiB, i.data.TypeFilesystem); err != nil {
+			return nil, err
+		}
+		if i.data.Lvm.Enabled {
+			if err = i.validateLvm(layout); err != nil {
+				return nil, err
+			}
+			plan = append(plan, i.data.Lvm.logicalVolumes(i.data.TypeFilesystem)...)
+		}
+		if i.data.Partitioning.Raid() {
+			if err = i.validateRaid(layout); err != nil {
+				return nil, err
+			}
+			if i.mirrors, err = i.planMirrors(ctx, layout); err != nil {
+				return nil, err
+			}
+			// /boot тоже объединяется в btrfs RAID1, чтобы ядра были доступны с любого диска
+			for idx := range plan {
+				if plan[idx].Role == RoleBoot {
+					plan[idx].Filesystem = "btrfs"
+				}
+			}
+		}
+	}
+	// Раздел подкачки со случайным ключом форматируется systemd-cryptsetup при каждой загрузке
+	if i.data.IsCryptoFilesystem && i.data.Swap.RandomKey {
+		for idx := range plan {
+			if plan[idx].Role == RoleSwap && !plan[idx].Logical {
+				plan[idx].Filesystem = ""
+			}
+		}
+	}
+	i.plan = plan
+	return plan, nil
+}
+
+// diskSizeMiB возвращает размер диска в MiB.
+func (i *InstallerService) diskSizeMiB(ctx context.Context, disk string) (int64, error) {
+	output, err := i.output(ctx, "lsblk", "-b", "-d", "-n", "-o", "SIZE", disk)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка определения размера диска %s: %v", disk, err)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка определения размера диска %s: %v", disk, err)
+	}
+	return size / (1024 * 1024), nil
+}
+
+// partitionPath возвращает имя раздела диска: /dev/sda + 1 = /dev/sda1, /dev/nvme0n1 + 1 = /dev/nvme0n1p1.
+func partitionPath(disk string, number int) string {
+	if last := disk[len(disk)-1]; last >= '0' && last <= '9' {
+		return fmt.Sprintf("%sp%d", disk, number)
+	}
+	return fmt.Sprintf("%s%d", disk, number)
+}
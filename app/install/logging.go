@@ -0,0 +1,94 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"errors"
+	"installer/lib"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LoggingExecutor — обёртка над исполнителем, которая пишет в журнал каждую команду,
+// время её выполнения и код возврата. Операции с файлами передаются без изменений.
+type LoggingExecutor struct {
+	Executor
+}
+
+// NewLoggingExecutor — конструктор журналирующей обёртки над исполнителем
+func NewLoggingExecutor(next Executor) *LoggingExecutor {
+	return &LoggingExecutor{Executor: next}
+}
+
+func (e *LoggingExecutor) Run(ctx context.Context, cmd Command) error {
+	start := time.Now()
+	err := e.Executor.Run(ctx, cmd)
+	logCommand(cmd, start, err, logrus.InfoLevel)
+	return err
+}
+
+// Output журналирует запросы на уровне debug: lsblk, blkid и mountpoint вызываются часто,
+// а ненулевой код возврата mountpoint — обычный ответ «не примонтировано».
+func (e *LoggingExecutor) Output(ctx context.Context, cmd Command) ([]byte, error) {
+	start := time.Now()
+	out, err := e.Executor.Output(ctx, cmd)
+	logCommand(cmd, start, err, logrus.DebugLevel)
+	return out, err
+}
+
+func (e *LoggingExecutor) RunPTY(ctx context.Context, cmd Command, onLine func(line string)) error {
+	start := time.Now()
+	err := e.Executor.RunPTY(ctx, cmd, onLine)
+	logCommand(cmd, start, err, logrus.InfoLevel)
+	return err
+}
+
+// logCommand записывает строку команды с длительностью и кодом возврата.
+// Неудачные команды из Run и RunPTY пишутся как ошибки вместе с stderr, если он был перехвачен.
+func logCommand(cmd Command, start time.Time, err error, level logrus.Level) {
+	entry := lib.Log.WithFields(logrus.Fields{
+		"duration":  time.Since(start).Round(time.Millisecond).String(),
+		"exit_code": exitCode(err),
+	})
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		entry = entry.WithField("stderr", strings.TrimSpace(string(exitErr.Stderr)))
+	}
+
+	if err != nil && level == logrus.InfoLevel {
+		level = logrus.ErrorLevel
+	}
+	entry.Logf(level, "$ %s", cmd)
+}
+
+// exitCode возвращает код завершения команды: 0 при успехе, -1 если процесс не удалось запустить
+// или он был прерван сигналом.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
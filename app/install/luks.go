@@ -0,0 +1,156 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Варианты PBKDF, принимаемые cryptsetup
+const (
+	PbkdfArgon2id = "argon2id"
+	PbkdfArgon2i  = "argon2i"
+	PbkdfPbkdf2   = "pbkdf2"
+)
+
+// LuksPbkdfs — варианты PBKDF для выбора в интерфейсе.
+var LuksPbkdfs = []string{PbkdfArgon2id, PbkdfArgon2i, PbkdfPbkdf2}
+
+// LuksCiphers — шифры, предлагаемые в экспертных настройках.
+var LuksCiphers = []string{"aes-xts-plain64", "serpent-xts-plain64", "twofish-xts-plain64"}
+
+// LuksSectorSizes — допустимые размеры сектора LUKS2 в байтах.
+var LuksSectorSizes = []int{512, 1024, 2048, 4096}
+
+// LuksOptions — параметры luksFormat. Нулевые значения оставляют выбор за cryptsetup.
+type LuksOptions struct {
+	// Cipher — шифр в формате cryptsetup, например aes-xts-plain64
+	Cipher string
+	// KeySize — размер ключа в битах; для XTS ключ делится пополам, поэтому 512 соответствует AES-256
+	KeySize int
+	// Pbkdf — функция получения ключа из пароля: argon2id, argon2i или pbkdf2
+	Pbkdf string
+	// PbkdfMemory — память argon2 в КиБ
+	PbkdfMemory int
+	// PbkdfParallel — число потоков argon2
+	PbkdfParallel int
+	// IterTime — время вычисления ключа при разблокировке в миллисекундах
+	IterTime int
+	// SectorSize — размер сектора шифрования в байтах
+	SectorSize int
+}
+
+var cipherPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)+$`)
+
+// argon2 сообщает, используется ли argon2: он выбирается cryptsetup для LUKS2 по умолчанию.
+func (o LuksOptions) argon2() bool {
+	return o.Pbkdf != PbkdfPbkdf2
+}
+
+// Validate проверяет параметры до начала установки, чтобы luksFormat не упал на уже очищенном диске.
+func (o LuksOptions) Validate() error {
+	if o.Cipher != "" && !cipherPattern.MatchString(o.Cipher) {
+		return fmt.Errorf("LUKS: некорректный шифр %q, ожидается формат вида aes-xts-plain64", o.Cipher)
+	}
+	if o.KeySize != 0 {
+		if o.KeySize < 128 || o.KeySize > 1024 || o.KeySize%8 != 0 {
+			return fmt.Errorf("LUKS: размер ключа %d должен быть кратен 8 и лежать в диапазоне 128–1024 бит", o.KeySize)
+		}
+		// Шифр cryptsetup по умолчанию для LUKS2 — aes-xts-plain64
+		isXts := o.Cipher == "" || strings.Contains(o.Cipher, "-xts-")
+		if isXts && o.KeySize != 256 && o.KeySize != 512 {
+			return fmt.Errorf("LUKS: для режима XTS размер ключа должен быть 256 или 512 бит")
+		}
+	}
+	if o.Pbkdf != "" && !slices.Contains(LuksPbkdfs, o.Pbkdf) {
+		return fmt.Errorf("LUKS: неизвестный PBKDF %q, допустимы: %s", o.Pbkdf, strings.Join(LuksPbkdfs, ", "))
+	}
+	if !o.argon2() && (o.PbkdfMemory != 0 || o.PbkdfParallel != 0) {
+		return fmt.Errorf("LUKS: память и число потоков задаются только для argon2")
+	}
+	if o.PbkdfMemory != 0 && (o.PbkdfMemory < 32 || o.PbkdfMemory > 4*1024*1024) {
+		return fmt.Errorf("LUKS: память PBKDF %d КиБ вне диапазона 32–4194304", o.PbkdfMemory)
+	}
+	if o.PbkdfParallel < 0 || o.PbkdfParallel > 32 {
+		return fmt.Errorf("LUKS: число потоков PBKDF %d вне диапазона 1–32", o.PbkdfParallel)
+	}
+	if o.IterTime < 0 || o.IterTime > 60000 {
+		return fmt.Errorf("LUKS: время PBKDF %d мс вне диапазона 1–60000", o.IterTime)
+	}
+	if o.SectorSize != 0 && !slices.Contains(LuksSectorSizes, o.SectorSize) {
+		return fmt.Errorf("LUKS: размер сектора %d не поддерживается, допустимы 512, 1024, 2048 и 4096", o.SectorSize)
+	}
+	return nil
+}
+
+// formatArgs возвращает аргументы cryptsetup luksFormat для устройства.
+func (o LuksOptions) formatArgs(device string) []string {
+	args := []string{"luksFormat", "--type", "luks2", "--batch-mode", "--force-password"}
+	if o.Cipher != "" {
+		args = append(args, "--cipher", o.Cipher)
+	}
+	if o.KeySize != 0 {
+		args = append(args, "--key-size", strconv.Itoa(o.KeySize))
+	}
+	if o.Pbkdf != "" {
+		args = append(args, "--pbkdf", o.Pbkdf)
+	}
+	if o.PbkdfMemory != 0 {
+		args = append(args, "--pbkdf-memory", strconv.Itoa(o.PbkdfMemory))
+	}
+	if o.PbkdfParallel != 0 {
+		args = append(args, "--pbkdf-parallel", strconv.Itoa(o.PbkdfParallel))
+	}
+	if o.IterTime != 0 {
+		args = append(args, "--iter-time", strconv.Itoa(o.IterTime))
+	}
+	if o.SectorSize != 0 {
+		args = append(args, "--sector-size", strconv.Itoa(o.SectorSize))
+	}
+	return append(args, device)
+}
+
+// Summary возвращает изменённые параметры для сводки; пустая строка означает параметры cryptsetup по умолчанию.
+func (o LuksOptions) Summary() string {
+	var parts []string
+	if o.Cipher != "" {
+		parts = append(parts, o.Cipher)
+	}
+	if o.KeySize != 0 {
+		parts = append(parts, fmt.Sprintf("%d bit", o.KeySize))
+	}
+	if o.Pbkdf != "" {
+		parts = append(parts, o.Pbkdf)
+	}
+	if o.PbkdfMemory != 0 {
+		parts = append(parts, fmt.Sprintf("%d MiB", o.PbkdfMemory/1024))
+	}
+	if o.PbkdfParallel != 0 {
+		parts = append(parts, fmt.Sprintf("%d threads", o.PbkdfParallel))
+	}
+	if o.IterTime != 0 {
+		parts = append(parts, fmt.Sprintf("%d ms", o.IterTime))
+	}
+	if o.SectorSize != 0 {
+		parts = append(parts, fmt.Sprintf("sector %d", o.SectorSize))
+	}
+	return strings.Join(parts, ", ")
+}
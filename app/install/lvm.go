@@ -0,0 +1,229 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"fmt"
+	"installer/lib"
+	"slices"
+	"strings"
+)
+
+// LvmVolumeGroup — имя группы томов, создаваемой на разделе root.
+const LvmVolumeGroup = "atomic"
+
+// LvmOptions — размещение root в LVM.
+type LvmOptions struct {
+	Enabled bool
+	// VarSize, HomeSize и SwapSize — размеры отдельных логических томов в формате схемы разметки
+	// (например, "20GiB" или "30%"); при пустом размере том не создаётся
+	VarSize  string
+	HomeSize string
+	SwapSize string
+}
+
+// lvPath возвращает путь к логическому тому группы атомарной системы.
+func lvPath(name string) string {
+	return fmt.Sprintf("/dev/%s/%s", LvmVolumeGroup, name)
+}
+
+// logicalVolumes возвращает дополнительные логические тома в порядке создания. Том root не входит в список:
+// он создаётся последним и занимает всё оставшееся место группы.
+func (o LvmOptions) logicalVolumes(rootFS string) []plannedPartition {
+	var volumes []plannedPartition
+	for _, lv := range []struct{ role, size, filesystem string }{
+		{RoleVar, o.VarSize, rootFS},
+		{RoleHome, o.HomeSize, rootFS},
+		{RoleSwap, o.SwapSize, "swap"},
+	} {
+		if lv.size == "" {
+			continue
+		}
+		volumes = append(volumes, plannedPartition{
+			PartitionSpec: lib.PartitionSpec{Role: lv.role, Size: lv.size, Filesystem: lv.filesystem},
+			Path:          lvPath(lv.role),
+			Logical:       true,
+		})
+	}
+	return volumes
+}
+
+// validateLvm проверяет параметры LVM и их совместимость со способом разметки.
+func (i *InstallerService) validateLvm(layout Layout) error {
+	if i.data.Partitioning.Manual() || i.data.Partitioning.Raid() {
+		return fmt.Errorf("LVM не поддерживается вместе с ручной разметкой и RAID1")
+	}
+	for _, part := range layout {
+		if part.Role == RoleSwap && i.data.Lvm.SwapSize != "" {
+			return fmt.Errorf("схема разметки уже содержит раздел %s, логический том swap не нужен", RoleSwap)
+		}
+	}
+	return i.data.Lvm.Validate()
+}
+
+// Summary возвращает описание логических томов для сводки, например "/var 20GiB, swap 8GiB".
+func (o LvmOptions) Summary() string {
+	var volumes []string
+	for _, lv := range []struct{ title, size string }{
+		{"/var", o.VarSize},
+		{"/home", o.HomeSize},
+		{"swap", o.SwapSize},
+	} {
+		if lv.size != "" {
+			volumes = append(volumes, lv.title+" "+lv.size)
+		}
+	}
+	if len(volumes) == 0 {
+		return lib.T_("Root only")
+	}
+	return strings.Join(volumes, ", ")
+}
+
+// Validate проверяет размеры логических томов.
+func (o LvmOptions) Validate() error {
+	for _, size := range []string{o.VarSize, o.HomeSize, o.SwapSize} {
+		if size == "" {
+			continue
+		}
+		if _, _, err := parseLayoutSize(size); err != nil {
+			return fmt.Errorf("LVM: %v", err)
+		}
+	}
+	return nil
+}
+
+// lvmCommands возвращает команды создания физического тома на pv, группы томов и логических томов плана.
+func lvmCommands(pv string, planned []plannedPartition) ([][]string, error) {
+	commands := [][]string{
+		{"pvcreate", "-ff", "-y", pv},
+		{"vgcreate", LvmVolumeGroup, pv},
+	}
+	for _, part := range planned {
+		if !part.Logical {
+			continue
+		}
+		value, percent, err := parseLayoutSize(part.Size)
+		if err != nil {
+			return nil, fmt.Errorf("логический том %s: %v", part.Role, err)
+		}
+		size := []string{"-L", fmt.Sprintf("%dm", value)}
+		if percent {
+			size = []string{"-l", fmt.Sprintf("%d%%VG", value)}
+		}
+		commands = append(commands, append([]string{"lvcreate", "-y", "-n", part.Role}, append(size, LvmVolumeGroup)...))
+	}
+	commands = append(commands, []string{"lvcreate", "-y", "-n", RoleRoot, "-l", "100%FREE", LvmVolumeGroup})
+	return commands, nil
+}
+
+// setupLvm создаёт группу томов на разделе root (внутри LUKS, если шифрование включено)
+// и логические тома, после чего root указывает на логический том.
+func (i *InstallerService) setupLvm(ctx context.Context, partitions map[string]PartitionInfo, planned []plannedPartition) (map[string]PartitionInfo, error) {
+	pv := partitions[RoleRoot].Path
+	lib.Log.Infof("Создание группы томов %s на %s...", LvmVolumeGroup, pv)
+
+	commands, err := lvmCommands(pv, planned)
+	if err != nil {
+		return nil, err
+	}
+	for _, args := range commands {
+		if err = i.run(ctx, args[0], args[1:]...); err != nil {
+			return nil, fmt.Errorf("ошибка выполнения команды %s: %v", args[0], err)
+		}
+		if args[0] == "vgcreate" {
+			i.pushLvmDeactivate()
+		}
+	}
+	return withLvmPaths(partitions), nil
+}
+
+// withLvmPaths заменяет путь root на логический том, сохраняя физический том в PhysicalVolume.
+func withLvmPaths(namedPartitions map[string]PartitionInfo) map[string]PartitionInfo {
+	rootInfo := namedPartitions[RoleRoot]
+	rootInfo.PhysicalVolume = rootInfo.Path
+	rootInfo.Path = lvPath(RoleRoot)
+	namedPartitions[RoleRoot] = rootInfo
+	return namedPartitions
+}
+
+// CheckVolumeGroup проверяет до установки, что группу томов LvmVolumeGroup можно создать.
+// При продолжении прерванной установки группа уже создана ею самой, поэтому проверка не нужна.
+func (i *InstallerService) CheckVolumeGroup() error {
+	if !i.data.Lvm.Enabled || (i.resume && i.ResumePhase() != "") {
+		return nil
+	}
+	return i.checkVolumeGroup(context.Background())
+}
+
+// checkVolumeGroup отказывает в установке, если в системе уже есть группа томов с именем LvmVolumeGroup:
+// vgcreate не создаст вторую, а отключение группы при очистке диска и откате затронуло бы чужие тома.
+// Допускается только группа, все физические тома которой лежат на очищаемом диске, — её оставила прошлая попытка установки.
+func (i *InstallerService) checkVolumeGroup(ctx context.Context) error {
+	output, err := i.output(ctx, "pvs", "--noheadings", "-o", "pv_name,vg_name")
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка физических томов LVM: %v", err)
+	}
+	var pvs []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == LvmVolumeGroup {
+			pvs = append(pvs, fields[0])
+		}
+	}
+	if len(pvs) == 0 {
+		return nil
+	}
+
+	if i.data.Partitioning.Erase() {
+		devices, err := i.output(ctx, "lsblk", "-ln", "-o", "PATH,FSTYPE", i.data.Disk)
+		if err != nil {
+			return fmt.Errorf("ошибка получения разделов диска %s: %v", i.data.Disk, err)
+		}
+		onDisk := make(map[string]bool)
+		for _, line := range strings.Split(string(devices), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				onDisk[fields[0]] = true
+			}
+		}
+		pvs = slices.DeleteFunc(pvs, func(pv string) bool { return onDisk[pv] })
+		if len(pvs) == 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("в системе уже есть группа томов %s (%s), которая не будет удалена при установке: переименуйте её через vgrename или установите систему без LVM",
+		LvmVolumeGroup, strings.Join(pvs, ", "))
+}
+
+// deactivateLvm отключает группу томов, оставшуюся от предыдущей попытки установки, чтобы диск можно было очистить.
+func (i *InstallerService) deactivateLvm(ctx context.Context) {
+	if err := i.run(ctx, "vgchange", "-an", LvmVolumeGroup); err != nil {
+		lib.Log.Warningf("Группа томов %s не отключена: %v", LvmVolumeGroup, err)
+	}
+}
+
+// growLvm расширяет физический том и логический том root на место, освобождённое временным разделом.
+func (i *InstallerService) growLvm(ctx context.Context, root PartitionInfo) error {
+	lib.Log.Infof("Расширение физического тома %s...", root.PhysicalVolume)
+	if err := i.run(ctx, "pvresize", root.PhysicalVolume); err != nil {
+		return fmt.Errorf("ошибка расширения физического тома: %v", err)
+	}
+	lib.Log.Infof("Расширение логического тома %s...", root.Path)
+	if err := i.run(ctx, "lvextend", "-l", "+100%FREE", root.Path); err != nil {
+		return fmt.Errorf("ошибка расширения логического тома: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"fmt"
+	"installer/lib"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// PartitionAssignment — роль, назначенная существующему разделу при ручной разметке.
+type PartitionAssignment struct {
+	Role string
+	Path string
+	// Format — раздел форматируется; иначе он используется с текущей файловой системой
+	Format bool
+}
+
+// ManualRoles — роли, которые можно назначить разделам при ручной разметке.
+var ManualRoles = []string{RoleESP, RoleBoot, RoleRoot, RoleVar, RoleHome, RoleBiosGrub}
+
+// RoleTitle возвращает название роли раздела для показа пользователю.
+func RoleTitle(role string) string {
+	switch role {
+	case RoleESP:
+		return lib.T_("EFI system partition")
+	case RoleBiosGrub:
+		return lib.T_("BIOS boot partition (LEGACY only)")
+	case RoleRoot:
+		return "/"
+	}
+	return "/" + role
+}
+
+// PlanManual строит план из разделов, которым пользователь назначил роли. Разделы не создаются:
+// отмеченные для форматирования форматируются заново, остальные монтируются с текущей файловой системой.
+// Образ загружается в подтом @install-containers корневой btrfs или в каталог .install-containers корня ext4 и xfs.
+func PlanManual(table *DiskTable, assignments []PartitionAssignment, bootMode, rootFS string) ([]plannedPartition, error) {
+	var planned []plannedPartition
+	paths := make(map[string]bool)
+	for _, assignment := range assignments {
+		if !slices.Contains(ManualRoles, assignment.Role) {
+			return nil, fmt.Errorf("раздел %s: неизвестная роль %q", assignment.Path, assignment.Role)
+		}
+		if hasRole(planned, assignment.Role) {
+			return nil, fmt.Errorf("роль %s назначена нескольким разделам", assignment.Role)
+		}
+		if paths[assignment.Path] {
+			return nil, fmt.Errorf("разделу %s назначено несколько ролей", assignment.Path)
+		}
+		paths[assignment.Path] = true
+
+		existing, ok := table.Partition(assignment.Path)
+		if !ok {
+			return nil, fmt.Errorf("раздел %s не найден на диске %s", assignment.Path, table.Disk)
+		}
+
+		part := plannedPartition{
+			PartitionSpec: lib.PartitionSpec{Role: assignment.Role},
+			Number:        existing.Number,
+			Path:          existing.Path,
+			StartMiB:      existing.StartMiB,
+			EndMiB:        existing.EndMiB,
+			Existing:      true,
+			Reformat:      assignment.Format,
+		}
+		if err := part.assignFilesystem(existing, rootFS); err != nil {
+			return nil, err
+		}
+		planned = append(planned, part)
+	}
+
+	required := []string{RoleESP, RoleBoot, RoleRoot}
+	if bootMode == "LEGACY" {
+		required = append(required, RoleBiosGrub)
+	}
+	for _, role := range required {
+		if !hasRole(planned, role) {
+			return nil, fmt.Errorf("не назначен раздел %s", role)
+		}
+	}
+
+	sort.Slice(planned, func(a, b int) bool { return planned[a].Number < planned[b].Number })
+	return planned, nil
+}
+
+// assignFilesystem задаёт файловую систему и флаги раздела, назначенного вручную.
+func (p *plannedPartition) assignFilesystem(existing ExistingPartition, rootFS string) error {
+	if p.Reformat {
+		switch p.Role {
+		case RoleESP:
+			p.Filesystem, p.Flags = "vfat", []string{"boot"}
+		case RoleBoot:
+			p.Filesystem = "ext4"
+		case RoleBiosGrub:
+			p.Flags = []string{"bios_grub"}
+		default:
+			p.Filesystem = rootFS
+		}
+		return nil
+	}
+
+	switch p.Role {
+	case RoleRoot:
+		// bootc устанавливает систему только на пустую файловую систему
+		return fmt.Errorf("раздел root %s нужно отформатировать", p.Path)
+	case RoleBiosGrub:
+		if !existing.HasFlag("bios_grub") {
+			return fmt.Errorf("у раздела %s нет флага bios_grub, включите форматирование", p.Path)
+		}
+		return nil
+	}
+
+	p.Filesystem = partedFilesystem(existing.Filesystem)
+	if p.Filesystem == "" {
+		return fmt.Errorf("на разделе %s нет файловой системы, включите форматирование", p.Path)
+	}
+	if p.Role == RoleESP && p.Filesystem != "vfat" {
+		return fmt.Errorf("системный раздел EFI %s должен быть в vfat, а не в %s", p.Path, p.Filesystem)
+	}
+	return nil
+}
+
+// partedFilesystem переводит имя файловой системы из вывода parted в имя для mount и fstab.
+func partedFilesystem(fsType string) string {
+	switch {
+	case strings.HasPrefix(fsType, "fat"):
+		return "vfat"
+	case strings.HasPrefix(fsType, "linux-swap"):
+		return "swap"
+	}
+	return fsType
+}
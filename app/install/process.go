@@ -17,95 +17,255 @@
 package install
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"github.com/creack/pty"
-	"github.com/sirupsen/logrus"
 	"installer/app/utility"
 	"installer/lib"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+	"unicode"
 )
 
 // InstallerService — сервис
 type InstallerService struct {
-	data   InstallerData
-	Status *SafeStatus
+	data     InstallerData
+	executor Executor
+	plan     []plannedPartition
+	mirrors  [][]plannedPartition
+	table    *DiskTable
+	// swapOffset — смещение файла подкачки на устройстве для resume_offset
+	swapOffset string
+	// tpmEnrolled — ключ LUKS привязан к TPM2, crypttab и параметры ядра должны пробовать TPM2
+	tpmEnrolled bool
+	// journal — журнал завершённых этапов; resume — продолжить по журналу прошлой попытки,
+	// redeploy — развёртывание повторяется после прерванной попытки
+	journal  *Journal
+	resume   bool
+	redeploy bool
+	// undo — отмена монтирований, открытых LUKS и активной группы томов в порядке их выполнения
+	undo []undoAction
+	// mu защищает cancel, done и cancelled: Cancel вызывается из другой горутины, чем RunInstall
+	mu        sync.Mutex
+	cancel    context.CancelFunc
+	done      chan struct{}
+	cancelled bool
+	// recoveryKey и headerBackups — ключ восстановления и копии заголовков LUKS для показа и сохранения после установки
+	recoveryKey   string
+	headerBackups []string
+	Status        *ProgressStream
 }
 
 // NewInstallerService — конструктор сервиса
 func NewInstallerService(installerData InstallerData) *InstallerService {
+	return NewInstallerServiceWithExecutor(installerData, NewLoggingExecutor(NewSystemExecutor()))
+}
+
+// NewInstallerServiceWithExecutor — конструктор сервиса, выполняющего все действия через executor.
+// В тестах сюда передаётся FakeExecutor с заготовленным выводом lsblk и blkid.
+func NewInstallerServiceWithExecutor(installerData InstallerData, executor Executor) *InstallerService {
 	return &InstallerService{
-		data:   installerData,
-		Status: NewSafeStatus(),
+		data:     installerData,
+		executor: executor,
+		Status:   NewProgressStream(),
 	}
 }
 
 type User struct {
 	Login    string
 	Password string
+	// PasswordHash — хэш пароля в формате crypt(3), используется вместо Password, если задан
+	PasswordHash string
 }
 
 type InstallerData struct {
-	Image              string
+	// Image — имя образа в реестре, по которому установленная система получает обновления
+	Image string
+	// ImageSource — локальный источник образа (oci-archive:, oci:, containers-storage:);
+	// если задан, образ копируется с установочного носителя, а не загружается из реестра
+	ImageSource        string
 	Disk               string
 	TypeFilesystem     string
 	TypeBoot           string
 	IsCryptoFilesystem bool
 	LuksPassword       string
-	User               User
+	// Tpm — автоматическая разблокировка LUKS через TPM2 (пароль остаётся запасным способом)
+	Tpm TpmOptions
+	// Luks — параметры luksFormat из экспертных настроек
+	Luks LuksOptions
+	// Keymap — раскладка консоли установленной системы (имя kbd), в том числе для ввода пароля LUKS в initrd
+	Keymap string
+	// RecoveryKey — добавить в LUKS слот со случайным ключом восстановления
+	RecoveryKey bool
+	User        User
+	// Layout — схема разметки диска; если не задана, используется схема по умолчанию для TypeBoot
+	Layout []lib.PartitionSpec
+	// Partitioning — очистка диска или установка рядом с существующими разделами
+	Partitioning Partitioning
+	// Lvm — размещение root в группе томов LVM
+	Lvm LvmOptions
+	// SubVolumes — подтомы корневой btrfs; если не заданы, используются подтомы из конфигурации
+	SubVolumes []lib.SubVolumeSpec
+	// Snapshots — исходные и периодические снимки подтомов /home и /var (только btrfs)
+	Snapshots bool
+	// Swap — способ подкачки и гибернация
+	Swap SwapOptions
 }
 
 const containerDir = "/var/lib/containers"
 
+// containerSubVolume — подтом btrfs, в который загружается образ, если в схеме нет временного раздела.
+// Имя отличается от @containers, чтобы постоянный подтом для хранилища контейнеров можно было задать в схеме подтомов.
+const containerSubVolume = "@install-containers"
+
+// containerRootDir — каталог в корне ext4 и xfs, в который загружается образ, если в схеме нет временного раздела.
+// Для этого корневой раздел отдельно монтируется в containerRootMount.
+const containerRootDir = ".install-containers"
+
+const containerRootMount = "/mnt/install-root"
+
+// installContainerName — имя контейнера bootc, по нему контейнер останавливается при отмене установки.
+const installContainerName = "atomic-installer-bootc"
+
+// containerStopSeconds — сколько podman stop ждёт завершения bootc после SIGTERM, прежде чем послать SIGKILL.
+const containerStopSeconds = 30
+
 var timezone = "Europe/Moscow"
 
-func (i *InstallerService) RunInstall() {
+// ErrCancelled возвращается RunInstall, если установка прервана через Cancel.
+var ErrCancelled = errors.New("установка отменена")
+
+// RunInstall выполняет установку по этапам и возвращает ошибку первого неудавшегося этапа.
+// Завершённые этапы записываются в журнал; после SetResume(true) этапы из журнала прошлой попытки пропускаются.
+func (i *InstallerService) RunInstall() error {
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	i.mu.Lock()
+	if i.cancelled {
+		i.mu.Unlock()
+		cancel()
+		i.Status.SetStatus(StatusCancelled)
+		return ErrCancelled
+	}
+	i.cancel = cancel
+	i.done = make(chan struct{})
+	i.mu.Unlock()
+	defer func() {
+		cancel()
+		close(i.done)
+	}()
 
 	i.Status.SetStatus(StatusCheckingEnvironment)
 	go i.checkTimeZone()
 
 	i.Status.SetStatus(StatusRemountingTmp)
-	i.checkAndRemountTmp()
+	i.checkAndRemountTmp(ctx)
 
-	if err := i.prepareDisk(ctx); err != nil {
-		i.Status.SetStatus(StatusError)
-		lib.Log.Errorf("Disk preparation error: %v", err)
-		return
+	i.journal = nil
+	if i.resume {
+		i.journal = i.loadJournal()
 	}
-
-	if err := i.installToFilesystem(ctx); err != nil {
-		i.Status.SetStatus(StatusError)
-		lib.Log.Errorf("Installation error: %v", err)
-		return
+	if i.journal != nil && i.journal.done(PhaseDisk) {
+		if err := i.restoreJournal(ctx); err != nil {
+			lib.Log.Errorf("Resume error: %v", err)
+			return i.fail(err)
+		}
+	} else {
+		// Разметка диска делает журнал прошлой попытки недействительным
+		i.journal = nil
+		i.removeJournal()
 	}
 
-	partitions, err := i.getNamedPartitionsWithCrypto()
-	if err != nil {
-		i.Status.SetStatus(StatusError)
-		lib.Log.Errorf("Error obtaining named partitions: %v", err)
-		return
+	phases := []struct {
+		name    string
+		run     func(ctx context.Context) error
+		failure string
+	}{
+		{PhaseDisk, i.prepareDisk, "Disk preparation error"},
+		{PhaseImage, i.stageImage, "Image download error"},
+		{PhaseDeploy, i.deploySystem, "Installation error"},
+		{PhaseConfigure, i.configureSystem, "Configuration error"},
+		{PhaseFinalize, i.finalizeInstall, "Container storage cleanup error"},
+	}
+	for _, phase := range phases {
+		if i.journal != nil && i.journal.done(phase.name) {
+			lib.Log.Infof("Phase %s is already completed, skipping", phase.name)
+			continue
+		}
+		if ctx.Err() != nil {
+			return i.fail(ctx.Err())
+		}
+		i.Status.StartPhase(phase.name)
+		if err := phase.run(ctx); err != nil {
+			lib.Log.Errorf("%s: %v", phase.failure, err)
+			return i.fail(err)
+		}
+		// Журнал нужен только для продолжения после сбоя, поэтому его ошибка не прерывает установку
+		if err := i.completePhase(ctx, phase.name); err != nil {
+			lib.Log.Warningf("Installation journal error: %v", err)
+			i.Status.Warn(fmt.Sprintf(lib.T_("Installation journal error: %v"), err))
+		}
 	}
 
-	if err = i.cleanupTemporaryPartition(ctx, partitions); err != nil {
-		i.Status.SetStatus(StatusError)
-		lib.Log.Errorf("Temporary partition cleanup error: %v", err)
+	// Открытые LUKS и группа томов остаются до перезагрузки в установленную систему
+	i.undo = nil
+	i.Status.SetStatus(StatusCompleted)
+	lib.Log.Info("Installation completed successfully!")
+	return nil
+}
+
+// fail откатывает изменения прерванной установки и возвращает её ошибку или ErrCancelled, если установку отменили.
+func (i *InstallerService) fail(err error) error {
+	i.Status.SetStatus(StatusRollingBack)
+	i.rollback()
+
+	i.mu.Lock()
+	cancelled := i.cancelled
+	i.mu.Unlock()
+	if cancelled {
+		lib.Log.Warning("Installation cancelled")
+		i.Status.SetStatus(StatusCancelled)
+		return ErrCancelled
+	}
+	i.Status.SetStatus(StatusError)
+	return err
+}
+
+// Cancel прерывает установку: отменяет её контекст, из-за чего выполняемые команды получают SIGTERM,
+// останавливает контейнер bootc и ждёт, пока RunInstall откатит изменения. После этого статус — StatusCancelled.
+// Если установка ещё не запущена, RunInstall сразу вернёт ErrCancelled; завершённая установка не меняется.
+func (i *InstallerService) Cancel() {
+	i.mu.Lock()
+	i.cancelled = true
+	cancel, done := i.cancel, i.done
+	i.mu.Unlock()
+	if cancel == nil {
 		return
 	}
 
-	i.Status.SetStatus(StatusCompleted)
-	lib.Log.Info("Installation completed successfully!")
+	lib.Log.Warning("Cancelling installation...")
+	cancel()
+	<-done
+}
+
+// run выполняет внешнюю команду через исполнитель сервиса
+func (i *InstallerService) run(ctx context.Context, name string, args ...string) error {
+	return i.executor.Run(ctx, Cmd(name, args...))
 }
 
-func (i *InstallerService) checkAndRemountTmp() {
+// output выполняет команду-запрос и возвращает её вывод
+func (i *InstallerService) output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return i.executor.Output(ctx, Cmd(name, args...))
+}
+
+func (i *InstallerService) checkAndRemountTmp(ctx context.Context) {
 	var stat syscall.Statfs_t
 
 	if err := syscall.Statfs("/tmp", &stat); err != nil {
@@ -119,13 +279,11 @@ func (i *InstallerService) checkAndRemountTmp() {
 
 	// If less than 5 GB, attempt to remount /tmp
 	if total < 5.0 {
-		cmd := exec.Command("mount", "-o", "remount,size=5G", "/tmp")
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			lib.Log.Errorf("Error remounting /tmp: %v (output: %s)", err, string(output))
+		if err := i.run(ctx, "mount", "-o", "remount,size=5G", "/tmp"); err != nil {
+			lib.Log.Errorf("Error remounting /tmp: %v", err)
 			return
 		}
-		lib.Log.Info("Successfully remounted /tmp, command output:", string(output))
+		lib.Log.Info("Successfully remounted /tmp")
 	} else {
 		lib.Log.Info("The /tmp size is sufficient, remounting is not required.")
 	}
@@ -141,12 +299,88 @@ func (i *InstallerService) checkTimeZone() {
 	timezone = ipTimeZone
 }
 
-func (i *InstallerService) cleanupTemporaryPartition(ctx context.Context, partitions map[string]PartitionInfo) error {
+// finalizeInstall удаляет хранилище контейнеров, использованное при установке.
+func (i *InstallerService) finalizeInstall(ctx context.Context) error {
+	partitions, err := i.getNamedPartitionsWithCrypto(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения разделов: %v", err)
+	}
+	return i.cleanupContainerStorage(ctx, partitions)
+}
+
+// cleanupContainerStorage удаляет хранилище контейнеров, использованное при установке:
+// временный раздел, подтом @install-containers или каталог .install-containers.
+func (i *InstallerService) cleanupContainerStorage(ctx context.Context, partitions map[string]PartitionInfo) error {
 	i.Status.SetStatus(StatusFinalizingInstallation)
+
+	plan, err := i.partitionPlan(ctx)
+	if err != nil {
+		return err
+	}
+	if hasRole(plan, RoleTemp) {
+		return i.cleanupTemporaryPartition(ctx, partitions)
+	}
+	if i.stagedInRootDir(plan) {
+		return i.removeContainerDirectory(ctx)
+	}
+	return i.removeContainerSubVolume(ctx, partitions)
+}
+
+// stagedInRootDir сообщает, что образ загружается в каталог .install-containers корневого раздела ext4 или xfs.
+func (i *InstallerService) stagedInRootDir(plan []plannedPartition) bool {
+	return !hasRole(plan, RoleTemp) && i.data.TypeFilesystem != "btrfs"
+}
+
+// removeContainerDirectory размонтирует и удаляет каталог .install-containers.
+// Ошибка удаления не прерывает установку: система уже готова, каталог можно удалить вручную.
+func (i *InstallerService) removeContainerDirectory(ctx context.Context) error {
+	lib.Log.Infof("Удаление каталога %s...", containerRootDir)
+
+	if err := i.unmount(ctx, "/var/tmp"); err != nil {
+		lib.Log.Errorf("ошибка размонтирования /var/tmp: %v", err)
+	}
+	if err := i.unmount(ctx, containerDir); err != nil {
+		return fmt.Errorf("ошибка размонтирования каталога %s: %v", containerRootDir, err)
+	}
+
+	if err := i.executor.RemoveAll(containerRootMount + "/" + containerRootDir); err != nil {
+		lib.Log.Warningf("Каталог %s не удалён, его можно удалить вручную: %v", containerRootDir, err)
+		i.Status.Warn(fmt.Sprintf(lib.T_("Directory %s was not deleted, it can be deleted manually"), "/"+containerRootDir))
+	}
+	i.releaseMountPoint(ctx, containerRootMount)
+	return nil
+}
+
+// removeContainerSubVolume размонтирует и удаляет подтом @install-containers.
+// Ошибка удаления не прерывает установку: система уже готова, подтом можно удалить вручную.
+func (i *InstallerService) removeContainerSubVolume(ctx context.Context, partitions map[string]PartitionInfo) error {
+	lib.Log.Infof("Удаление подтома %s...", containerSubVolume)
+
+	if err := i.unmount(ctx, "/var/tmp"); err != nil {
+		lib.Log.Errorf("ошибка размонтирования /var/tmp: %v", err)
+	}
+	if err := i.unmount(ctx, containerDir); err != nil {
+		return fmt.Errorf("ошибка размонтирования подтома %s: %v", containerSubVolume, err)
+	}
+
+	mountPoint := "/mnt/btrfs-setup"
+	if err := i.mountDisk(ctx, partitions[RoleRoot].Path, mountPoint, "rw,subvol=/"); err != nil {
+		return fmt.Errorf("ошибка монтирования Btrfs раздела: %v", err)
+	}
+	defer i.releaseMountPoint(ctx, mountPoint)
+
+	if err := i.run(ctx, "btrfs", "subvolume", "delete", mountPoint+"/"+containerSubVolume); err != nil {
+		lib.Log.Warningf("Подтом %s не удалён, его можно удалить вручную: %v", containerSubVolume, err)
+		i.Status.Warn(fmt.Sprintf(lib.T_("Subvolume %s was not deleted, it can be deleted manually"), containerSubVolume))
+	}
+	return nil
+}
+
+func (i *InstallerService) cleanupTemporaryPartition(ctx context.Context, partitions map[string]PartitionInfo) error {
 	lib.Log.Info("Удаление временного раздела и расширение root-раздела...")
 
 	// Размонтируем временный раздел
-	lib.Log.Infof("Размонтирование временного раздела %s...", partitions["temp"].Path)
+	lib.Log.Infof("Размонтирование временного раздела %s...", partitions[RoleTemp].Path)
 	if err := i.unmount(ctx, containerDir); err != nil {
 		return fmt.Errorf("ошибка размонтирования временного раздела: %v", err)
 	}
@@ -154,56 +388,70 @@ func (i *InstallerService) cleanupTemporaryPartition(ctx context.Context, partit
 	// Размонтируем временный раздел
 	lib.Log.Infof("Размонтирование временного раздела %s...", "/var/tmp")
 	if err := i.unmount(ctx, "/var/tmp"); err != nil {
-		logrus.Errorf("ошибка размонтирования временного раздела: %v", err)
+		lib.Log.Errorf("ошибка размонтирования временного раздела: %v", err)
 	}
 
 	// Удаляем временный раздел
-	lib.Log.Infof("Удаление временного раздела %s...", partitions["temp"].Path)
-	cmd := exec.Command("parted", "-s", i.data.Disk, "rm", partitions["temp"].Number)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	lib.Log.Infof("Удаление временного раздела %s...", partitions[RoleTemp].Path)
+	if err := i.run(ctx, "parted", "-s", i.data.Disk, "rm", partitions[RoleTemp].Number); err != nil {
 		return fmt.Errorf("ошибка удаления временного раздела: %v", err)
 	}
 
+	// Расширять root имеет смысл, только если после удаления temp он оказался последним разделом диска
+	plan, err := i.partitionPlan(ctx)
+	if err != nil {
+		return err
+	}
+	if !rootIsLast(plan) {
+		lib.Log.Infof("Раздел root не последний в схеме разметки, расширение пропущено.")
+		return nil
+	}
+
 	// Определяем физический раздел для resize (исходный или зашифрованный)
 	var resizeTarget string
-	if i.data.IsCryptoFilesystem && partitions["root"].OriginalPath != "" {
-		resizeTarget = partitions["root"].OriginalPath
+	if i.data.IsCryptoFilesystem && partitions[RoleRoot].OriginalPath != "" {
+		resizeTarget = partitions[RoleRoot].OriginalPath
 		lib.Log.Infof("Расширение LUKS раздела %s до 100%%...", resizeTarget)
 	} else {
-		resizeTarget = partitions["root"].Path
+		resizeTarget = partitions[RoleRoot].Path
 		lib.Log.Infof("Расширение root-раздела %s до 100%%...", resizeTarget)
 	}
 
-	cmd = exec.Command("parted", "-s", i.data.Disk, "resizepart", partitions["root"].Number, "100%")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	// При установке рядом за временным разделом могут быть чужие разделы, поэтому root расширяется только до его конца
+	end := "100%"
+	for _, part := range plan {
+		if part.Role == RoleTemp && part.EndMiB != 0 {
+			end = fmt.Sprintf("%dMiB", part.EndMiB)
+		}
+	}
+	if err := i.run(ctx, "parted", "-s", i.data.Disk, "resizepart", partitions[RoleRoot].Number, end); err != nil {
 		return fmt.Errorf("ошибка изменения размера раздела: %v", err)
 	}
 
 	// Для LUKS разделов нужно расширить и сам зашифрованный том
-	if i.data.IsCryptoFilesystem && partitions["root"].OriginalPath != "" {
+	if i.data.IsCryptoFilesystem && partitions[RoleRoot].OriginalPath != "" {
 		lib.Log.Infof("Расширение LUKS тома...")
-		resizeCmd := exec.Command("cryptsetup", "resize", "cryptroot")
-		resizeCmd.Stdout = os.Stdout
-		resizeCmd.Stderr = os.Stderr
-		resizeCmd.Stdin = strings.NewReader(i.data.LuksPassword)
-		if err := resizeCmd.Run(); err != nil {
+		resizeCmd := Command{Name: "cryptsetup", Args: []string{"resize", "cryptroot"}, Stdin: i.data.LuksPassword}
+		if err := i.executor.Run(ctx, resizeCmd); err != nil {
 			return fmt.Errorf("ошибка расширения LUKS тома: %v", err)
 		}
 
 		// Задержка и принудительное обновление размера устройства
 		time.Sleep(3 * time.Second)
-		exec.Command("udevadm", "settle").Run()
-		exec.Command("partprobe").Run()
+		_ = i.run(ctx, "udevadm", "settle")
+		_ = i.run(ctx, "partprobe")
+	}
+
+	// В LVM сначала расширяются физический и логический тома, затем файловая система на логическом томе
+	if i.data.Lvm.Enabled {
+		if err := i.growLvm(ctx, partitions[RoleRoot]); err != nil {
+			return err
+		}
 	}
 
 	// Проверяем тип файловой системы root-раздела
-	lib.Log.Infof("Проверка типа файловой системы раздела %s...", partitions["root"].Path)
-	cmd = exec.Command("blkid", "-o", "value", "-s", "TYPE", partitions["root"].Path)
-	output, err := cmd.Output()
+	lib.Log.Infof("Проверка типа файловой системы раздела %s...", partitions[RoleRoot].Path)
+	output, err := i.output(ctx, "blkid", "-o", "value", "-s", "TYPE", partitions[RoleRoot].Path)
 	if err != nil {
 		return fmt.Errorf("ошибка проверки типа файловой системы: %v", err)
 	}
@@ -214,38 +462,42 @@ func (i *InstallerService) cleanupTemporaryPartition(ctx context.Context, partit
 	if fsType == "btrfs" {
 		// Для btrfs используем btrfs filesystem resize
 		mountPoint := "/mnt/btrfs-root"
-		lib.Log.Infof("Изменение размера файловой системы btrfs на разделе %s...", partitions["root"].Path)
+		lib.Log.Infof("Изменение размера файловой системы btrfs на разделе %s...", partitions[RoleRoot].Path)
 
 		// Монтируем раздел
-		if err = i.mountDisk(partitions["root"].Path, mountPoint, ""); err != nil {
+		if err = i.mountDisk(ctx, partitions[RoleRoot].Path, mountPoint, ""); err != nil {
 			return fmt.Errorf("ошибка монтирования btrfs-раздела: %v", err)
 		}
-		defer i.unmountDisk(mountPoint) // Размонтируем после завершения
+		defer i.unmountDisk(ctx, mountPoint) // Размонтируем после завершения
 
 		// Выполняем resize на точке монтирования
-		cmd = exec.Command("btrfs", "filesystem", "resize", "max", mountPoint)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+		if err = i.run(ctx, "btrfs", "filesystem", "resize", "max", mountPoint); err != nil {
 			return fmt.Errorf("ошибка изменения размера файловой системы btrfs: %v", err)
 		}
 	} else if fsType == "ext4" {
 		// Для ext4 используем resize2fs
-		cmd = exec.Command("e2fsck", "-f", "-y", partitions["root"].Path)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		lib.Log.Infof("Проверка и исправление файловой системы ext4 на разделе %s...", partitions["root"].Path)
-		if err := cmd.Run(); err != nil {
+		lib.Log.Infof("Проверка и исправление файловой системы ext4 на разделе %s...", partitions[RoleRoot].Path)
+		if err = i.run(ctx, "e2fsck", "-f", "-y", partitions[RoleRoot].Path); err != nil {
 			return fmt.Errorf("ошибка проверки файловой системы ext4: %v", err)
 		}
 
-		lib.Log.Infof("Изменение размера файловой системы ext4 на разделе %s...", partitions["root"].Path)
-		cmd = exec.Command("resize2fs", partitions["root"].Path)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+		lib.Log.Infof("Изменение размера файловой системы ext4 на разделе %s...", partitions[RoleRoot].Path)
+		if err = i.run(ctx, "resize2fs", partitions[RoleRoot].Path); err != nil {
 			return fmt.Errorf("ошибка изменения размера файловой системы ext4: %v", err)
 		}
+	} else if fsType == "xfs" {
+		// xfs расширяется только смонтированной
+		mountPoint := "/mnt/xfs-root"
+		lib.Log.Infof("Изменение размера файловой системы xfs на разделе %s...", partitions[RoleRoot].Path)
+
+		if err = i.mountDisk(ctx, partitions[RoleRoot].Path, mountPoint, ""); err != nil {
+			return fmt.Errorf("ошибка монтирования xfs-раздела: %v", err)
+		}
+		defer i.unmountDisk(ctx, mountPoint)
+
+		if err = i.run(ctx, "xfs_growfs", mountPoint); err != nil {
+			return fmt.Errorf("ошибка изменения размера файловой системы xfs: %v", err)
+		}
 	} else {
 		return fmt.Errorf("неподдерживаемая файловая система: %s", fsType)
 	}
@@ -255,95 +507,111 @@ func (i *InstallerService) cleanupTemporaryPartition(ctx context.Context, partit
 }
 
 // isMounted проверяет, примонтирован ли путь
-func (i *InstallerService) isMounted(path string) bool {
-	cmd := exec.Command("mountpoint", "-q", path)
-	err := cmd.Run()
+func (i *InstallerService) isMounted(ctx context.Context, path string) bool {
+	_, err := i.output(ctx, "mountpoint", "-q", path)
 	return err == nil
 }
 
-// unmount размонтирует путь, если он примонтирован
+// unmount размонтирует путь, если он примонтирован.
+// Размонтирование — часть очистки, поэтому выполняется и после отмены установки.
 func (i *InstallerService) unmount(ctx context.Context, path string) error {
-	if i.isMounted(path) {
+	ctx = context.WithoutCancel(ctx)
+	if i.isMounted(ctx, path) {
 		lib.Log.Infof("Размонтирование %s...", path)
-		fmt.Printf("Размонтирование %s...", path)
-		cmd := exec.CommandContext(ctx, "umount", "-l", path)
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-		if err := cmd.Run(); err != nil {
+		if err := i.run(ctx, "umount", "-l", path); err != nil {
 			return fmt.Errorf("ошибка размонтирования %s: %v", path, err)
 		}
 		lib.Log.Infof("%s успешно размонтирован.", path)
 	}
+	i.dropUndo(path)
 	return nil
 }
 
-func (i *InstallerService) freeDisk() {
-	lib.Log.Infof("Освобождение диска %s от процессов", i.data.Disk)
-	killCmd := exec.Command("fuser", "-km", i.data.Disk)
-	if _, err := killCmd.CombinedOutput(); err != nil {
-		lib.Log.Warningf("Не найдены активные процессы для %s: %v", i.data.Disk, err)
+func (i *InstallerService) freeDisk(ctx context.Context) {
+	for _, disk := range append([]string{i.data.Disk}, i.data.Partitioning.Mirrors...) {
+		lib.Log.Infof("Освобождение диска %s от процессов", disk)
+		if err := i.run(ctx, "fuser", "-km", disk); err != nil {
+			lib.Log.Warningf("Не найдены активные процессы для %s: %v", disk, err)
+		}
 	}
 
-	exec.Command("sync").Run()
+	_ = i.run(ctx, "sync")
 }
 
 // prepareDisk выполняет подготовку диска
 func (i *InstallerService) prepareDisk(ctx context.Context) error {
 	i.Status.SetStatus(StatusPreparingDisk)
-	paths := []string{"/mnt/target/boot/efi", "/mnt/target/boot", containerDir, "/mnt/target", "/var/tmp"}
-
-	for _, path := range paths {
-		_ = i.unmount(ctx, path)
+	if i.data.Lvm.Enabled {
+		if err := i.checkVolumeGroup(ctx); err != nil {
+			return err
+		}
 	}
-	i.freeDisk()
+	i.unmountInstallPaths(ctx)
+	i.freeDisk(ctx)
 
 	lib.Log.Infof("Подготовка диска %s с файловой системой %s в режиме %s", i.data.Disk, i.data.TypeFilesystem, i.data.TypeBoot)
 
-	// Команды для разметки
-	var commands [][]string
-
-	if i.data.TypeBoot == "LEGACY" {
-		commands = [][]string{
-			{"wipefs", "--all", i.data.Disk},
-			{"parted", "-s", i.data.Disk, "mklabel", "gpt"},
-			{"parted", "-s", i.data.Disk, "mkpart", "primary", "1MiB", "3MiB"},                               // BIOS Boot Partition (2 МиБ)
-			{"parted", "-s", i.data.Disk, "set", "1", "bios_grub", "on"},                                     // BIOS Boot Partition
-			{"parted", "-s", i.data.Disk, "mkpart", "primary", "fat32", "3MiB", "1003MiB"},                   // EFI раздел (1 ГБ)
-			{"parted", "-s", i.data.Disk, "set", "2", "boot", "on"},                                          // EFI раздел
-			{"parted", "-s", i.data.Disk, "mkpart", "primary", "ext4", "1003MiB", "3003MiB"},                 // Boot раздел (2 ГБ)
-			{"parted", "-s", i.data.Disk, "mkpart", "primary", i.data.TypeFilesystem, "3003MiB", "25000MiB"}, // Root раздел
-			{"parted", "-s", i.data.Disk, "mkpart", "primary", "ext4", "25000MiB", "60000MiB"},               // Временный раздел
-		}
-	} else if i.data.TypeBoot == "UEFI" {
-		commands = [][]string{
-			{"wipefs", "--all", i.data.Disk},
-			{"parted", "-s", i.data.Disk, "mklabel", "gpt"},
-			{"parted", "-s", i.data.Disk, "mkpart", "primary", "fat32", "1MiB", "601MiB"},                    // EFI раздел (600 МБ)
-			{"parted", "-s", i.data.Disk, "set", "1", "boot", "on"},                                          // EFI раздел
-			{"parted", "-s", i.data.Disk, "mkpart", "primary", "ext4", "601MiB", "2601MiB"},                  // Boot раздел (2 ГБ)
-			{"parted", "-s", i.data.Disk, "mkpart", "primary", i.data.TypeFilesystem, "2601MiB", "25000MiB"}, // Root раздел
-			{"parted", "-s", i.data.Disk, "mkpart", "primary", "ext4", "25000MiB", "60000MiB"},               // Временный раздел
+	plan, err := i.partitionPlan(ctx)
+	if err != nil {
+		return err
+	}
+
+	// При установке рядом существующие разделы сохраняются, место освобождается уменьшением раздела
+	// Группа томов от прошлой попытки установки держит раздел root и мешает очистке диска
+	if i.data.Lvm.Enabled {
+		i.deactivateLvm(ctx)
+	}
+
+	if i.data.Partitioning.Alongside() {
+		if err = i.shrinkPartition(ctx); err != nil {
+			return err
 		}
-	} else {
-		return fmt.Errorf("неизвестный тип загрузки: %s", i.data.TypeBoot)
 	}
 
-	for _, args := range commands {
-		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+	// Полная очистка старых данных перед созданием новой таблицы разделов
+	if err = i.wipeDisks(ctx); err != nil {
+		return err
+	}
+
+	// Команды для разметки
+	for _, args := range partedCommands(i.data.Disk, plan, i.data.Partitioning.Erase()) {
+		if err = i.run(ctx, args[0], args[1:]...); err != nil {
 			return fmt.Errorf("ошибка выполнения команды %s: %v", args[0], err)
 		}
 	}
 
-	partitions, err := i.getNamedPartitions()
+	// Проверяем, что ядро видит все разделы плана
+	created, err := i.getPartitions(ctx, i.data.Disk)
 	if err != nil {
 		return fmt.Errorf("ошибка получения разделов: %v", err)
 	}
+	for _, part := range plan {
+		if !part.Logical && !slices.Contains(created, part.Path) {
+			return fmt.Errorf("раздел %s (%s) не найден на диске %s", part.Path, part.Role, i.data.Disk)
+		}
+	}
 
-	if len(partitions) < 3 {
-		return fmt.Errorf("недостаточно разделов на диске")
+	// Дополнительные диски RAID1 размечаются заново по своим планам
+	for idx, disk := range i.data.Partitioning.Mirrors {
+		for _, args := range partedCommands(disk, i.mirrors[idx], true) {
+			if err = i.run(ctx, args[0], args[1:]...); err != nil {
+				return fmt.Errorf("ошибка выполнения команды %s: %v", args[0], err)
+			}
+		}
+		created, err := i.getPartitions(ctx, disk)
+		if err != nil {
+			return fmt.Errorf("ошибка получения разделов: %v", err)
+		}
+		for _, part := range i.mirrors[idx] {
+			if !slices.Contains(created, part.Path) {
+				return fmt.Errorf("раздел %s (%s) не найден на диске %s", part.Path, part.Role, disk)
+			}
+		}
+	}
+
+	partitions, err := i.getNamedPartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения разделов: %v", err)
 	}
 
 	var partitionList []string
@@ -352,277 +620,470 @@ func (i *InstallerService) prepareDisk(ctx context.Context) error {
 	}
 	lib.Log.Infof("Partitions: %s", strings.Join(partitionList, ", "))
 
-	// LUKS шифрование root раздела
+	// С заново форматируемых разделов удаляются старые сигнатуры, чтобы mkfs и cryptsetup не отказались их перезаписать
+	for _, part := range plan {
+		if part.Reformat {
+			if err = i.run(ctx, "wipefs", "--all", part.Path); err != nil {
+				return fmt.Errorf("ошибка очистки раздела %s: %v", part.Path, err)
+			}
+		}
+	}
+
+	// LUKS шифрование root раздела; при RAID1 шифруется root на каждом диске
 	if i.data.IsCryptoFilesystem {
-		lib.Log.Infof("Настройка LUKS шифрования для раздела %s...", partitions["root"].Path)
+		for idx, originalRootPath := range partitions[RoleRoot].devices() {
+			lib.Log.Infof("Настройка LUKS шифрования для раздела %s...", originalRootPath)
+
+			// Форматируем с LUKS2, передаем пароль через stdin
+			cryptsetupCmd := Command{
+				Name:  "cryptsetup",
+				Args:  i.data.Luks.formatArgs(originalRootPath),
+				Stdin: i.data.LuksPassword,
+			}
+			if err := i.executor.Run(ctx, cryptsetupCmd); err != nil {
+				return fmt.Errorf("ошибка создания LUKS раздела: %v", err)
+			}
+
+			// Открываем зашифрованный раздел
+			if err := i.luksOpen(ctx, originalRootPath, cryptName(idx)); err != nil {
+				return fmt.Errorf("ошибка открытия LUKS раздела: %v", err)
+			}
+		}
 
-		originalRootPath := partitions["root"].Path
+		// Создаем новую map с обновленными путями
+		partitions = i.withCryptPaths(partitions)
 
-		// Форматируем с LUKS2, передаем пароль через stdin
-		cryptsetupCmd := exec.CommandContext(ctx, "cryptsetup", "luksFormat", "--type", "luks2", "--batch-mode", "--force-password", originalRootPath)
-		cryptsetupCmd.Stdout = os.Stdout
-		cryptsetupCmd.Stderr = os.Stderr
-		cryptsetupCmd.Stdin = strings.NewReader(i.data.LuksPassword)
-		if err := cryptsetupCmd.Run(); err != nil {
-			return fmt.Errorf("ошибка создания LUKS раздела: %v", err)
+		// Раздел подкачки шифруется, иначе на него попадает содержимое памяти в открытом виде
+		if i.encryptedSwap(plan) {
+			if err = i.setupSwapEncryption(ctx, partitions); err != nil {
+				return err
+			}
 		}
 
-		// Открываем зашифрованный раздел
-		openCmd := exec.CommandContext(ctx, "cryptsetup", "luksOpen", originalRootPath, "cryptroot")
-		openCmd.Stdout = os.Stdout
-		openCmd.Stderr = os.Stderr
-		openCmd.Stdin = strings.NewReader(i.data.LuksPassword)
-		if err := openCmd.Run(); err != nil {
-			return fmt.Errorf("ошибка открытия LUKS раздела: %v", err)
+		i.enrollTpm(ctx, partitions)
+
+		if i.data.RecoveryKey {
+			if err = i.addRecoveryKey(ctx, partitions); err != nil {
+				return err
+			}
 		}
 
-		// Создаем новую map с обновленными путями
-		rootInfo := partitions["root"]
-		rootInfo.OriginalPath = originalRootPath
-		rootInfo.Path = "/dev/mapper/cryptroot"
-		partitions["root"] = rootInfo
+		// Копия снимается после добавления всех слотов, чтобы восстановленный заголовок содержал и их
+		if err = i.backupLuksHeaders(ctx, partitions); err != nil {
+			return err
+		}
 	}
 
-	formats := []struct {
-		cmd  string
-		args []string
-	}{
-		{"mkfs.vfat", []string{"-F32", partitions["efi"].Path}}, // Форматирование EFI раздела
-		{"mkfs.ext4", []string{partitions["boot"].Path}},        // Форматирование boot раздела
+	// Группа томов LVM создаётся на разделе root или внутри cryptroot
+	if i.data.Lvm.Enabled {
+		if partitions, err = i.setupLvm(ctx, partitions, plan); err != nil {
+			return err
+		}
 	}
 
-	if i.data.TypeFilesystem == "ext4" {
-		formats = append(formats, struct {
-			cmd  string
-			args []string
-		}{"mkfs.ext4", []string{partitions["root"].Path}})
-
-	} else if i.data.TypeFilesystem == "btrfs" {
-		formats = append(formats, struct {
-			cmd  string
-			args []string
-		}{"mkfs.btrfs", []string{"-f", partitions["root"].Path}})
-	} else {
-		return fmt.Errorf("неизвестная файловая система: %s", i.data.TypeFilesystem)
+	// Форматирование разделов; root форматируется по пути с учётом LUKS
+	for _, part := range plan {
+		if part.Existing && !part.Reformat {
+			continue
+		}
+		info := partitions[part.Role]
+
+		// Разделы root и boot всех дисков RAID1 объединяются в одну btrfs, остальные форматируются на каждом диске
+		commands := [][]string{raidFormatCommand(info.devices())}
+		if len(info.Mirrors) == 0 || part.Filesystem != "btrfs" {
+			commands = nil
+			for _, device := range info.devices() {
+				args, err := formatCommand(part.Filesystem, device)
+				if err != nil {
+					return err
+				}
+				if args != nil {
+					commands = append(commands, args)
+				}
+			}
+		}
+		for _, args := range commands {
+			if err = i.run(ctx, args[0], args[1:]...); err != nil {
+				return fmt.Errorf("ошибка форматирования %s: %v", info.Path, err)
+			}
+		}
 	}
 
-	formats = append(formats, struct {
-		cmd  string
-		args []string
-	}{"mkfs.ext4", []string{partitions["temp"].Path}})
-
-	for _, format := range formats {
-		cmd := exec.CommandContext(ctx, format.cmd, format.args...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			return fmt.Errorf("ошибка форматирования %s: %v", format.args[0], err)
+	// Ядро должно увидеть все устройства btrfs RAID1 до монтирования
+	if i.data.Partitioning.Raid() {
+		if err = i.run(ctx, "btrfs", "device", "scan"); err != nil {
+			return fmt.Errorf("ошибка поиска устройств btrfs: %v", err)
 		}
 	}
 
+	// Без временного раздела хранилище контейнеров размещается в подтоме корневой btrfs
+	stageOnRoot := !hasRole(plan, RoleTemp)
+
 	if i.data.TypeFilesystem == "btrfs" {
-		if err = i.createBtrfsSubVolumes(partitions["root"].Path); err != nil {
+		if err = i.createBtrfsSubVolumes(ctx, partitions[RoleRoot].Path, rootSubVolumes(i.subVolumeLayout(), plan), stageOnRoot); err != nil {
 			return fmt.Errorf("ошибка создания подтомов Btrfs: %v", err)
 		}
 	}
 
-	// Создание временного раздела
-	tempCommands := [][]string{
-		{"mkdir", "-p", containerDir},
-		{"mount", partitions["temp"].Path, containerDir},
+	// Файл подкачки создаётся до bootc: для гибернации его смещение передаётся в параметры ядра
+	if i.data.Swap.Mode == SwapFile {
+		if err = i.createSwapFile(ctx, partitions[RoleRoot].Path); err != nil {
+			return err
+		}
 	}
 
-	for _, args := range tempCommands {
-		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			return fmt.Errorf("ошибка выполнения команды %s: %v", args[0], err)
-		}
+	if err = i.mountContainerStorage(ctx, plan, partitions); err != nil {
+		return err
 	}
 	lib.Log.Infof("Диск %s успешно подготовлен.", i.data.Disk)
 
 	return nil
 }
 
-func (i *InstallerService) createBtrfsSubVolumes(rootPartition string) error {
-	mountPoint := "/mnt/btrfs-setup"
-	if err := os.MkdirAll(mountPoint, 0755); err != nil {
-		return fmt.Errorf("ошибка создания точки монтирования: %v", err)
+// unmountInstallPaths размонтирует точки монтирования, оставшиеся от прошлой попытки установки.
+func (i *InstallerService) unmountInstallPaths(ctx context.Context) {
+	paths := []string{"/mnt/target/boot/efi", "/mnt/target/boot", containerDir, "/mnt/target", containerRootMount, "/var/tmp"}
+
+	for _, path := range paths {
+		_ = i.unmount(ctx, path)
+	}
+}
+
+// mountContainerStorage монтирует хранилище контейнеров (временный раздел, подтом корневой btrfs
+// или каталог корневого раздела ext4 и xfs) и переносит на него /var/tmp: туда podman распаковывает слои образа.
+func (i *InstallerService) mountContainerStorage(ctx context.Context, plan []plannedPartition, partitions map[string]PartitionInfo) error {
+	switch {
+	case hasRole(plan, RoleTemp):
+		if err := i.mountDisk(ctx, partitions[RoleTemp].Path, containerDir, ""); err != nil {
+			return fmt.Errorf("ошибка монтирования временного раздела: %v", err)
+		}
+	case i.stagedInRootDir(plan):
+		lib.Log.Infof("Хранилище контейнеров размещается в каталоге %s корневого раздела", containerRootDir)
+		if err := i.mountDisk(ctx, partitions[RoleRoot].Path, containerRootMount, ""); err != nil {
+			return fmt.Errorf("ошибка монтирования root раздела: %v", err)
+		}
+		storageDir := containerRootMount + "/" + containerRootDir
+		if err := i.executor.MkdirAll(storageDir, 0700); err != nil {
+			return fmt.Errorf("ошибка создания каталога %s: %v", storageDir, err)
+		}
+		if err := i.executor.MkdirAll(containerDir, 0755); err != nil {
+			return fmt.Errorf("ошибка создания точки монтирования: %v", err)
+		}
+		if err := i.run(ctx, "mount", "--bind", storageDir, containerDir); err != nil {
+			return fmt.Errorf("ошибка монтирования каталога %s: %v", storageDir, err)
+		}
+		i.pushUnmount(containerDir)
+	default:
+		lib.Log.Infof("Хранилище контейнеров размещается в подтоме %s", containerSubVolume)
+		if err := i.mountDisk(ctx, partitions[RoleRoot].Path, containerDir, "subvol="+containerSubVolume); err != nil {
+			return fmt.Errorf("ошибка монтирования подтома %s: %v", containerSubVolume, err)
+		}
+	}
+
+	tmpDir := containerDir + "/tmp"
+
+	if err := i.executor.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	// /var/tmp → /var/lib/containers/tmp
+	if err := i.run(ctx, "mount", "--bind", tmpDir, "/var/tmp"); err != nil {
+		return fmt.Errorf("bind mount failed: %v", err)
 	}
-	defer os.RemoveAll(mountPoint)
+	i.pushUnmount("/var/tmp")
+	return nil
+}
 
-	if err := i.mountDisk(rootPartition, mountPoint, "rw,subvol=/"); err != nil {
+// createBtrfsSubVolumes создаёт подтомы корневой btrfs, а при withContainers — и подтом для хранилища контейнеров.
+func (i *InstallerService) createBtrfsSubVolumes(ctx context.Context, rootPartition string, subVolumes []lib.SubVolumeSpec, withContainers bool) error {
+	mountPoint := "/mnt/btrfs-setup"
+	if err := i.mountDisk(ctx, rootPartition, mountPoint, "rw,subvol=/"); err != nil {
 		return fmt.Errorf("ошибка монтирования Btrfs раздела: %v", err)
 	}
-	defer i.unmountDisk(mountPoint)
+	defer i.releaseMountPoint(ctx, mountPoint)
 
-	subVolumes := []string{"@", "@home", "@var"}
+	names := make([]string, 0, len(subVolumes)+1)
 	for _, subVol := range subVolumes {
+		names = append(names, subVol.Name)
+	}
+	if withContainers {
+		names = append(names, containerSubVolume)
+	}
+	for _, subVol := range names {
 		subVolPath := fmt.Sprintf("%s/%s", mountPoint, subVol)
-		if _, err := os.Stat(subVolPath); os.IsNotExist(err) {
-			cmd := exec.Command("btrfs", "subvolume", "create", subVolPath)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
+		if _, err := i.executor.Stat(subVolPath); os.IsNotExist(err) {
+			if err = i.run(ctx, "btrfs", "subvolume", "create", subVolPath); err != nil {
 				return fmt.Errorf("ошибка создания подтома %s: %v", subVol, err)
 			}
 		} else {
-			lib.Log.Warning("Подтом %s уже существует, пропуск.", subVol)
+			lib.Log.Warningf("Подтом %s уже существует, пропуск.", subVol)
+		}
+	}
+
+	// Атрибут No_COW наследуют только файлы, созданные после его установки, поэтому он задаётся у пустого подтома
+	for _, subVol := range subVolumes {
+		if subVol.NoCow {
+			if err := i.run(ctx, "chattr", "+C", fmt.Sprintf("%s/%s", mountPoint, subVol.Name)); err != nil {
+				return fmt.Errorf("ошибка отключения копирования при записи для подтома %s: %v", subVol.Name, err)
+			}
 		}
 	}
 
 	return nil
 }
 
-// installToFilesystem выполняет установку с использованием bootc
-func (i *InstallerService) installToFilesystem(ctx context.Context) error {
+// stageImage размещает образ в хранилище контейнеров: копирует его с установочного носителя или загружает из реестра.
+// После этого podman запускает образ без обращения к реестру, а при продолжении установки образ не загружается заново.
+func (i *InstallerService) stageImage(ctx context.Context) error {
+	if i.data.ImageSource != "" {
+		return i.importOfflineImage(ctx)
+	}
+	return i.pullImage(ctx)
+}
+
+// pullImage загружает образ из реестра в хранилище контейнеров и показывает прогресс загрузки.
+func (i *InstallerService) pullImage(ctx context.Context) error {
+	lib.Log.Infof("Запущен процесс загрузки образа %s", i.data.Image)
+	i.Status.SetStatus(StatusDownloadImage)
+
+	var progress pullProgress
+	var lastUpdate time.Time
+	updateInterval := 500 * time.Millisecond
+	err := i.runPTY(ctx, Cmd("podman", "pull", i.data.Image), func(line string) {
+		if !progress.parse(line) {
+			return
+		}
+		now := time.Now()
+		if now.Sub(lastUpdate) >= updateInterval {
+			done, total, speed := progress.sum()
+			i.Status.SetProgress(Progress{BytesDone: done, BytesTotal: total, Speed: speed})
+			if total > 0 {
+				i.Status.SetPhasePercent(float64(done) * 100 / float64(total))
+			}
+			lastUpdate = now
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки образа %s: %v", i.data.Image, err)
+	}
+	return nil
+}
+
+// blobProgressPattern разбирает строку хода загрузки слоя в выводе podman pull:
+// «Copying blob 3c5fd1a0b4d2 [=====>-----] 12.0MiB / 50.3MiB | 5.1 MiB/s».
+var blobProgressPattern = regexp.MustCompile(`Copying blob\s+(\S+)\s+\[.*?\]\s+([\d.]+\s*[A-Za-z]+)\s*/\s*([\d.]+\s*[A-Za-z]+)\s*\|\s*([\d.]+\s*[A-Za-z]+)/s`)
+
+// blobDonePattern находит строку о завершённой загрузке слоя: «Copying blob 3c5fd1a0b4d2 done».
+var blobDonePattern = regexp.MustCompile(`Copying blob\s+(\S+)\s+done`)
+
+// pullProgress суммирует ход загрузки слоёв образа, которые podman показывает отдельными строками.
+type pullProgress struct {
+	blobs map[string]*blobProgress
+}
+
+type blobProgress struct {
+	done, total, speed int64
+}
+
+// parse учитывает строку вывода podman pull и сообщает, изменился ли ход загрузки.
+func (p *pullProgress) parse(line string) bool {
+	if p.blobs == nil {
+		p.blobs = make(map[string]*blobProgress)
+	}
+	if matches := blobProgressPattern.FindStringSubmatch(line); matches != nil {
+		done, errDone := parseByteSize(matches[2])
+		total, errTotal := parseByteSize(matches[3])
+		speed, errSpeed := parseByteSize(matches[4])
+		if errDone != nil || errTotal != nil || errSpeed != nil {
+			return false
+		}
+		p.blobs[matches[1]] = &blobProgress{done: done, total: total, speed: speed}
+		return true
+	}
+	if matches := blobDonePattern.FindStringSubmatch(line); matches != nil {
+		if blob, ok := p.blobs[matches[1]]; ok {
+			blob.done, blob.speed = blob.total, 0
+			return true
+		}
+	}
+	return false
+}
+
+// sum возвращает загруженный и полный объём всех известных слоёв и общую скорость загрузки.
+func (p *pullProgress) sum() (done, total, speed int64) {
+	for _, blob := range p.blobs {
+		done += blob.done
+		total += blob.total
+		speed += blob.speed
+	}
+	return done, total, speed
+}
+
+// parseByteSize разбирает размер из вывода podman: 512B, 12.0MiB, 1.5 GB.
+func parseByteSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	number := strings.TrimRightFunc(value, unicode.IsLetter)
+	unit := strings.ToLower(value[len(number):])
+	size, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil {
+		return 0, fmt.Errorf("некорректный размер %q", value)
+	}
+	multipliers := map[string]float64{
+		"b": 1, "kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+		"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+	}
+	multiplier, ok := multipliers[unit]
+	if !ok {
+		return 0, fmt.Errorf("неизвестная единица размера %q", value)
+	}
+	return int64(size * multiplier), nil
+}
+
+// imageDigest возвращает дайджест образа в хранилище контейнеров.
+func (i *InstallerService) imageDigest(ctx context.Context) (string, error) {
+	output, err := i.output(ctx, "podman", "image", "inspect", "--format", "{{.Digest}}", i.data.Image)
+	if err != nil {
+		return "", fmt.Errorf("ошибка получения дайджеста образа %s: %v", i.data.Image, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// runPTY выполняет команду через pty, пишет её строки в лог и передаёт их в onLine.
+// Ошибка содержит последние строки вывода.
+func (i *InstallerService) runPTY(ctx context.Context, cmd Command, onLine func(line string)) error {
+	// Хотим хранить только последние 5 строк для ошибки
+	const maxLines = 5
+	linesBuffer := make([]string, 0, maxLines)
+
+	err := i.executor.RunPTY(ctx, cmd, func(line string) {
+		linesBuffer = append(linesBuffer, line)
+		if len(linesBuffer) > maxLines {
+			linesBuffer = linesBuffer[len(linesBuffer)-maxLines:]
+		}
+
+		lib.Log.Debug(line)
+		onLine(line)
+	})
+	if err != nil && len(linesBuffer) > 0 {
+		return errors.New(strings.Join(linesBuffer, "\n"))
+	}
+	return err
+}
+
+// deploySystem разворачивает образ из хранилища контейнеров на целевые разделы с использованием bootc
+func (i *InstallerService) deploySystem(ctx context.Context) error {
 	i.Status.SetStatus(StatusInstallingSystem)
 
 	mountPoint := "/mnt/target"
-	mountBtrfsVar := "/mnt/btrfs/var"
-	mountBtrfsHome := "/mnt/btrfs/home"
 	mountPointBoot := "/mnt/target/boot"
 	efiMountPoint := "/mnt/target/boot/efi"
 
 	// Получаем именованные разделы (с учетом LUKS если активен)
-	partitions, err := i.getNamedPartitionsWithCrypto()
+	partitions, err := i.getNamedPartitionsWithCrypto(ctx)
 	if err != nil {
 		return fmt.Errorf("ошибка получения разделов: %v", err)
 	}
 
 	// Монтируем разделы
 	if i.data.TypeFilesystem == "btrfs" {
-		if err = i.mountDisk(partitions["root"].Path, mountPoint, "subvol=@"); err != nil {
+		if err = i.mountDisk(ctx, partitions[RoleRoot].Path, mountPoint, "subvol="+rootSubVolume(i.subVolumeLayout()).Name); err != nil {
 			return fmt.Errorf("ошибка монтирования корневого подтома: %v", err)
 		}
 	} else {
-		if err = i.mountDisk(partitions["root"].Path, mountPoint, ""); err != nil {
+		if err = i.mountDisk(ctx, partitions[RoleRoot].Path, mountPoint, ""); err != nil {
 			return fmt.Errorf("ошибка монтирования root раздела: %v", err)
 		}
+		// bootc не удаляет файлы прерванного развёртывания рядом с образом, поэтому корень очищается до монтирования /boot
+		if i.redeploy && i.stagedInRootDir(i.plan) {
+			if err = i.clearStagedRoot(mountPoint); err != nil {
+				return err
+			}
+		}
 	}
 
-	if err = i.mountDisk(partitions["boot"].Path, mountPointBoot, ""); err != nil {
+	if err = i.mountDisk(ctx, partitions[RoleBoot].Path, mountPointBoot, ""); err != nil {
 		return fmt.Errorf("ошибка монтирования boot раздела: %v", err)
 	}
 
-	if err = i.mountDisk(partitions["efi"].Path, efiMountPoint, ""); err != nil {
+	if err = i.mountDisk(ctx, partitions[RoleESP].Path, efiMountPoint, ""); err != nil {
 		return fmt.Errorf("ошибка монтирования EFI раздела: %v", err)
 	}
 
 	// Выполняем установку с использованием bootc
-	installCmd := i.buildBootcCommand(partitions)
+	installCmd := i.buildBootcCommand(ctx, partitions)
+
+	// Контейнер прошлой попытки, оставшийся после аварийного завершения установщика, занимает имя
+	_ = i.run(ctx, "podman", "rm", "--force", "--ignore", installContainerName)
 
-	cmd := exec.CommandContext(ctx, "podman", "run", "--rm", "--privileged", "--pid=host",
+	// Образ уже в хранилище контейнеров, обращение к реестру не требуется
+	cmd := Cmd("podman", "run", "--rm", "--privileged", "--pid=host",
+		"--name", installContainerName,
 		"--security-opt", "label=type:unconfined_t",
 		"-v", containerDir+":/var/lib/containers",
 		"-v", "/dev:/dev",
 		"-v", "/mnt/target:/mnt/target",
 		"--security-opt", "label=disable",
-		i.data.Image,
-		"sh", "-c", installCmd,
+		"--pull=never",
+		i.data.Image, "sh", "-c", installCmd,
 	)
 
-	tmpDir := containerDir + "/tmp"
-
-	if err = os.MkdirAll(tmpDir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
-	}
+	// Завершение podman run не останавливает контейнер, поэтому при отмене он останавливается отдельно
+	i.pushUndo(installContainerName, "остановка контейнера "+installContainerName, func(ctx context.Context) error {
+		return i.run(ctx, "podman", "stop", "--ignore", "--time", strconv.Itoa(containerStopSeconds), installContainerName)
+	})
 
-	// /var/tmp → /var/lib/containers/tmp
-	cmdMount := exec.Command("mount", "--bind", tmpDir, "/var/tmp")
-	if output, err := cmdMount.CombinedOutput(); err != nil {
-		return fmt.Errorf("bind mount failed: %v\n%s", err, string(output))
-	}
-
-	// Устанавливаем переменную окружения для поддержки TTY.
-	env := os.Environ()
-	env = append(env, "TERM=xterm-256color")
-	cmd.Env = env
-
-	lib.Log.Infof("Запущен процесс загрузки и установки образа")
-	i.Status.SetStatus(StatusDownloadImage)
-	// Запускаем команду через pty
-	ptmx, err := pty.Start(cmd)
-	if err != nil {
-		return fmt.Errorf("failed to run command from pty: %v", err)
-	}
-	defer func() { _ = ptmx.Close() }()
-
-	// Устанавливаем размер терминала (опционально)
-	if err = pty.Setsize(ptmx, &pty.Winsize{
-		Rows: 40,
-		Cols: 120,
-	}); err != nil {
-		return err
-	}
-
-	progressRegex := regexp.MustCompile(`Copying blob\s+\S+\s+\[.*?\]\s+([\d\.]+[A-Za-z]+)\s*/\s*([\d\.]+[A-Za-z]+)\s*\|\s*([\d\.]+\s*[A-Za-z/]+)`)
-
-	scanner := bufio.NewScanner(ptmx)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 100*1024*1024)
-
-	// Хотим хранить только последние 5 строк для ошибки
-	const maxLines = 5
-	linesBuffer := make([]string, 0, maxLines)
-
-	var lastUpdate time.Time
-	updateInterval := 500 * time.Millisecond
-	for scanner.Scan() {
-		line := scanner.Text()
-		matches := progressRegex.FindStringSubmatch(line)
-		linesBuffer = append(linesBuffer, line)
-		if len(linesBuffer) > maxLines {
-			linesBuffer = linesBuffer[len(linesBuffer)-maxLines:]
-		}
-
-		fmt.Println(line)
-		if len(matches) == 4 {
-			downloaded := matches[1]
-			total := matches[2]
-			speed := matches[3]
-			progressMsg := fmt.Sprintf("%s из %s, %s", downloaded, total, speed)
-			now := time.Now()
-			if now.Sub(lastUpdate) >= updateInterval {
-				i.Status.SetProgress(progressMsg)
-				lastUpdate = now
-			}
-		} else if strings.Contains(line, "/sysroot/ostree/repo") {
+	lib.Log.Infof("Запущен процесс установки образа")
+	err = i.runPTY(ctx, cmd, func(line string) {
+		if strings.Contains(line, "/sysroot/ostree/repo") {
 			i.Status.SetStatus(StatusCreatedCommit)
+			i.Status.SetPhasePercent(30)
 		} else if strings.Contains(line, "Initializing ostree layout") {
 			i.Status.SetStatus(StatusInstallingSystem)
+			i.Status.SetPhasePercent(10)
 		}
+	})
+	if err != nil {
+		return fmt.Errorf("error install: %v", err)
 	}
+	i.dropUndo(installContainerName)
 
-	if err = scanner.Err(); err != nil {
-		lib.Log.Errorf("error reading output: %v", err)
-	}
+	i.unmountDisk(ctx, efiMountPoint)
+	i.unmountDisk(ctx, mountPointBoot)
+	i.unmountDisk(ctx, mountPoint)
+	return nil
+}
 
-	if err = cmd.Wait(); err != nil {
-		return fmt.Errorf(
-			"error install: %s",
-			strings.Join(linesBuffer, "\n"),
-		)
-	}
+// configureSystem настраивает развёрнутую систему: пользователя, часовой пояс, содержимое /var и /home, fstab.
+// Этап можно выполнить повторно, если прошлая попытка прервалась на нём.
+func (i *InstallerService) configureSystem(ctx context.Context) error {
+	mountPoint := "/mnt/target"
+	mountBtrfs := "/mnt/btrfs"
+	mountPointBoot := "/mnt/target/boot"
+	efiMountPoint := "/mnt/target/boot/efi"
 
-	i.unmountDisk(efiMountPoint)
-	i.unmountDisk(mountPointBoot)
-	i.unmountDisk(mountPoint)
+	partitions, err := i.getNamedPartitionsWithCrypto(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения разделов: %v", err)
+	}
 
 	i.Status.SetStatus(StatusConfiguringSystem)
 	var ostreeDeployPath string
 	if i.data.TypeFilesystem == "btrfs" {
-		if err = i.mountDisk(partitions["root"].Path, mountPoint, "rw,subvol=@"); err != nil {
+		if err = i.mountDisk(ctx, partitions[RoleRoot].Path, mountPoint, "rw,subvol="+rootSubVolume(i.subVolumeLayout()).Name); err != nil {
 			return fmt.Errorf("ошибка повторного монтирования корневого подтома: %v", err)
 		}
 
-		if err = i.mountDisk(partitions["root"].Path, mountBtrfsVar, "subvol=@var"); err != nil {
-			return fmt.Errorf("ошибка монтирования подтома @var: %v", err)
+		// Подтомы и отдельные разделы /var и /home монтируются в /mnt/btrfs так же, как в установленной системе,
+		// поэтому при копировании вложенные каталоги (например, /var/log) попадают в свои подтома
+		volumes, err := i.dataVolumes(ctx, partitions)
+		if err != nil {
+			return err
 		}
-
-		if err = i.mountDisk(partitions["root"].Path, mountBtrfsHome, "subvol=@home"); err != nil {
-			return fmt.Errorf("ошибка монтирования подтома @home: %v", err)
+		for idx, volume := range volumes {
+			if err = i.mountDisk(ctx, volume.Device, mountBtrfs+volume.MountPoint, volume.Options); err != nil {
+				return fmt.Errorf("ошибка монтирования %s: %v", volume.MountPoint, err)
+			}
+			defer i.unmountDisk(ctx, mountBtrfs+volumes[idx].MountPoint)
 		}
 
 		ostreeDeployPath, err = i.findOstreeDeployPath(mountPoint)
@@ -630,11 +1091,11 @@ func (i *InstallerService) installToFilesystem(ctx context.Context) error {
 			return fmt.Errorf("ошибка поиска ostree deploy пути: %v", err)
 		}
 
-		if err = i.configureUserAndRoot(ostreeDeployPath, i.data.User.Login, i.data.User.Password); err != nil {
+		if err = i.configureUserAndRoot(ctx, ostreeDeployPath, i.data.User); err != nil {
 			return fmt.Errorf("ошибка настройки пользователя и root: %v", err)
 		}
 
-		if err = i.configureTimezone(ostreeDeployPath, timezone); err != nil {
+		if err = i.configureTimezone(ctx, ostreeDeployPath, timezone); err != nil {
 			return fmt.Errorf("ошибка установки timezone: %v", err)
 		}
 
@@ -642,14 +1103,24 @@ func (i *InstallerService) installToFilesystem(ctx context.Context) error {
 			return fmt.Errorf("ошибка установки timezone: %v", err)
 		}
 
-		// Копируем содержимое /var в подтом @var
-		if err = i.copyWithRsync(fmt.Sprintf("%s/var/", ostreeDeployPath), mountBtrfsVar); err != nil {
-			return fmt.Errorf("ошибка копирования /var в @var: %v", err)
+		// Копируем содержимое /var, /home и других каталогов развёртывания в подтома
+		for _, volume := range topLevelVolumes(volumes) {
+			src := ostreeDeployPath + volume.MountPoint
+			if volume.MountPoint != "/var" && volume.MountPoint != "/home" {
+				if _, err = i.executor.Stat(src); os.IsNotExist(err) {
+					continue
+				}
+			}
+			if err = i.copyWithRsync(ctx, src+"/", mountBtrfs+volume.MountPoint); err != nil {
+				return fmt.Errorf("ошибка копирования %s: %v", volume.MountPoint, err)
+			}
 		}
 
-		// Копируем содержимое /home в подтом @home
-		if err = i.copyWithRsync(fmt.Sprintf("%s/home/", ostreeDeployPath), mountBtrfsHome); err != nil {
-			return fmt.Errorf("ошибка копирования /home в @home: %v", err)
+		// Исходный снимок делается после копирования: до этого пользователь и его домашний каталог есть только в развёртывании
+		if i.data.Snapshots {
+			if err = i.setupSnapshots(ctx, ostreeDeployPath, partitions); err != nil {
+				return err
+			}
 		}
 
 		//Очищаем содержимое /var внутри ostree
@@ -668,17 +1139,11 @@ func (i *InstallerService) installToFilesystem(ctx context.Context) error {
 		selabeledFilePath := fmt.Sprintf("%s/.ostree-selabeled", varDeployPath)
 		lib.Log.Infof("Создание файла %s", selabeledFilePath)
 
-		file, err := os.Create(selabeledFilePath)
-		if err != nil {
+		if err = i.executor.WriteFile(selabeledFilePath, nil, 0644); err != nil {
 			return fmt.Errorf("ошибка создания файла .ostree-selabeled: %v", err)
 		}
-
-		errFile := file.Close()
-		if errFile != nil {
-			return fmt.Errorf("ошибка очистки содержимого /ostree/deploy/default/var: %v", errFile)
-		}
 	} else {
-		if err = i.mountDisk(partitions["root"].Path, mountPoint, "rw"); err != nil {
+		if err = i.mountDisk(ctx, partitions[RoleRoot].Path, mountPoint, "rw"); err != nil {
 			return fmt.Errorf("ошибка повторного монтирования root раздела: %v", err)
 		}
 
@@ -687,23 +1152,43 @@ func (i *InstallerService) installToFilesystem(ctx context.Context) error {
 			return fmt.Errorf("ошибка поиска ostree deploy пути: %v", err)
 		}
 
-		if err = i.configureUserAndRoot(ostreeDeployPath, i.data.User.Login, i.data.User.Password); err != nil {
+		if err = i.configureUserAndRoot(ctx, ostreeDeployPath, i.data.User); err != nil {
 			return fmt.Errorf("ошибка настройки пользователя и root: %v", err)
 		}
 
 		varDeployPath := filepath.Join(ostreeDeployPath, "../../var/home")
 
 		// Копируем содержимое /home из коммита внутрь varDeployPath
-		if err = i.copyWithRsync(fmt.Sprintf("%s/home/", ostreeDeployPath), varDeployPath); err != nil {
+		if err = i.copyWithRsync(ctx, fmt.Sprintf("%s/home/", ostreeDeployPath), varDeployPath); err != nil {
 			return fmt.Errorf("ошибка копирования /home в @home: %v", err)
 		}
 
+		// Отдельные тома /home и /var (логические тома LVM) получают содержимое, подготовленное на корневом разделе
+		for _, volume := range []struct{ role, src string }{
+			{RoleHome, varDeployPath + "/"},
+			{RoleVar, filepath.Join(ostreeDeployPath, "../../var") + "/"},
+		} {
+			part, ok := partitions[volume.role]
+			if !ok {
+				continue
+			}
+			dataMountPoint := "/mnt/data-volume"
+			if err = i.mountDisk(ctx, part.Path, dataMountPoint, ""); err != nil {
+				return fmt.Errorf("ошибка монтирования %s: %v", part.Path, err)
+			}
+			err = i.copyWithRsync(ctx, volume.src, dataMountPoint)
+			i.unmountDisk(ctx, dataMountPoint)
+			if err != nil {
+				return fmt.Errorf("ошибка копирования в %s: %v", part.Path, err)
+			}
+		}
+
 		// Очищаем содержимое /var внутри ostree
 		if err = i.clearDirectory(fmt.Sprintf("%s/var", ostreeDeployPath)); err != nil {
 			return fmt.Errorf("ошибка очистки содержимого /var: %v", err)
 		}
 
-		if err = i.configureTimezone(ostreeDeployPath, timezone); err != nil {
+		if err = i.configureTimezone(ctx, ostreeDeployPath, timezone); err != nil {
 			return fmt.Errorf("ошибка установки timezone: %v", err)
 		}
 
@@ -712,61 +1197,153 @@ func (i *InstallerService) installToFilesystem(ctx context.Context) error {
 		}
 	}
 
-	if err = i.mountDisk(partitions["boot"].Path, mountPointBoot, "rw"); err != nil {
+	// Перенос содержимого /var и /home — самая долгая часть настройки
+	i.Status.SetPhasePercent(70)
+	if err = i.configureZram(ostreeDeployPath); err != nil {
+		return err
+	}
+	if err = i.configureKeymap(ostreeDeployPath); err != nil {
+		return err
+	}
+
+	if err = i.mountDisk(ctx, partitions[RoleBoot].Path, mountPointBoot, "rw"); err != nil {
 		return fmt.Errorf("ошибка повторного монтирования boot раздела: %v", err)
 	}
 
-	if err = i.mountDisk(partitions["efi"].Path, efiMountPoint, "rw"); err != nil {
+	if err = i.mountDisk(ctx, partitions[RoleESP].Path, efiMountPoint, "rw"); err != nil {
 		return fmt.Errorf("ошибка повторного монтирования EFI раздела: %v", err)
 	}
 
 	// Генерация fstab
 	lib.Log.Infof("Генерация fstab...")
-	if err = i.generateFstab(mountPoint, partitions, i.data.TypeFilesystem); err != nil {
+	if err = i.generateFstab(ctx, mountPoint, partitions); err != nil {
 		return fmt.Errorf("ошибка генерации fstab: %v", err)
 	}
 
-	i.unmountDisk(efiMountPoint)
-	i.unmountDisk(mountPointBoot)
-	if i.data.TypeFilesystem == "btrfs" {
-		i.unmountDisk(mountBtrfsHome)
-		i.unmountDisk(mountBtrfsVar)
+	if err = i.copyESPToMirrors(ctx, partitions[RoleESP], efiMountPoint); err != nil {
+		return err
 	}
+
+	i.unmountDisk(ctx, efiMountPoint)
+	i.unmountDisk(ctx, mountPointBoot)
 	time.Sleep(5 * time.Second)
-	i.unmountDisk(mountPoint)
+	i.unmountDisk(ctx, mountPoint)
+	return nil
+}
+
+// dataVolume — подтом или отдельный раздел, монтируемый вне корня установленной системы.
+type dataVolume struct {
+	MountPoint string
+	Device     string
+	Options    string
+}
+
+// dataVolumes возвращает подтомы корневой btrfs и отдельные разделы /var и /home в порядке монтирования.
+func (i *InstallerService) dataVolumes(ctx context.Context, partitions map[string]PartitionInfo) ([]dataVolume, error) {
+	plan, err := i.partitionPlan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []dataVolume
+	for _, subVol := range rootSubVolumes(i.subVolumeLayout(), plan) {
+		if subVol.MountPoint != "/" {
+			volumes = append(volumes, dataVolume{subVol.MountPoint, partitions[RoleRoot].Path, "subvol=" + subVol.Name})
+		}
+	}
+	for _, role := range []string{RoleVar, RoleHome} {
+		if part, ok := partitions[role]; ok {
+			volumes = append(volumes, dataVolume{"/" + role, part.Path, ""})
+		}
+	}
+	sort.SliceStable(volumes, func(a, b int) bool { return volumes[a].MountPoint < volumes[b].MountPoint })
+	return volumes, nil
+}
+
+// topLevelVolumes возвращает тома, не вложенные в другие: содержимое вложенных копируется вместе с родительским.
+func topLevelVolumes(volumes []dataVolume) []dataVolume {
+	var result []dataVolume
+	for _, volume := range volumes {
+		nested := false
+		for _, parent := range volumes {
+			if strings.HasPrefix(volume.MountPoint, parent.MountPoint+"/") {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			result = append(result, volume)
+		}
+	}
+	return result
+}
+
+// importOfflineImage копирует образ с установочного носителя в хранилище контейнеров под именем из реестра,
+// чтобы podman запустил его без сети, а bootc записал это имя как источник обновлений.
+func (i *InstallerService) importOfflineImage(ctx context.Context) error {
+	i.Status.SetStatus(StatusImportingImage)
+	lib.Log.Infof("Копирование образа %s из %s", i.data.Image, i.data.ImageSource)
+	if err := i.run(ctx, "skopeo", "copy", i.data.ImageSource, "containers-storage:"+i.data.Image); err != nil {
+		return fmt.Errorf("ошибка копирования образа %s: %v", i.data.ImageSource, err)
+	}
 	return nil
 }
 
 // buildBootcCommand создает команду bootc с флагами для LUKS
-func (i *InstallerService) buildBootcCommand(partitions map[string]PartitionInfo) string {
+func (i *InstallerService) buildBootcCommand(ctx context.Context, partitions map[string]PartitionInfo) string {
 	baseCmd := []string{"[ -f /usr/libexec/init-ostree.sh ] && /usr/libexec/init-ostree.sh; bootc install to-filesystem --skip-fetch-check --disable-selinux"}
 
 	if i.data.TypeBoot != "UEFI" {
 		baseCmd = append(baseCmd, "--generic-image")
 	}
 
-	if i.data.IsCryptoFilesystem {
+	// В корне ext4 и xfs уже лежит загруженный образ, поэтому bootc устанавливает систему рядом с ним.
+	// В остальных случаях bootc требует пустой корень, а прерванное развёртывание могло оставить в нём файлы
+	if i.stagedInRootDir(i.plan) {
+		baseCmd = append(baseCmd, "--replace=alongside")
+	} else if i.redeploy {
+		baseCmd = append(baseCmd, "--replace=wipe")
+	}
+
+	// При установке с носителя обновления должны приходить из реестра
+	if i.data.ImageSource != "" {
+		baseCmd = append(baseCmd, "--target-imgref="+i.data.Image)
+	}
+
+	if i.data.IsCryptoFilesystem || i.data.Lvm.Enabled {
 		// UUID boot раздела
-		bootUUID := i.getUUID(partitions["boot"].Path)
+		bootUUID := i.getUUID(ctx, partitions[RoleBoot].Path)
 		baseCmd = append(baseCmd, fmt.Sprintf("--boot-mount-spec=UUID=%s", bootUUID))
 
-		// Путь к зашифрованному разделу
-		baseCmd = append(baseCmd, "--root-mount-spec=/dev/mapper/cryptroot")
+		// Путь к зашифрованному разделу или логическому тому root
+		if i.data.Lvm.Enabled {
+			baseCmd = append(baseCmd, fmt.Sprintf("--root-mount-spec=/dev/mapper/%s-%s", LvmVolumeGroup, RoleRoot))
+			baseCmd = append(baseCmd, fmt.Sprintf("--karg=rd.lvm.lv=%s/%s", LvmVolumeGroup, RoleRoot))
+		} else {
+			baseCmd = append(baseCmd, "--root-mount-spec=/dev/mapper/cryptroot")
+		}
 
-		// UUID исходного раздела для LUKS
-		originalPath := partitions["root"].OriginalPath
-		if originalPath == "" {
-			originalPath = partitions["root"].Path
+		// UUID исходных разделов для LUKS, при RAID1 — на каждом диске
+		if i.data.IsCryptoFilesystem {
+			for idx, originalPath := range partitions[RoleRoot].originalDevices() {
+				rootUUID := i.getUUID(ctx, originalPath)
+				baseCmd = append(baseCmd, fmt.Sprintf("--karg=rd.luks.name=%s=%s", rootUUID, cryptName(idx)))
+			}
+			baseCmd = append(baseCmd, i.tpmKargs(ctx, partitions[RoleRoot].originalDevices())...)
 		}
-		rootUUID := i.getUUID(originalPath)
-		baseCmd = append(baseCmd, fmt.Sprintf("--karg=rd.luks.name=%s=cryptroot", rootUUID))
 
 		// Дополнительные флаги для btrfs
 		if i.data.TypeFilesystem == "btrfs" {
-			baseCmd = append(baseCmd, "--karg=rootflags=subvol=@")
+			baseCmd = append(baseCmd, "--karg=rootflags=subvol="+rootSubVolume(i.subVolumeLayout()).Name)
 		}
 	}
 
+	// Параметры пробуждения после гибернации
+	baseCmd = append(baseCmd, i.resumeKargs(ctx, partitions)...)
+
+	// Раскладка для запроса пароля LUKS в initrd
+	baseCmd = append(baseCmd, i.keymapKargs()...)
+
 	// Добавляем параметры для красивого экрана загрузки и Plymouth
 	baseCmd = append(baseCmd, "--karg=rhgb")
 	baseCmd = append(baseCmd, "--karg=quiet")
@@ -781,7 +1358,7 @@ func (i *InstallerService) buildBootcCommand(partitions map[string]PartitionInfo
 // configureHostname задаёт имя хоста через chroot
 func (i *InstallerService) configureHostname(rootPath, hostname string) error {
 	hostnameFile := filepath.Join(rootPath, "etc", "hostname")
-	if err := os.WriteFile(hostnameFile, []byte(hostname+"\n"), 0644); err != nil {
+	if err := i.executor.WriteFile(hostnameFile, []byte(hostname+"\n"), 0644); err != nil {
 		return fmt.Errorf("ошибка создания /etc/hostname: %v", err)
 	}
 
@@ -790,7 +1367,7 @@ func (i *InstallerService) configureHostname(rootPath, hostname string) error {
 	hostsContent := fmt.Sprintf("127.0.0.1 localhost %s\n::1 localhost %s\n", hostname, hostname)
 
 	// Читаем существующий hosts если есть
-	if existingHosts, err := os.ReadFile(hostsFile); err == nil {
+	if existingHosts, err := i.executor.ReadFile(hostsFile); err == nil {
 		lines := strings.Split(string(existingHosts), "\n")
 		var filteredLines []string
 		for _, line := range lines {
@@ -801,7 +1378,7 @@ func (i *InstallerService) configureHostname(rootPath, hostname string) error {
 		hostsContent = hostsContent + strings.Join(filteredLines, "\n") + "\n"
 	}
 
-	if err := os.WriteFile(hostsFile, []byte(hostsContent), 0644); err != nil {
+	if err := i.executor.WriteFile(hostsFile, []byte(hostsContent), 0644); err != nil {
 		return fmt.Errorf("ошибка обновления /etc/hosts: %v", err)
 	}
 
@@ -810,22 +1387,17 @@ func (i *InstallerService) configureHostname(rootPath, hostname string) error {
 }
 
 // configureTimezone устанавливает тайм-зону в указанном chroot окружении
-func (i *InstallerService) configureTimezone(rootPath string, timezone string) error {
+func (i *InstallerService) configureTimezone(ctx context.Context, rootPath string, timezone string) error {
 	lib.Log.Infof("Настройка таймзоны: %s", timezone)
 	localtimePath := fmt.Sprintf("%s/etc/localtime", rootPath)
 
 	// Удаляем существующий символический линк или файл
-	if _, err := os.Lstat(localtimePath); err == nil {
-		if err := os.Remove(localtimePath); err != nil {
-			return fmt.Errorf("ошибка удаления старого localtime: %v", err)
-		}
+	if err := i.executor.RemoveAll(localtimePath); err != nil {
+		return fmt.Errorf("ошибка удаления старого localtime: %v", err)
 	}
 
 	tzLink := fmt.Sprintf("/usr/share/zoneinfo/%s", timezone)
-	cmd := exec.Command("ln", "-sf", tzLink, localtimePath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := i.run(ctx, "ln", "-sf", tzLink, localtimePath); err != nil {
 		return fmt.Errorf("ошибка создания ссылки на таймзону: %v", err)
 	}
 
@@ -833,54 +1405,65 @@ func (i *InstallerService) configureTimezone(rootPath string, timezone string) e
 	return nil
 }
 
-func (i *InstallerService) configureUserAndRoot(rootPath string, userName string, password string) error {
-	chrootCmd := func(args ...string) *exec.Cmd {
-		cmd := exec.Command("chroot", append([]string{rootPath}, args...)...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		return cmd
+func (i *InstallerService) configureUserAndRoot(ctx context.Context, rootPath string, user User) error {
+	userName := user.Login
+	chroot := func(args ...string) error {
+		return i.run(ctx, "chroot", append([]string{rootPath}, args...)...)
 	}
 
 	varHomePath := fmt.Sprintf("%s/var/home", rootPath)
 	homeDir := fmt.Sprintf("/var/home/%s", userName)
 
 	lib.Log.Infof("Проверка существования каталога /var/home...")
-	if _, err := os.Stat(varHomePath); os.IsNotExist(err) {
+	if _, err := i.executor.Stat(varHomePath); os.IsNotExist(err) {
 		lib.Log.Warningf("Каталог %s не существует. Создаём...", varHomePath)
-		if err = os.MkdirAll(varHomePath, 0755); err != nil {
+		if err = i.executor.MkdirAll(varHomePath, 0755); err != nil {
 			return fmt.Errorf("ошибка создания каталога %s: %v", varHomePath, err)
 		}
 	}
 
-	lib.Log.Infof("Добавление пользователя...")
-	cmd := chrootCmd("adduser", "-m", "-d", fmt.Sprintf("/var/home/%s", userName), "-G", "wheel", userName)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ошибка добавления пользователя %s: %v", userName, err)
+	// Пользователь уже есть, если прошлая попытка прервалась после его создания
+	passwd, _ := i.executor.ReadFile(filepath.Join(rootPath, "etc", "passwd"))
+	if strings.Contains("\n"+string(passwd), "\n"+userName+":") {
+		lib.Log.Warningf("Пользователь %s уже существует, пропуск.", userName)
+	} else {
+		lib.Log.Infof("Добавление пользователя...")
+		if err := chroot("adduser", "-m", "-d", fmt.Sprintf("/var/home/%s", userName), "-G", "wheel", userName); err != nil {
+			return fmt.Errorf("ошибка добавления пользователя %s: %v", userName, err)
+		}
+	}
+
+	// Если задан хэш пароля, передаём его в chpasswd без повторного хэширования.
+	// Пароль передаётся через stdin, чтобы он не попал в список процессов и план установки.
+	password := user.Password
+	chpasswdArgs := []string{rootPath, "chpasswd"}
+	if user.PasswordHash != "" {
+		password = user.PasswordHash
+		chpasswdArgs = append(chpasswdArgs, "-e")
+	}
+	chpasswd := func(login string) error {
+		return i.executor.Run(ctx, Command{Name: "chroot", Args: chpasswdArgs, Stdin: login + ":" + password + "\n"})
 	}
 
 	lib.Log.Infof("Установка пароля пользователя...")
-	cmd = chrootCmd("sh", "-c", fmt.Sprintf("echo '%s:%s' | chpasswd", userName, password))
-	if err := cmd.Run(); err != nil {
+	if err := chpasswd(userName); err != nil {
 		return fmt.Errorf("ошибка установки пароля для пользователя %s: %v", userName, err)
 	}
 
 	lib.Log.Infof("Установка пароля root...")
-	cmd = chrootCmd("sh", "-c", fmt.Sprintf("echo 'root:%s' | chpasswd", password))
-	if err := cmd.Run(); err != nil {
+	if err := chpasswd("root"); err != nil {
 		return fmt.Errorf("ошибка установки пароля для root: %v", err)
 	}
 
 	lib.Log.Infof("Копирование файлов skel...")
-	cmd = chrootCmd(
+	if err := chroot(
 		"sh", "-c",
 		fmt.Sprintf("[ -d /etc/skel ] && cp -r /etc/skel/. %s/", homeDir),
-	)
-	if err := cmd.Run(); err != nil {
+	); err != nil {
 		return fmt.Errorf("ошибка копирования skel: %v", err)
 	}
 
-	cmd = chrootCmd("chown", "-R", fmt.Sprintf("%s:%s", userName, userName), homeDir)
-	if err := cmd.Run(); err != nil {
+	if err := chroot("chown", "-R", fmt.Sprintf("%s:%s", userName, userName), homeDir); err != nil {
 		return fmt.Errorf("ошибка изменения владельца: %v", err)
 	}
 
@@ -889,7 +1472,7 @@ func (i *InstallerService) configureUserAndRoot(rootPath string, userName string
 }
 
 func (i *InstallerService) clearDirectory(path string) error {
-	dirEntries, err := os.ReadDir(path)
+	dirEntries, err := i.executor.ReadDir(path)
 	if err != nil {
 		return fmt.Errorf("ошибка чтения содержимого директории %s: %v", path, err)
 	}
@@ -897,7 +1480,7 @@ func (i *InstallerService) clearDirectory(path string) error {
 	for _, entry := range dirEntries {
 		entryPath := fmt.Sprintf("%s/%s", path, entry.Name())
 
-		if err = os.RemoveAll(entryPath); err != nil {
+		if err = i.executor.RemoveAll(entryPath); err != nil {
 			return fmt.Errorf("ошибка удаления %s: %v", entryPath, err)
 		}
 	}
@@ -905,14 +1488,29 @@ func (i *InstallerService) clearDirectory(path string) error {
 	return nil
 }
 
-// copyWithRsync копирование с использованием команды rsync
-func (i *InstallerService) copyWithRsync(src string, dst string) error {
-	cmd := exec.Command("rsync", "-aHAX", src, dst)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
+// clearStagedRoot удаляет из корня файлы прерванного развёртывания, сохраняя каталог с загруженным образом.
+func (i *InstallerService) clearStagedRoot(mountPoint string) error {
+	entries, err := i.executor.ReadDir(mountPoint)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения содержимого директории %s: %v", mountPoint, err)
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == containerRootDir || entry.Name() == "lost+found" {
+			continue
+		}
+		entryPath := fmt.Sprintf("%s/%s", mountPoint, entry.Name())
+		if err = i.executor.RemoveAll(entryPath); err != nil {
+			return fmt.Errorf("ошибка удаления %s: %v", entryPath, err)
+		}
+	}
+	return nil
+}
 
+// copyWithRsync копирование с использованием команды rsync
+func (i *InstallerService) copyWithRsync(ctx context.Context, src string, dst string) error {
 	lib.Log.Infof("Копирование с использованием rsync: %s -> %s", src, dst)
-	if err := cmd.Run(); err != nil {
+	if err := i.run(ctx, "rsync", "-aHAX", src, dst); err != nil {
 		return fmt.Errorf("ошибка выполнения rsync: %v", err)
 	}
 	return nil
@@ -921,7 +1519,7 @@ func (i *InstallerService) copyWithRsync(src string, dst string) error {
 // findOstreeDeployPath находит путь к папке, заканчивающейся на .0
 func (i *InstallerService) findOstreeDeployPath(mountPoint string) (string, error) {
 	deployPath := fmt.Sprintf("%s/ostree/deploy/default/deploy", mountPoint)
-	entries, err := os.ReadDir(deployPath)
+	entries, err := i.executor.ReadDir(deployPath)
 	if err != nil {
 		return "", fmt.Errorf("ошибка чтения директории %s: %v", deployPath, err)
 	}
@@ -935,7 +1533,7 @@ func (i *InstallerService) findOstreeDeployPath(mountPoint string) (string, erro
 	return "", fmt.Errorf("не найдена папка, в %s", deployPath)
 }
 
-func (i *InstallerService) generateFstab(mountPoint string, partitions map[string]PartitionInfo, rootFileSystem string) error {
+func (i *InstallerService) generateFstab(ctx context.Context, mountPoint string, partitions map[string]PartitionInfo) error {
 	ostreeDeployPath, err := i.findOstreeDeployPath(mountPoint)
 	if err != nil {
 		return fmt.Errorf("ошибка поиска ostree deploy пути: %v", err)
@@ -944,46 +1542,25 @@ func (i *InstallerService) generateFstab(mountPoint string, partitions map[strin
 
 	lib.Log.Infof("Генерация %s...", fstabPath)
 
-	fstabContent := "# Auto generate fstab from atomic-installer installer\n"
-
-	if rootFileSystem == "btrfs" {
-		fstabContent += fmt.Sprintf(
-			"UUID=%s / btrfs subvol=@,compress=zstd:1,x-systemd.device-timeout=0 0 0\n",
-			i.getUUID(partitions["root"].Path),
-		)
-		fstabContent += fmt.Sprintf(
-			"UUID=%s /home btrfs subvol=@home,compress=zstd:1,x-systemd.device-timeout=0 0 0\n",
-			i.getUUID(partitions["root"].Path),
-		)
-		fstabContent += fmt.Sprintf(
-			"UUID=%s /var btrfs subvol=@var,compress=zstd:1,x-systemd.device-timeout=0 0 0\n",
-			i.getUUID(partitions["root"].Path),
-		)
-	} else if rootFileSystem == "ext4" {
-		fstabContent += fmt.Sprintf(
-			"UUID=%s / ext4 defaults 1 1\n",
-			i.getUUID(partitions["root"].Path),
-		)
-	} else {
-		return fmt.Errorf("неизвестная файловая система: %s", rootFileSystem)
+	plan, err := i.partitionPlan(ctx)
+	if err != nil {
+		return err
 	}
 
-	fstabContent += fmt.Sprintf(
-		"UUID=%s /boot ext4 defaults 1 2\n",
-		i.getUUID(partitions["boot"].Path),
-	)
-	fstabContent += fmt.Sprintf(
-		"UUID=%s /boot/efi vfat umask=0077,shortname=winnt 0 2\n",
-		i.getUUID(partitions["efi"].Path),
-	)
-
-	file, err := os.Create(fstabPath)
-	if err != nil {
-		return fmt.Errorf("ошибка создания %s: %v", fstabPath, err)
+	fstabContent := "# Auto generate fstab from atomic-installer installer\n"
+	for _, entry := range fstabEntries(plan, i.subVolumeLayout()) {
+		// UUID раздела подкачки со случайным ключом меняется при каждой загрузке
+		if entry.Role == RoleSwap && i.data.Swap.RandomKey && partitions[RoleSwap].OriginalPath != "" {
+			fstabContent += entry.line(partitions[RoleSwap].Path)
+			continue
+		}
+		fstabContent += entry.line("UUID=" + i.getUUID(ctx, partitions[entry.Role].Path))
+	}
+	if i.data.Swap.Mode == SwapFile {
+		fstabContent += fmt.Sprintf("%s none swap defaults 0 0\n", swapFilePath)
 	}
-	defer file.Close()
 
-	if _, err = file.WriteString(fstabContent); err != nil {
+	if err = i.executor.WriteFile(fstabPath, []byte(fstabContent), 0644); err != nil {
 		return fmt.Errorf("ошибка записи в %s: %v", fstabPath, err)
 	}
 
@@ -991,7 +1568,7 @@ func (i *InstallerService) generateFstab(mountPoint string, partitions map[strin
 
 	// Создание crypttab для LUKS разделов
 	if i.data.IsCryptoFilesystem {
-		if err := i.generateCrypttab(ostreeDeployPath, partitions); err != nil {
+		if err := i.generateCrypttab(ctx, ostreeDeployPath, partitions); err != nil {
 			return fmt.Errorf("ошибка создания crypttab: %v", err)
 		}
 	}
@@ -999,26 +1576,20 @@ func (i *InstallerService) generateFstab(mountPoint string, partitions map[strin
 	return nil
 }
 
-func (i *InstallerService) generateCrypttab(ostreeDeployPath string, partitions map[string]PartitionInfo) error {
+func (i *InstallerService) generateCrypttab(ctx context.Context, ostreeDeployPath string, partitions map[string]PartitionInfo) error {
 	crypttabPath := fmt.Sprintf("%s/etc/crypttab", ostreeDeployPath)
 	lib.Log.Infof("Генерация %s...", crypttabPath)
 
-	var originalPath string
-	if partitions["root"].OriginalPath != "" {
-		originalPath = partitions["root"].OriginalPath
-	} else {
-		originalPath = partitions["root"].Path
+	// По записи на каждый зашифрованный раздел root, при RAID1 — на каждом диске
+	var crypttabContent string
+	for idx, originalPath := range partitions[RoleRoot].originalDevices() {
+		crypttabContent += fmt.Sprintf("%s UUID=%s none %s\n", cryptName(idx), i.getUUID(ctx, originalPath), i.luksOptions())
 	}
-
-	crypttabContent := fmt.Sprintf("cryptroot UUID=%s none luks\n", i.getUUID(originalPath))
-
-	file, err := os.Create(crypttabPath)
-	if err != nil {
-		return fmt.Errorf("ошибка создания %s: %v", crypttabPath, err)
+	if partitions[RoleSwap].OriginalPath != "" {
+		crypttabContent += i.swapCrypttabLine(ctx, partitions)
 	}
-	defer file.Close()
 
-	if _, err = file.WriteString(crypttabContent); err != nil {
+	if err := i.executor.WriteFile(crypttabPath, []byte(crypttabContent), 0644); err != nil {
 		return fmt.Errorf("ошибка записи в %s: %v", crypttabPath, err)
 	}
 
@@ -1030,65 +1601,89 @@ type PartitionInfo struct {
 	Path         string
 	Number       string
 	OriginalPath string
+	Filesystem   string
+	// Mirrors — разделы той же роли на дополнительных дисках RAID1
+	Mirrors []PartitionInfo
+	// PhysicalVolume — физический том LVM (раздел root или cryptroot), если root — логический том
+	PhysicalVolume string
 }
 
-func (i *InstallerService) getNamedPartitions() (map[string]PartitionInfo, error) {
-	partitions, err := i.getPartitions(i.data.Disk)
+// getNamedPartitions возвращает разделы установочного диска по ролям из плана разметки.
+func (i *InstallerService) getNamedPartitions(ctx context.Context) (map[string]PartitionInfo, error) {
+	plan, err := i.partitionPlan(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	lib.Log.Infof("Список разделов:")
-	for i, partition := range partitions {
-		lib.Log.Infof("Раздел %d: %s", i+1, partition)
-	}
-	if i.data.TypeBoot == "LEGACY" && len(partitions) < 4 {
-		return nil, fmt.Errorf("недостаточно разделов на диске для режима LEGACY")
-	} else if i.data.TypeBoot == "UEFI" && len(partitions) < 3 {
-		return nil, fmt.Errorf("недостаточно разделов на диске для режима UEFI")
-	}
-
-	// Карта с информацией о разделах
 	namedPartitions := make(map[string]PartitionInfo)
-
-	if i.data.TypeBoot == "LEGACY" {
-		namedPartitions["bios"] = PartitionInfo{Path: partitions[0], Number: "1"} // BIOS Boot Partition
-		namedPartitions["efi"] = PartitionInfo{Path: partitions[1], Number: "2"}  // EFI Partition
-		namedPartitions["boot"] = PartitionInfo{Path: partitions[2], Number: "3"} // Boot Partition
-		namedPartitions["root"] = PartitionInfo{Path: partitions[3], Number: "4"} // Root Partition
-		namedPartitions["temp"] = PartitionInfo{Path: partitions[4], Number: "5"} // Temporary Partition
-	} else if i.data.TypeBoot == "UEFI" {
-		namedPartitions["efi"] = PartitionInfo{Path: partitions[0], Number: "1"}  // EFI Partition
-		namedPartitions["boot"] = PartitionInfo{Path: partitions[1], Number: "2"} // Boot Partition
-		namedPartitions["root"] = PartitionInfo{Path: partitions[2], Number: "3"} // Root Partition
-		namedPartitions["temp"] = PartitionInfo{Path: partitions[3], Number: "4"} // Temporary Partition
+	for _, part := range plan {
+		namedPartitions[part.Role] = PartitionInfo{
+			Path:       part.Path,
+			Number:     strconv.Itoa(part.Number),
+			Filesystem: part.Filesystem,
+		}
+	}
+	for _, mirrorPlan := range i.mirrors {
+		for _, part := range mirrorPlan {
+			info := namedPartitions[part.Role]
+			info.Mirrors = append(info.Mirrors, PartitionInfo{
+				Path:       part.Path,
+				Number:     strconv.Itoa(part.Number),
+				Filesystem: part.Filesystem,
+			})
+			namedPartitions[part.Role] = info
+		}
 	}
 
 	return namedPartitions, nil
 }
 
-// getNamedPartitionsWithCrypto возвращает разделы с учетом LUKS шифрования
-func (i *InstallerService) getNamedPartitionsWithCrypto() (map[string]PartitionInfo, error) {
-	namedPartitions, err := i.getNamedPartitions()
+func (i *InstallerService) getNamedPartitionsWithCrypto(ctx context.Context) (map[string]PartitionInfo, error) {
+	namedPartitions, err := i.getNamedPartitions(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	// Если используется LUKS, обновляем путь root раздела
 	if i.data.IsCryptoFilesystem {
-		rootInfo := namedPartitions["root"]
-		rootInfo.OriginalPath = rootInfo.Path
-		rootInfo.Path = "/dev/mapper/cryptroot"
-		namedPartitions["root"] = rootInfo
+		namedPartitions = i.withCryptPaths(namedPartitions)
+	}
+
+	// При LVM root находится на логическом томе
+	if i.data.Lvm.Enabled {
+		namedPartitions = withLvmPaths(namedPartitions)
 	}
 
 	return namedPartitions, nil
 }
 
+// withCryptPaths заменяет пути root, его копий RAID1 и раздела подкачки на открытые устройства LUKS,
+// сохраняя исходные разделы.
+func (i *InstallerService) withCryptPaths(namedPartitions map[string]PartitionInfo) map[string]PartitionInfo {
+	rootInfo := namedPartitions[RoleRoot]
+	rootInfo.OriginalPath = rootInfo.Path
+	rootInfo.Path = "/dev/mapper/" + cryptName(0)
+	mirrors := make([]PartitionInfo, len(rootInfo.Mirrors))
+	for idx, mirror := range rootInfo.Mirrors {
+		mirror.OriginalPath = mirror.Path
+		mirror.Path = "/dev/mapper/" + cryptName(idx+1)
+		mirrors[idx] = mirror
+	}
+	rootInfo.Mirrors = mirrors
+	namedPartitions[RoleRoot] = rootInfo
+
+	if i.encryptedSwap(i.plan) {
+		swapInfo := namedPartitions[RoleSwap]
+		swapInfo.OriginalPath = swapInfo.Path
+		swapInfo.Path = "/dev/mapper/" + cryptSwapName
+		namedPartitions[RoleSwap] = swapInfo
+	}
+	return namedPartitions
+}
+
 // getPartitionNames возвращает список всех разделов на указанном диске
-func (i *InstallerService) getPartitions(disk string) ([]string, error) {
-	cmd := exec.Command("lsblk", "-ln", "-o", "NAME,TYPE", disk)
-	output, err := cmd.Output()
+func (i *InstallerService) getPartitions(ctx context.Context, disk string) ([]string, error) {
+	output, err := i.output(ctx, "lsblk", "-ln", "-o", "NAME,TYPE", disk)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка выполнения lsblk: %v", err)
 	}
@@ -1106,9 +1701,9 @@ func (i *InstallerService) getPartitions(disk string) ([]string, error) {
 }
 
 // mountDisk монтирует указанный раздел в точку монтирования
-func (i *InstallerService) mountDisk(disk string, mountPoint string, options string) error {
-	fmt.Printf("Монтирование диска %s в %s с опциями '%s'", disk, mountPoint, options)
-	if err := os.MkdirAll(mountPoint, 0755); err != nil {
+func (i *InstallerService) mountDisk(ctx context.Context, disk string, mountPoint string, options string) error {
+	lib.Log.Infof("Монтирование диска %s в %s с опциями '%s'", disk, mountPoint, options)
+	if err := i.executor.MkdirAll(mountPoint, 0755); err != nil {
 		return fmt.Errorf("ошибка создания точки монтирования: %v", err)
 	}
 	var args []string
@@ -1116,28 +1711,46 @@ func (i *InstallerService) mountDisk(disk string, mountPoint string, options str
 		args = append(args, "-o", options)
 	}
 	args = append(args, disk, mountPoint)
-	cmd := exec.Command("mount", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := i.run(ctx, "mount", args...); err != nil {
 		return fmt.Errorf("ошибка монтирования диска: %v", err)
 	}
+	i.pushUnmount(mountPoint)
 	return nil
 }
 
-// unmountDisk размонтирует указанную точку монтирования
-func (i *InstallerService) unmountDisk(mountPoint string) {
+// unmountDisk размонтирует указанную точку монтирования.
+// Отменённый контекст не передаётся в umount: иначе команда не запустится и раздел останется примонтированным.
+func (i *InstallerService) unmountDisk(ctx context.Context, mountPoint string) error {
+	ctx = context.WithoutCancel(ctx)
 	lib.Log.Infof("Размонтирование %s...", mountPoint)
-	cmd := exec.Command("umount", mountPoint)
-	if err := cmd.Run(); err != nil {
+	if err := i.run(ctx, "umount", mountPoint); err != nil {
 		lib.Log.Warningf("Ошибка размонтирования %s: %v", mountPoint, err.Error())
+		return err
+	}
+	i.dropUndo(mountPoint)
+	return nil
+}
+
+// releaseMountPoint размонтирует служебную точку монтирования и удаляет её пустой каталог.
+// Каталог удаляется без рекурсии и только если mountpoint подтверждает, что файловая система отключена:
+// под точкой монтирования может оставаться корень установленной системы.
+func (i *InstallerService) releaseMountPoint(ctx context.Context, mountPoint string) {
+	ctx = context.WithoutCancel(ctx)
+	if err := i.unmountDisk(ctx, mountPoint); err != nil {
+		return
+	}
+	if i.isMounted(ctx, mountPoint) {
+		lib.Log.Warningf("%s всё ещё примонтирован, каталог не удалён", mountPoint)
+		return
+	}
+	if err := i.executor.Remove(mountPoint); err != nil {
+		lib.Log.Warningf("Каталог %s не удалён: %v", mountPoint, err)
 	}
 }
 
 // getUUID возвращает UUID указанного раздела
-func (i *InstallerService) getUUID(disk string) string {
-	cmd := exec.Command("blkid", "-s", "UUID", "-o", "value", disk)
-	output, err := cmd.Output()
+func (i *InstallerService) getUUID(ctx context.Context, disk string) string {
+	output, err := i.output(ctx, "blkid", "-s", "UUID", "-o", "value", disk)
 	if err != nil {
 		lib.Log.Errorf("Ошибка получения UUID для %s: %v", disk, err)
 		return ""
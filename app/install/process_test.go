@@ -0,0 +1,502 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testLuksPassword = "test-luks-password"
+
+// newTestService возвращает сервис установки на диск /dev/vda размером 64 GiB и исполнитель с заготовленным
+// выводом lsblk и blkid: у каждого раздела и у cryptroot свой UUID.
+func newTestService(data InstallerData) (*InstallerService, *FakeExecutor) {
+	executor := NewFakeExecutor().
+		On("lsblk -b -d -n -o SIZE /dev/vda", "68719476736\n", nil).
+		On("lsblk -ln -o NAME,TYPE /dev/vda", "vda disk\nvda1 part\nvda2 part\nvda3 part\nvda4 part\n", nil).
+		On("blkid -s UUID -o value /dev/mapper/cryptroot", "uuid-cryptroot\n", nil)
+	for _, part := range []string{"vda1", "vda2", "vda3", "vda4"} {
+		executor.On("blkid -s UUID -o value /dev/"+part, "uuid-"+part+"\n", nil)
+	}
+	// Развёртывание, которое bootc создал бы в /mnt/target
+	_ = executor.MkdirAll("/mnt/target/ostree/deploy/default/deploy/test.0", 0755)
+
+	data.Image = "registry.example/atomic:latest"
+	data.Disk = "/dev/vda"
+	if data.IsCryptoFilesystem {
+		data.LuksPassword = testLuksPassword
+	}
+	return NewInstallerServiceWithExecutor(data, executor), executor
+}
+
+// manualTable — существующая разметка диска для ручной разметки: ESP, /boot, будущий root и /home.
+const manualTable = `BYT;
+/dev/vda:65536MiB:virtblk:512:512:gpt:Virtio Block Device:;
+1:1.00MiB:601MiB:600MiB:fat32:EFI system partition:boot, esp;
+2:601MiB:2649MiB:2048MiB:ext4:boot:;
+3:2649MiB:40000MiB:37351MiB:btrfs:root:;
+4:40000MiB:65536MiB:25536MiB:ext4:home:;
+`
+
+// assertInOrder проверяет, что команды want выполнены в указанном порядке; между ними допускаются другие команды.
+func assertInOrder(t *testing.T, commands, want []string) {
+	t.Helper()
+	next := 0
+	for _, cmd := range commands {
+		if next < len(want) && cmd == want[next] {
+			next++
+		}
+	}
+	if next < len(want) {
+		t.Errorf("command %q not found in order, executed:\n  %s", want[next], strings.Join(commands, "\n  "))
+	}
+}
+
+func TestInstallDiskAndConfiguration(t *testing.T) {
+	tests := []struct {
+		name       string
+		boot       string
+		filesystem string
+		luks       bool
+		// manual — роли существующих разделов; таблица разделов диска задана в manualTable
+		manual []PartitionAssignment
+		// commands — ключевые команды подготовки диска в порядке выполнения
+		commands []string
+		// noCommands — начала команд, которые не должны выполняться
+		noCommands []string
+		fstab      string
+		// crypttab пуст, если файл не должен создаваться
+		crypttab string
+		// bootc — параметры, которые должны быть в команде bootc, noBootc — которых там быть не должно
+		bootc   []string
+		noBootc []string
+	}{
+		{
+			name: "UEFI ext4", boot: "UEFI", filesystem: "ext4",
+			commands: []string{
+				"wipefs --all /dev/vda",
+				"parted -s /dev/vda mklabel gpt",
+				"parted -s /dev/vda mkpart primary fat32 1MiB 601MiB",
+				"parted -s /dev/vda set 1 boot on",
+				"parted -s /dev/vda mkpart primary ext4 601MiB 2649MiB",
+				"parted -s /dev/vda mkpart primary ext4 2649MiB 100%",
+				"mkfs.vfat -F32 /dev/vda1",
+				"mkfs.ext4 /dev/vda2",
+				"mkfs.ext4 /dev/vda3",
+				"mount /dev/vda3 /mnt/install-root",
+				"mount --bind /mnt/install-root/.install-containers /var/lib/containers",
+				"mount --bind /var/lib/containers/tmp /var/tmp",
+			},
+			fstab: "UUID=uuid-vda3 / ext4 defaults 1 1\n" +
+				"UUID=uuid-vda2 /boot ext4 defaults 1 2\n" +
+				"UUID=uuid-vda1 /boot/efi vfat umask=0077,shortname=winnt 0 2\n",
+			bootc:   []string{"--replace=alongside"},
+			noBootc: []string{"--generic-image", "--root-mount-spec", "rd.luks"},
+		},
+		{
+			name: "UEFI btrfs", boot: "UEFI", filesystem: "btrfs",
+			commands: []string{
+				"parted -s /dev/vda mkpart primary btrfs 2649MiB 100%",
+				"mkfs.btrfs -f /dev/vda3",
+				"mount -o rw,subvol=/ /dev/vda3 /mnt/btrfs-setup",
+				"btrfs subvolume create /mnt/btrfs-setup/@",
+				"btrfs subvolume create /mnt/btrfs-setup/@home",
+				"btrfs subvolume create /mnt/btrfs-setup/@var",
+				"btrfs subvolume create /mnt/btrfs-setup/@install-containers",
+				"umount /mnt/btrfs-setup",
+				"mount -o subvol=@install-containers /dev/vda3 /var/lib/containers",
+			},
+			fstab: "UUID=uuid-vda3 / btrfs subvol=@,compress=zstd:1,x-systemd.device-timeout=0 0 0\n" +
+				"UUID=uuid-vda2 /boot ext4 defaults 1 2\n" +
+				"UUID=uuid-vda1 /boot/efi vfat umask=0077,shortname=winnt 0 2\n" +
+				"UUID=uuid-vda3 /home btrfs subvol=@home,compress=zstd:1,x-systemd.device-timeout=0 0 0\n" +
+				"UUID=uuid-vda3 /var btrfs subvol=@var,compress=zstd:1,x-systemd.device-timeout=0 0 0\n",
+			noBootc: []string{"--replace", "--generic-image", "--root-mount-spec"},
+		},
+		{
+			name: "UEFI btrfs LUKS", boot: "UEFI", filesystem: "btrfs", luks: true,
+			commands: []string{
+				"parted -s /dev/vda mkpart primary btrfs 2649MiB 100%",
+				"cryptsetup luksFormat --type luks2 --batch-mode --force-password /dev/vda3",
+				"cryptsetup luksOpen /dev/vda3 cryptroot",
+				"cryptsetup luksHeaderBackup /dev/vda3 --header-backup-file /run/atomic-installer/luks-header-vda3.img",
+				"mkfs.btrfs -f /dev/mapper/cryptroot",
+				"mount -o subvol=@install-containers /dev/mapper/cryptroot /var/lib/containers",
+			},
+			fstab: "UUID=uuid-cryptroot / btrfs subvol=@,compress=zstd:1,x-systemd.device-timeout=0 0 0\n" +
+				"UUID=uuid-vda2 /boot ext4 defaults 1 2\n" +
+				"UUID=uuid-vda1 /boot/efi vfat umask=0077,shortname=winnt 0 2\n" +
+				"UUID=uuid-cryptroot /home btrfs subvol=@home,compress=zstd:1,x-systemd.device-timeout=0 0 0\n" +
+				"UUID=uuid-cryptroot /var btrfs subvol=@var,compress=zstd:1,x-systemd.device-timeout=0 0 0\n",
+			crypttab: "cryptroot UUID=uuid-vda3 none luks\n",
+			bootc: []string{
+				"--boot-mount-spec=UUID=uuid-vda2",
+				"--root-mount-spec=/dev/mapper/cryptroot",
+				"--karg=rd.luks.name=uuid-vda3=cryptroot",
+				"--karg=rootflags=subvol=@",
+			},
+			noBootc: []string{"--replace", "--generic-image"},
+		},
+		{
+			name: "LEGACY ext4", boot: "LEGACY", filesystem: "ext4",
+			commands: []string{
+				"parted -s /dev/vda mkpart primary 1MiB 3MiB",
+				"parted -s /dev/vda set 1 bios_grub on",
+				"parted -s /dev/vda mkpart primary fat32 3MiB 1003MiB",
+				"parted -s /dev/vda set 2 boot on",
+				"parted -s /dev/vda mkpart primary ext4 1003MiB 3051MiB",
+				"parted -s /dev/vda mkpart primary ext4 3051MiB 100%",
+				"mkfs.vfat -F32 /dev/vda2",
+				"mkfs.ext4 /dev/vda3",
+				"mkfs.ext4 /dev/vda4",
+				"mount /dev/vda4 /mnt/install-root",
+				"mount --bind /mnt/install-root/.install-containers /var/lib/containers",
+			},
+			fstab: "UUID=uuid-vda4 / ext4 defaults 1 1\n" +
+				"UUID=uuid-vda3 /boot ext4 defaults 1 2\n" +
+				"UUID=uuid-vda2 /boot/efi vfat umask=0077,shortname=winnt 0 2\n",
+			bootc:   []string{"--generic-image", "--replace=alongside"},
+			noBootc: []string{"--root-mount-spec"},
+		},
+		{
+			name: "LEGACY btrfs", boot: "LEGACY", filesystem: "btrfs",
+			commands: []string{
+				"parted -s /dev/vda mkpart primary btrfs 3051MiB 100%",
+				"mkfs.btrfs -f /dev/vda4",
+				"btrfs subvolume create /mnt/btrfs-setup/@install-containers",
+				"mount -o subvol=@install-containers /dev/vda4 /var/lib/containers",
+			},
+			fstab: "UUID=uuid-vda4 / btrfs subvol=@,compress=zstd:1,x-systemd.device-timeout=0 0 0\n" +
+				"UUID=uuid-vda3 /boot ext4 defaults 1 2\n" +
+				"UUID=uuid-vda2 /boot/efi vfat umask=0077,shortname=winnt 0 2\n" +
+				"UUID=uuid-vda4 /home btrfs subvol=@home,compress=zstd:1,x-systemd.device-timeout=0 0 0\n" +
+				"UUID=uuid-vda4 /var btrfs subvol=@var,compress=zstd:1,x-systemd.device-timeout=0 0 0\n",
+			bootc:   []string{"--generic-image"},
+			noBootc: []string{"--replace", "--root-mount-spec"},
+		},
+		{
+			name: "LEGACY ext4 LUKS", boot: "LEGACY", filesystem: "ext4", luks: true,
+			commands: []string{
+				"cryptsetup luksFormat --type luks2 --batch-mode --force-password /dev/vda4",
+				"cryptsetup luksOpen /dev/vda4 cryptroot",
+				"cryptsetup luksHeaderBackup /dev/vda4 --header-backup-file /run/atomic-installer/luks-header-vda4.img",
+				"mkfs.ext4 /dev/mapper/cryptroot",
+				"mount /dev/mapper/cryptroot /mnt/install-root",
+				"mount --bind /mnt/install-root/.install-containers /var/lib/containers",
+			},
+			fstab: "UUID=uuid-cryptroot / ext4 defaults 1 1\n" +
+				"UUID=uuid-vda3 /boot ext4 defaults 1 2\n" +
+				"UUID=uuid-vda2 /boot/efi vfat umask=0077,shortname=winnt 0 2\n",
+			crypttab: "cryptroot UUID=uuid-vda4 none luks\n",
+			bootc: []string{
+				"--generic-image",
+				"--replace=alongside",
+				"--boot-mount-spec=UUID=uuid-vda3",
+				"--root-mount-spec=/dev/mapper/cryptroot",
+				"--karg=rd.luks.name=uuid-vda4=cryptroot",
+			},
+			noBootc: []string{"rootflags"},
+		},
+		{
+			name: "UEFI ext4 manual", boot: "UEFI", filesystem: "ext4",
+			manual: []PartitionAssignment{
+				{Role: RoleESP, Path: "/dev/vda1"},
+				{Role: RoleBoot, Path: "/dev/vda2", Format: true},
+				{Role: RoleRoot, Path: "/dev/vda3", Format: true},
+				{Role: RoleHome, Path: "/dev/vda4"},
+			},
+			commands: []string{
+				"wipefs --all /dev/vda3",
+				"mkfs.ext4 /dev/vda2",
+				"mkfs.ext4 /dev/vda3",
+				"mount /dev/vda3 /mnt/install-root",
+				"mount --bind /mnt/install-root/.install-containers /var/lib/containers",
+				"mount --bind /var/lib/containers/tmp /var/tmp",
+			},
+			noCommands: []string{
+				"parted -s /dev/vda mklabel", "parted -s /dev/vda mkpart",
+				"wipefs --all /dev/vda1", "wipefs --all /dev/vda4", "mkfs.vfat", "mkfs.ext4 /dev/vda4",
+			},
+			fstab: "UUID=uuid-vda3 / ext4 defaults 1 1\n" +
+				"UUID=uuid-vda2 /boot ext4 defaults 1 2\n" +
+				"UUID=uuid-vda1 /boot/efi vfat umask=0077,shortname=winnt 0 2\n" +
+				"UUID=uuid-vda4 /home ext4 defaults 0 2\n",
+			bootc:   []string{"--replace=alongside"},
+			noBootc: []string{"--generic-image", "--root-mount-spec"},
+		},
+	}
+
+	const deployPath = "/mnt/target/ostree/deploy/default/deploy/test.0"
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := InstallerData{
+				TypeBoot:           test.boot,
+				TypeFilesystem:     test.filesystem,
+				IsCryptoFilesystem: test.luks,
+			}
+			if test.manual != nil {
+				data.Partitioning = Partitioning{Mode: ModeManual, Assignments: test.manual}
+			}
+			service, executor := newTestService(data)
+			executor.On("parted -m -s /dev/vda unit MiB print", manualTable, nil)
+			ctx := context.Background()
+
+			if err := service.prepareDisk(ctx); err != nil {
+				t.Fatalf("prepareDisk: %v", err)
+			}
+			assertInOrder(t, executor.Commands(), test.commands)
+			for _, cmd := range executor.Commands() {
+				for _, prefix := range test.noCommands {
+					if strings.HasPrefix(cmd, prefix) {
+						t.Errorf("unexpected command: %s", cmd)
+					}
+				}
+			}
+
+			// Пароль LUKS передаётся только через stdin и не попадает в строку команды
+			for _, cmd := range executor.Calls() {
+				if strings.Contains(cmd.String(), testLuksPassword) {
+					t.Errorf("LUKS password in command line: %s", cmd)
+				}
+				if cmd.Name == "cryptsetup" && (cmd.Args[0] == "luksFormat" || cmd.Args[0] == "luksOpen") && cmd.Stdin != testLuksPassword {
+					t.Errorf("%s: stdin = %q, want the LUKS password", cmd, cmd.Stdin)
+				}
+			}
+
+			partitions, err := service.getNamedPartitionsWithCrypto(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = service.generateFstab(ctx, "/mnt/target", partitions); err != nil {
+				t.Fatalf("generateFstab: %v", err)
+			}
+			fstab, _ := executor.File(deployPath + "/etc/fstab")
+			if want := "# Auto generate fstab from atomic-installer installer\n" + test.fstab; fstab != want {
+				t.Errorf("fstab:\n%s\nwant:\n%s", fstab, want)
+			}
+			crypttab, ok := executor.File(deployPath + "/etc/crypttab")
+			if test.crypttab == "" && ok {
+				t.Errorf("unexpected crypttab:\n%s", crypttab)
+			} else if crypttab != test.crypttab {
+				t.Errorf("crypttab:\n%s\nwant:\n%s", crypttab, test.crypttab)
+			}
+
+			bootc := service.buildBootcCommand(ctx, partitions)
+			if !strings.HasSuffix(bootc, " /mnt/target") {
+				t.Errorf("bootc command does not target /mnt/target: %s", bootc)
+			}
+			for _, flag := range test.bootc {
+				if !strings.Contains(bootc, " "+flag) {
+					t.Errorf("bootc command has no %s: %s", flag, bootc)
+				}
+			}
+			for _, flag := range test.noBootc {
+				if strings.Contains(bootc, flag) {
+					t.Errorf("bootc command has unexpected %s: %s", flag, bootc)
+				}
+			}
+		})
+	}
+}
+
+// Хранилище контейнеров на ext4 удаляется вместе с каталогом в корне, а не удалением раздела и расширением root.
+func TestFinalizeRemovesRootContainerDirectory(t *testing.T) {
+	service, executor := newTestService(InstallerData{TypeBoot: "UEFI", TypeFilesystem: "ext4"})
+	ctx := context.Background()
+	if err := service.prepareDisk(ctx); err != nil {
+		t.Fatalf("prepareDisk: %v", err)
+	}
+	_ = executor.MkdirAll(containerRootMount+"/"+containerRootDir+"/storage", 0700)
+	prepared := len(executor.Calls())
+
+	if err := service.finalizeInstall(ctx); err != nil {
+		t.Fatalf("finalizeInstall: %v", err)
+	}
+	commands := executor.Commands()[prepared:]
+	assertInOrder(t, commands, []string{
+		"umount -l /var/tmp",
+		"umount -l /var/lib/containers",
+		"umount /mnt/install-root",
+	})
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd, "parted") || strings.HasPrefix(cmd, "resize2fs") {
+			t.Errorf("unexpected partition change on finalize: %s", cmd)
+		}
+	}
+	if _, err := executor.Stat(containerRootMount + "/" + containerRootDir); err == nil {
+		t.Errorf("%s was not removed", containerRootDir)
+	}
+}
+
+// Подтом @install-containers удаляется через примонтированный корень btrfs установленной системы.
+// Если его не удалось размонтировать, в том числе после отмены установки, содержимое корня не трогается.
+func TestFinalizeKeepsMountedBtrfsRoot(t *testing.T) {
+	const mountPoint = "/mnt/btrfs-setup"
+	installed := []string{
+		mountPoint + "/@/ostree/deploy/default/deploy/test.0/etc/fstab",
+		mountPoint + "/@home/user/.bashrc",
+	}
+
+	tests := []struct {
+		name string
+		// setup задаёт ответы исполнителя; cancel отменяет контекст этапа завершения
+		setup       func(executor *FakeExecutor, cancel context.CancelFunc)
+		installed   bool
+		wantRemoved bool
+	}{
+		{
+			name:        "unmounted",
+			setup:       func(*FakeExecutor, context.CancelFunc) {},
+			wantRemoved: true,
+		},
+		{
+			name: "cancelled during finalize",
+			setup: func(executor *FakeExecutor, cancel context.CancelFunc) {
+				executor.OnCall("btrfs subvolume delete", func(Command) (string, error) {
+					cancel()
+					return "", context.Canceled
+				})
+			},
+			installed:   true,
+			wantRemoved: false,
+		},
+		{
+			name: "umount failed",
+			setup: func(executor *FakeExecutor, _ context.CancelFunc) {
+				executor.On("umount "+mountPoint, "", errors.New("target is busy"))
+			},
+			installed:   true,
+			wantRemoved: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service, executor := newTestService(InstallerData{TypeBoot: "UEFI", TypeFilesystem: "btrfs"})
+			if err := service.prepareDisk(context.Background()); err != nil {
+				t.Fatalf("prepareDisk: %v", err)
+			}
+			if test.installed {
+				for _, path := range installed {
+					_ = executor.WriteFile(path, []byte("installed"), 0644)
+				}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			test.setup(executor, cancel)
+			if err := service.finalizeInstall(ctx); err != nil {
+				t.Fatalf("finalizeInstall: %v", err)
+			}
+
+			assertInOrder(t, executor.Commands(), []string{
+				"mount -o rw,subvol=/ /dev/vda3 " + mountPoint,
+				"btrfs subvolume delete " + mountPoint + "/@install-containers",
+				"umount " + mountPoint,
+			})
+			if test.installed {
+				for _, path := range installed {
+					if _, ok := executor.File(path); !ok {
+						t.Errorf("%s was removed", path)
+					}
+				}
+			}
+			if _, err := executor.Stat(mountPoint); (err != nil) != test.wantRemoved {
+				t.Errorf("%s removed = %v, want %v", mountPoint, err != nil, test.wantRemoved)
+			}
+		})
+	}
+}
+
+// Корень btrfs, примонтированный для создания файла подкачки, не удаляется, если его не удалось размонтировать.
+func TestCreateSwapFileKeepsMountedRoot(t *testing.T) {
+	const mountPoint = "/mnt/btrfs-swap"
+	service, executor := newTestService(InstallerData{
+		TypeBoot:       "UEFI",
+		TypeFilesystem: "btrfs",
+		Swap:           SwapOptions{Mode: SwapFile},
+	})
+	_ = executor.WriteFile("/proc/meminfo", []byte("MemTotal:        4194304 kB\n"), 0444)
+	_ = executor.WriteFile(mountPoint+"/@/etc/os-release", []byte("installed"), 0644)
+	executor.On("umount "+mountPoint, "", errors.New("target is busy"))
+
+	if err := service.createSwapFile(context.Background(), "/dev/vda3"); err != nil {
+		t.Fatalf("createSwapFile: %v", err)
+	}
+	assertInOrder(t, executor.Commands(), []string{
+		"mount -o rw,subvol=/ /dev/vda3 " + mountPoint,
+		"btrfs filesystem mkswapfile --size 2048M " + mountPoint + "/@swap/swapfile",
+		"umount " + mountPoint,
+	})
+	if _, ok := executor.File(mountPoint + "/@/etc/os-release"); !ok {
+		t.Errorf("root content under %s was removed", mountPoint)
+	}
+}
+
+// Группа томов с тем же именем допустима только на очищаемом диске: её оставила прошлая попытка установки.
+func TestCheckVolumeGroup(t *testing.T) {
+	const diskDevices = "/dev/vda\n/dev/vda1 vfat\n/dev/vda2 ext4\n/dev/vda3 crypto_LUKS\n/dev/mapper/cryptroot LVM2_member\n"
+	tests := []struct {
+		name         string
+		partitioning Partitioning
+		pvs          string
+		wantErr      bool
+	}{
+		{name: "no volume groups", pvs: ""},
+		{name: "other volume group", pvs: "  /dev/sdb2 fedora\n  /dev/sdb3\n"},
+		{name: "previous attempt on target disk", pvs: "  /dev/mapper/cryptroot atomic\n"},
+		{name: "on another disk", pvs: "  /dev/mapper/cryptroot atomic\n  /dev/sdb2 atomic\n", wantErr: true},
+		{
+			name:         "kept partition alongside",
+			partitioning: Partitioning{Mode: ModeAlongside},
+			pvs:          "  /dev/mapper/cryptroot atomic\n",
+			wantErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service, executor := newTestService(InstallerData{
+				TypeBoot:       "UEFI",
+				TypeFilesystem: "ext4",
+				Lvm:            LvmOptions{Enabled: true},
+				Partitioning:   test.partitioning,
+			})
+			executor.On("pvs", test.pvs, nil)
+			executor.On("lsblk -ln -o PATH,FSTYPE /dev/vda", diskDevices, nil)
+
+			err := service.CheckVolumeGroup()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("CheckVolumeGroup() = %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				// Установка отказывает до того, как диск будет изменён, а чужая группа не отключается
+				if err = service.prepareDisk(context.Background()); err == nil {
+					t.Fatal("prepareDisk succeeded with a conflicting volume group")
+				}
+				for _, cmd := range executor.Commands() {
+					if !strings.HasPrefix(cmd, "pvs") && !strings.HasPrefix(cmd, "lsblk") {
+						t.Errorf("unexpected command before refusal: %s", cmd)
+					}
+				}
+			}
+		})
+	}
+}
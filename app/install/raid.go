@@ -0,0 +1,134 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"fmt"
+	"installer/lib"
+	"slices"
+)
+
+// mirroredRoles — разделы, которые при установке на RAID1 создаются на каждом диске.
+// root и boot объединяются в btrfs RAID1, ESP и bios_grub дублируются, чтобы система загружалась с любого диска.
+var mirroredRoles = []string{RoleBiosGrub, RoleESP, RoleBoot, RoleRoot}
+
+// mirrorLayout возвращает схему разметки дополнительного диска RAID1: только дублируемые разделы основной схемы.
+func (l Layout) mirrorLayout() Layout {
+	var mirror Layout
+	for _, part := range l {
+		if slices.Contains(mirroredRoles, part.Role) {
+			mirror = append(mirror, part)
+		}
+	}
+	return mirror
+}
+
+// validateRaid проверяет, что схему можно развернуть на нескольких дисках.
+func (i *InstallerService) validateRaid(layout Layout) error {
+	if i.data.TypeFilesystem != "btrfs" {
+		return fmt.Errorf("RAID1 поддерживается только для btrfs, выбрана файловая система %s", i.data.TypeFilesystem)
+	}
+	for _, part := range layout {
+		if part.Role == RoleTemp {
+			return fmt.Errorf("схема разметки для RAID1 не может содержать раздел %s", RoleTemp)
+		}
+	}
+	for idx, disk := range i.data.Partitioning.Mirrors {
+		if disk == i.data.Disk || slices.Contains(i.data.Partitioning.Mirrors[:idx], disk) {
+			return fmt.Errorf("диск %s указан в RAID1 несколько раз", disk)
+		}
+	}
+	return nil
+}
+
+// planMirrors размечает дополнительные диски RAID1. На каждом диске разделы создаются по той же схеме,
+// что и на основном, поэтому ESP и boot оказываются на всех дисках.
+func (i *InstallerService) planMirrors(ctx context.Context, layout Layout) ([][]plannedPartition, error) {
+	var plans [][]plannedPartition
+	for _, disk := range i.data.Partitioning.Mirrors {
+		diskMiB, err := i.diskSizeMiB(ctx, disk)
+		if err != nil {
+			return nil, err
+		}
+		plan, err := layout.mirrorLayout().Plan(disk, diskMiB, i.data.TypeFilesystem)
+		if err != nil {
+			return nil, err
+		}
+		for idx := range plan {
+			if plan[idx].Role == RoleBoot {
+				plan[idx].Filesystem = "btrfs"
+			}
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// raidFormatCommand возвращает команду создания btrfs RAID1 (данные и метаданные) на нескольких устройствах.
+func raidFormatCommand(devices []string) []string {
+	return append([]string{"mkfs.btrfs", "-f", "-d", "raid1", "-m", "raid1"}, devices...)
+}
+
+// cryptName возвращает имя устройства LUKS для root: cryptroot на основном диске, cryptroot1, cryptroot2… на дополнительных.
+func cryptName(idx int) string {
+	if idx == 0 {
+		return "cryptroot"
+	}
+	return fmt.Sprintf("cryptroot%d", idx)
+}
+
+// devices возвращает пути раздела и его копий на дополнительных дисках RAID1.
+func (p PartitionInfo) devices() []string {
+	devices := []string{p.Path}
+	for _, mirror := range p.Mirrors {
+		devices = append(devices, mirror.Path)
+	}
+	return devices
+}
+
+// originalDevices возвращает физические разделы root и его копий (под LUKS, если шифрование включено).
+func (p PartitionInfo) originalDevices() []string {
+	var devices []string
+	for _, part := range append([]PartitionInfo{p}, p.Mirrors...) {
+		if part.OriginalPath != "" {
+			devices = append(devices, part.OriginalPath)
+		} else {
+			devices = append(devices, part.Path)
+		}
+	}
+	return devices
+}
+
+// copyESPToMirrors копирует загрузчик с основного ESP на ESP дополнительных дисков,
+// чтобы прошивка нашла его при выходе из строя любого из дисков.
+func (i *InstallerService) copyESPToMirrors(ctx context.Context, esp PartitionInfo, efiMountPoint string) error {
+	mountPoint := "/mnt/esp-mirror"
+	for _, mirror := range esp.Mirrors {
+		lib.Log.Infof("Копирование ESP на %s...", mirror.Path)
+		if err := i.mountDisk(ctx, mirror.Path, mountPoint, "rw"); err != nil {
+			return fmt.Errorf("ошибка монтирования ESP %s: %v", mirror.Path, err)
+		}
+		// vfat не хранит владельцев и расширенные атрибуты, поэтому копируются только файлы и время изменения
+		err := i.run(ctx, "rsync", "-rt", efiMountPoint+"/", mountPoint)
+		i.unmountDisk(ctx, mountPoint)
+		if err != nil {
+			return fmt.Errorf("ошибка копирования ESP на %s: %v", mirror.Path, err)
+		}
+	}
+	return nil
+}
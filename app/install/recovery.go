@@ -0,0 +1,160 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"installer/lib"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// recoveryDir — каталог в tmpfs установочной системы для резервных копий заголовков LUKS до их сохранения пользователем.
+const recoveryDir = "/run/atomic-installer"
+
+// recoveryKeyFile — имя файла ключа восстановления при сохранении на внешний носитель.
+const recoveryKeyFile = "luks-recovery-key.txt"
+
+// recoveryKeySlot — слот LUKS2 ключа восстановления. Слот задаётся явно, чтобы при продолжении установки
+// ключ прерванной попытки можно было удалить, не зная его.
+const recoveryKeySlot = 31
+
+// modhexAlphabet — алфавит ключей восстановления systemd: символы одинаково набираются в большинстве раскладок.
+const modhexAlphabet = "cbdefghijklnrtuv"
+
+// GenerateRecoveryKey возвращает случайный ключ восстановления в формате systemd-cryptenroll:
+// 256 бит в виде 8 групп по 8 символов modhex через дефис.
+func GenerateRecoveryKey() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("ошибка генерации ключа восстановления: %v", err)
+	}
+	var key strings.Builder
+	for idx, b := range raw {
+		if idx > 0 && idx%4 == 0 {
+			key.WriteByte('-')
+		}
+		key.WriteByte(modhexAlphabet[b>>4])
+		key.WriteByte(modhexAlphabet[b&0x0f])
+	}
+	return key.String(), nil
+}
+
+// RecoveryKey возвращает ключ восстановления, добавленный при установке, или пустую строку.
+func (i *InstallerService) RecoveryKey() string {
+	return i.recoveryKey
+}
+
+// HeaderBackups возвращает пути резервных копий заголовков LUKS, созданных при установке.
+func (i *InstallerService) HeaderBackups() []string {
+	return i.headerBackups
+}
+
+// luksDevices возвращает разделы, зашифрованные паролем: root на каждом диске и раздел подкачки.
+func luksDevices(partitions map[string]PartitionInfo, randomKeySwap bool) []string {
+	devices := partitions[RoleRoot].originalDevices()
+	if swap := partitions[RoleSwap]; swap.OriginalPath != "" && !randomKeySwap {
+		devices = append(devices, swap.OriginalPath)
+	}
+	return devices
+}
+
+// addRecoveryKey добавляет ключ восстановления в слот recoveryKeySlot каждого раздела LUKS. Существующий пароль
+// и новый ключ передаются через стандартный ввод построчно, поэтому ключ не попадает ни на диск, ни в план.
+func (i *InstallerService) addRecoveryKey(ctx context.Context, partitions map[string]PartitionInfo) error {
+	key, err := GenerateRecoveryKey()
+	if err != nil {
+		return err
+	}
+	for _, device := range luksDevices(partitions, i.data.Swap.RandomKey) {
+		lib.Log.Infof("Добавление ключа восстановления для %s...", device)
+		addKeyCmd := Command{
+			Name:  "cryptsetup",
+			Args:  []string{"luksAddKey", "--batch-mode", "--force-password", "--key-slot", strconv.Itoa(recoveryKeySlot), device},
+			Stdin: i.data.LuksPassword + "\n" + key + "\n",
+		}
+		if err = i.executor.Run(ctx, addKeyCmd); err != nil {
+			return fmt.Errorf("ошибка добавления ключа восстановления для %s: %v", device, err)
+		}
+	}
+	i.recoveryKey = key
+	return nil
+}
+
+// replaceRecoveryKey заменяет ключ восстановления прерванной попытки новым. Ключ не сохраняется в журнале,
+// поэтому прежний слот удаляется, а копии заголовков снимаются заново, чтобы они содержали новый ключ.
+func (i *InstallerService) replaceRecoveryKey(ctx context.Context, partitions map[string]PartitionInfo) error {
+	lib.Log.Infof("Ключ восстановления прерванной установки заменяется новым")
+	for _, device := range luksDevices(partitions, i.data.Swap.RandomKey) {
+		if err := i.run(ctx, "cryptsetup", "luksKillSlot", "--batch-mode", device, strconv.Itoa(recoveryKeySlot)); err != nil {
+			return fmt.Errorf("ошибка удаления ключа восстановления для %s: %v", device, err)
+		}
+	}
+	if err := i.addRecoveryKey(ctx, partitions); err != nil {
+		return err
+	}
+	return i.backupLuksHeaders(ctx, partitions)
+}
+
+// backupLuksHeaders сохраняет заголовки LUKS в recoveryDir. Копия нужна, если заголовок на диске повреждён:
+// без него данные не расшифровать ни паролем, ни ключом восстановления.
+func (i *InstallerService) backupLuksHeaders(ctx context.Context, partitions map[string]PartitionInfo) error {
+	if err := i.executor.MkdirAll(recoveryDir, 0700); err != nil {
+		return fmt.Errorf("ошибка создания каталога %s: %v", recoveryDir, err)
+	}
+	i.headerBackups = nil
+	for _, device := range luksDevices(partitions, i.data.Swap.RandomKey) {
+		backupPath := filepath.Join(recoveryDir, fmt.Sprintf("luks-header-%s.img", filepath.Base(device)))
+		// cryptsetup отказывается перезаписывать существующий файл копии
+		_ = i.executor.RemoveAll(backupPath)
+		lib.Log.Infof("Резервная копия заголовка LUKS %s в %s", device, backupPath)
+		if err := i.run(ctx, "cryptsetup", "luksHeaderBackup", device, "--header-backup-file", backupPath); err != nil {
+			return fmt.Errorf("ошибка резервного копирования заголовка LUKS %s: %v", device, err)
+		}
+		i.headerBackups = append(i.headerBackups, backupPath)
+	}
+	return nil
+}
+
+// ExportRecovery сохраняет ключ восстановления и резервные копии заголовков LUKS в каталог,
+// например на смонтированный внешний носитель. withKey и withHeaders выбирают, что сохранять.
+func (i *InstallerService) ExportRecovery(dir string, withKey, withHeaders bool) error {
+	if withKey && i.recoveryKey != "" {
+		keyPath := filepath.Join(dir, recoveryKeyFile)
+		if err := i.executor.WriteFile(keyPath, []byte(i.recoveryKey+"\n"), 0600); err != nil {
+			return fmt.Errorf("ошибка записи %s: %v", keyPath, err)
+		}
+		lib.Log.Infof("Ключ восстановления сохранён в %s", keyPath)
+	}
+	if withHeaders {
+		for _, backupPath := range i.headerBackups {
+			content, err := i.executor.ReadFile(backupPath)
+			if err != nil {
+				return fmt.Errorf("ошибка чтения %s: %v", backupPath, err)
+			}
+			target := filepath.Join(dir, filepath.Base(backupPath))
+			if err = i.executor.WriteFile(target, content, 0600); err != nil {
+				return fmt.Errorf("ошибка записи %s: %v", target, err)
+			}
+			lib.Log.Infof("Резервная копия заголовка LUKS сохранена в %s", target)
+		}
+	}
+	return nil
+}
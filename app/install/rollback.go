@@ -0,0 +1,107 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"fmt"
+	"installer/lib"
+)
+
+// undoAction отменяет изменение состояния установочной системы: монтирование, открытие LUKS, активацию LVM.
+type undoAction struct {
+	// key — точка монтирования или устройство; по нему действие снимается, когда изменение отменено штатно
+	key         string
+	description string
+	run         func(ctx context.Context) error
+}
+
+// pushUndo регистрирует отмену только что выполненного изменения.
+func (i *InstallerService) pushUndo(key, description string, run func(ctx context.Context) error) {
+	i.undo = append(i.undo, undoAction{key: key, description: description, run: run})
+}
+
+// dropUndo снимает последнюю отмену с ключом key: изменение уже отменено штатным шагом установки.
+func (i *InstallerService) dropUndo(key string) {
+	for idx := len(i.undo) - 1; idx >= 0; idx-- {
+		if i.undo[idx].key == key {
+			i.undo = append(i.undo[:idx], i.undo[idx+1:]...)
+			return
+		}
+	}
+}
+
+// rollback отменяет зарегистрированные изменения в обратном порядке, чтобы после ошибки установку можно было
+// повторить в той же сессии без перезагрузки. Ошибки отмены не прерывают откат остальных изменений.
+func (i *InstallerService) rollback() {
+	if len(i.undo) == 0 {
+		return
+	}
+	lib.Log.Infof("Откат изменений после прерванной установки...")
+
+	// Контекст установки может быть уже отменён, а откат должен выполниться полностью
+	ctx := context.Background()
+	for len(i.undo) > 0 {
+		action := i.undo[len(i.undo)-1]
+		i.undo = i.undo[:len(i.undo)-1]
+		lib.Log.Infof("Откат: %s", action.description)
+		if err := action.run(ctx); err != nil {
+			lib.Log.Warningf("Не удалось выполнить откат (%s): %v", action.description, err)
+			i.Status.Warn(lib.T_("Some changes could not be undone, see the log for details"))
+		}
+	}
+	lib.Log.Infof("Откат изменений завершён.")
+}
+
+// pushUnmount регистрирует размонтирование точки монтирования при откате.
+// Размонтирование не ленивое, чтобы после него можно было закрыть LUKS и отключить LVM.
+func (i *InstallerService) pushUnmount(mountPoint string) {
+	i.pushUndo(mountPoint, fmt.Sprintf("размонтирование %s", mountPoint), func(ctx context.Context) error {
+		if !i.isMounted(ctx, mountPoint) {
+			return nil
+		}
+		return i.run(ctx, "umount", mountPoint)
+	})
+}
+
+// luksOpen открывает раздел LUKS под именем name и регистрирует его закрытие при откате.
+func (i *InstallerService) luksOpen(ctx context.Context, device, name string) error {
+	openCmd := Command{
+		Name:  "cryptsetup",
+		Args:  []string{"luksOpen", device, name},
+		Stdin: i.data.LuksPassword,
+	}
+	if err := i.executor.Run(ctx, openCmd); err != nil {
+		return err
+	}
+	i.pushLuksClose(name)
+	return nil
+}
+
+// pushLuksClose регистрирует закрытие раздела LUKS при откате.
+func (i *InstallerService) pushLuksClose(name string) {
+	i.pushUndo("/dev/mapper/"+name, fmt.Sprintf("закрытие LUKS %s", name), func(ctx context.Context) error {
+		return i.run(ctx, "cryptsetup", "close", name)
+	})
+}
+
+// pushLvmDeactivate регистрирует отключение группы томов при откате, чтобы освободить cryptroot или раздел root.
+func (i *InstallerService) pushLvmDeactivate() {
+	i.pushUndo(LvmVolumeGroup, fmt.Sprintf("отключение группы томов %s", LvmVolumeGroup), func(ctx context.Context) error {
+		return i.run(ctx, "vgchange", "-an", LvmVolumeGroup)
+	})
+}
@@ -0,0 +1,167 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"fmt"
+	"installer/lib"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotSubVolume — подтом со снимками пользовательских данных. Развёртывание откатывается средствами ostree,
+// поэтому снимаются только подтомы /home и /var.
+var snapshotSubVolume = lib.SubVolumeSpec{Name: "@snapshots", MountPoint: "/var/.snapshots", Options: "noatime"}
+
+// baselineSnapshot — снимок, создаваемый сразу после установки; периодическая очистка его не удаляет.
+const baselineSnapshot = "fresh-install"
+
+// snapshotKeep — количество хранимых периодических снимков каждого подтома.
+const snapshotKeep = 14
+
+// snapshotScriptPath — скрипт периодических снимков в /etc развёртывания: /usr атомарной системы неизменяем.
+const snapshotScriptPath = "/etc/atomic-snapshot.sh"
+
+// snapshotScript снимает подтомы из верхнего уровня btrfs, чтобы не зависеть от точек монтирования.
+// Снимки с датой в имени старше последних snapshotKeep удаляются.
+const snapshotScript = `#!/bin/sh
+# Периодические снимки подтомов %[1]s, настроенные установщиком.
+# Снимок %[2]s создан сразу после установки и не удаляется.
+set -e
+KEEP=%[3]d
+TOP=/run/atomic-snapshot
+mkdir -p "$TOP"
+mount -o subvol=/ UUID=%[4]s "$TOP"
+trap 'umount "$TOP"' EXIT
+STAMP=$(date +%%Y-%%m-%%d_%%H%%M%%S)
+for SUBVOL in %[1]s; do
+	DIR="$TOP/%[5]s/$SUBVOL"
+	mkdir -p "$DIR"
+	btrfs subvolume snapshot -r "$TOP/$SUBVOL" "$DIR/$STAMP"
+	ls -1d "$DIR"/2* | sort | head -n -"$KEEP" | while read -r OLD; do
+		btrfs subvolume delete "$OLD"
+	done
+done
+`
+
+const snapshotService = `[Unit]
+Description=Snapshots of /home and /var
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh %s
+`
+
+const snapshotTimer = `[Unit]
+Description=Daily snapshots of /home and /var
+
+[Timer]
+OnCalendar=daily
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`
+
+// withSnapshotSubVolume добавляет подтом для снимков, если его нет в схеме подтомов.
+func withSnapshotSubVolume(subVolumes []lib.SubVolumeSpec) []lib.SubVolumeSpec {
+	for _, subVol := range subVolumes {
+		if subVol.Name == snapshotSubVolume.Name {
+			return subVolumes
+		}
+	}
+	return append(append([]lib.SubVolumeSpec(nil), subVolumes...), snapshotSubVolume)
+}
+
+// snapshotTargets возвращает подтомы /home и /var; отдельные разделы для них не снимаются.
+func snapshotTargets(subVolumes []lib.SubVolumeSpec) []string {
+	var names []string
+	for _, subVol := range subVolumes {
+		if subVol.MountPoint == "/home" || subVol.MountPoint == "/var" {
+			names = append(names, subVol.Name)
+		}
+	}
+	return names
+}
+
+// setupSnapshots создаёт исходные снимки подтомов /home и /var и настраивает таймер systemd
+// для периодических снимков в развёртывании.
+func (i *InstallerService) setupSnapshots(ctx context.Context, ostreeDeployPath string, partitions map[string]PartitionInfo) error {
+	plan, err := i.partitionPlan(ctx)
+	if err != nil {
+		return err
+	}
+	targets := snapshotTargets(rootSubVolumes(i.subVolumeLayout(), plan))
+	if len(targets) == 0 {
+		lib.Log.Warning("/home и /var размещены на отдельных разделах, снимки не настраиваются.")
+		return nil
+	}
+
+	mountPoint := "/mnt/btrfs-snapshots"
+	if err = i.mountDisk(ctx, partitions[RoleRoot].Path, mountPoint, "rw,subvol=/"); err != nil {
+		return fmt.Errorf("ошибка монтирования btrfs для снимков: %v", err)
+	}
+	defer i.unmountDisk(ctx, mountPoint)
+
+	for _, name := range targets {
+		dir := fmt.Sprintf("%s/%s/%s", mountPoint, snapshotSubVolume.Name, name)
+		if err = i.executor.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("ошибка создания каталога снимков %s: %v", dir, err)
+		}
+		// Снимок уже есть, если прошлая попытка прервалась после его создания
+		if _, err = i.executor.Stat(dir + "/" + baselineSnapshot); err == nil {
+			lib.Log.Warningf("Снимок %s подтома %s уже существует, пропуск.", baselineSnapshot, name)
+			continue
+		}
+		lib.Log.Infof("Создание снимка %s подтома %s...", baselineSnapshot, name)
+		if err = i.run(ctx, "btrfs", "subvolume", "snapshot", "-r", fmt.Sprintf("%s/%s", mountPoint, name), dir+"/"+baselineSnapshot); err != nil {
+			return fmt.Errorf("ошибка создания снимка подтома %s: %v", name, err)
+		}
+	}
+
+	uuid := i.getUUID(ctx, partitions[RoleRoot].Path)
+	script := fmt.Sprintf(snapshotScript, strings.Join(targets, " "), baselineSnapshot, snapshotKeep, uuid, snapshotSubVolume.Name)
+	systemdDir := filepath.Join(ostreeDeployPath, "etc/systemd/system")
+	files := []struct {
+		path    string
+		content string
+		perm    os.FileMode
+	}{
+		{filepath.Join(ostreeDeployPath, snapshotScriptPath), script, 0755},
+		{filepath.Join(systemdDir, "atomic-snapshot.service"), fmt.Sprintf(snapshotService, snapshotScriptPath), 0644},
+		{filepath.Join(systemdDir, "atomic-snapshot.timer"), snapshotTimer, 0644},
+	}
+	if err = i.executor.MkdirAll(filepath.Join(systemdDir, "timers.target.wants"), 0755); err != nil {
+		return fmt.Errorf("ошибка создания каталога %s: %v", systemdDir, err)
+	}
+	for _, file := range files {
+		if err = i.executor.WriteFile(file.path, []byte(file.content), file.perm); err != nil {
+			return fmt.Errorf("ошибка записи %s: %v", file.path, err)
+		}
+	}
+
+	// Таймер включается ссылкой, как это делает systemctl enable
+	if err = i.run(ctx, "ln", "-sf", "/etc/systemd/system/atomic-snapshot.timer",
+		filepath.Join(systemdDir, "timers.target.wants", "atomic-snapshot.timer")); err != nil {
+		return fmt.Errorf("ошибка включения таймера снимков: %v", err)
+	}
+
+	lib.Log.Infof("Снимки подтомов %s настроены.", strings.Join(targets, ", "))
+	return nil
+}
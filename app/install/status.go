@@ -19,75 +19,243 @@ package install
 import (
 	"fmt"
 	"installer/lib"
+	"slices"
 	"sync"
+	"time"
 )
 
 // InstallerStatus — тип статуса установки.
 type InstallerStatus int
 
 const (
-	StatusNotStarted = iota
+	StatusNotStarted InstallerStatus = iota
 	StatusCheckingEnvironment
 	StatusDownloadImage
+	StatusImportingImage
 	StatusRemountingTmp
+	StatusResuming
 	StatusPreparingDisk
+	StatusWipingDisk
 	StatusInstallingSystem
 	StatusCreatedCommit
 	StatusConfiguringSystem
 	StatusFinalizingInstallation
 	StatusCompleted
+	StatusRollingBack
 	StatusError
+	StatusCancelled
 )
 
-// SafeStatus — хранилище статуса с каналом уведомлений.
-type SafeStatus struct {
-	mu       sync.Mutex
-	status   InstallerStatus
-	progress string
-	notify   chan struct{}
+// Final сообщает, что статус завершает установку и событий после него не будет.
+func (s InstallerStatus) Final() bool {
+	return s == StatusCompleted || s == StatusError || s == StatusCancelled
 }
 
-// NewSafeStatus создаёт новый SafeStatus с начальными настройками.
-func NewSafeStatus() *SafeStatus {
-	return &SafeStatus{
-		status: StatusNotStarted,
-		notify: make(chan struct{}, 1),
+// phaseWeights — доли этапов в общем ходе установки, в процентах.
+// Больше всего времени обычно занимают загрузка образа и его развёртывание.
+var phaseWeights = map[string]float64{
+	PhaseDisk:      10,
+	PhaseImage:     45,
+	PhaseDeploy:    30,
+	PhaseConfigure: 10,
+	PhaseFinalize:  5,
+}
+
+// Progress — ход подшага этапа: очистки диска, загрузки слоёв образа и т. п.
+type Progress struct {
+	// Step — что выполняется: диск при очистке, раздел при стирании заголовков LUKS
+	Step string
+	// BytesDone и BytesTotal — выполненный и полный объём работы, 0 если объём неизвестен
+	BytesDone  int64
+	BytesTotal int64
+	// Speed — скорость в байтах в секунду, 0 если неизвестна
+	Speed int64
+	// ETA — оставшееся время подшага, 0 если неизвестно
+	ETA time.Duration
+}
+
+// ProgressEvent — событие хода установки. Событие содержит полное состояние, поэтому подписчику
+// достаточно последнего полученного, а промежуточные нужны для журнала и истории.
+type ProgressEvent struct {
+	Status InstallerStatus
+	// Phase — текущий этап установки, пустой до начала первого этапа
+	Phase string
+	// Progress — ход текущего подшага, сбрасывается при смене статуса
+	Progress
+	// PhasePercent — ход текущего этапа, Percent — ход всей установки с учётом весов этапов, 0–100
+	PhasePercent float64
+	Percent      float64
+	// Remaining — оценка оставшегося времени всей установки, 0 пока оценки нет
+	Remaining time.Duration
+	// Warning — предупреждение, не прерывающее установку; передаётся только в своём событии
+	Warning string
+	// Text — описание состояния для пользователя
+	Text string
+	Time time.Time
+}
+
+// ProgressStream — состояние установки и поток событий о его изменении для нескольких подписчиков.
+type ProgressStream struct {
+	mu           sync.Mutex
+	event        ProgressEvent
+	started      time.Time
+	startPercent float64
+	subscribers  []*subscriber
+}
+
+// NewProgressStream создаёт поток событий с начальным статусом StatusNotStarted.
+func NewProgressStream() *ProgressStream {
+	event := ProgressEvent{Status: StatusNotStarted, Time: time.Now()}
+	event.Text = statusText(event)
+	return &ProgressStream{event: event}
+}
+
+// SetStatus устанавливает новый статус и сбрасывает ход подшага прошлого статуса.
+func (s *ProgressStream) SetStatus(newStatus InstallerStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.event.Status = newStatus
+	s.event.Progress = Progress{}
+	s.publish("")
+}
+
+// StartPhase отмечает начало этапа установки. Этапы, пропущенные при продолжении установки,
+// считаются выполненными, а оценка оставшегося времени строится только по этапам текущего запуска.
+func (s *ProgressStream) StartPhase(phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.event.Phase = phase
+	s.event.PhasePercent = 0
+	if s.started.IsZero() {
+		s.started = time.Now()
+		s.startPercent = overallPercent(phase, 0)
 	}
+	s.publish("")
 }
 
-// SetStatus безопасно устанавливает новый статус и уведомляет подписчиков.
-func (s *SafeStatus) SetStatus(newStatus InstallerStatus) {
+// SetPhasePercent обновляет ход текущего этапа.
+func (s *ProgressStream) SetPhasePercent(percent float64) {
 	s.mu.Lock()
-	s.status = newStatus
-	s.mu.Unlock()
-	s.notifyChange()
+	defer s.mu.Unlock()
+	s.event.PhasePercent = min(max(percent, 0), 100)
+	s.publish("")
 }
 
-// SetProgress обновляет строку прогресса.
-func (s *SafeStatus) SetProgress(progress string) {
+// SetProgress обновляет ход подшага текущего статуса.
+func (s *ProgressStream) SetProgress(progress Progress) {
 	s.mu.Lock()
-	s.progress = progress
-	s.mu.Unlock()
-	s.notifyChange()
+	defer s.mu.Unlock()
+	s.event.Progress = progress
+	s.publish("")
 }
 
-// GetStatusText возвращает текстовое описание текущего статуса установки с прогрессом.
-func (s *SafeStatus) GetStatusText() string {
+// Warn передаёт подписчикам предупреждение, не прерывающее установку.
+func (s *ProgressStream) Warn(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish(message)
+}
+
+// Subscribe возвращает канал событий и функцию отписки. Первым приходит текущее состояние.
+// У каждого подписчика своя очередь: события не теряются, а медленный подписчик не задерживает установку.
+// После события с завершающим статусом канал закрывается, а подписчик удаляется из потока.
+func (s *ProgressStream) Subscribe() (<-chan ProgressEvent, func()) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	switch s.status {
+	sub := newSubscriber()
+	unsubscribe := func() {
+		s.mu.Lock()
+		s.subscribers = slices.DeleteFunc(s.subscribers, func(other *subscriber) bool { return other == sub })
+		s.mu.Unlock()
+		sub.close()
+	}
+	sub.push(s.event)
+	s.subscribers = append(s.subscribers, sub)
+	go sub.pump(unsubscribe)
+	return sub.events, unsubscribe
+}
+
+// Event возвращает текущее состояние установки.
+func (s *ProgressStream) Event() ProgressEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.event
+}
+
+// GetStatusText возвращает текстовое описание текущего статуса установки с прогрессом.
+func (s *ProgressStream) GetStatusText() string {
+	return s.Event().Text
+}
+
+// GetStatus возвращает текущий статус установки.
+func (s *ProgressStream) GetStatus() InstallerStatus {
+	return s.Event().Status
+}
+
+// publish пересчитывает общий ход установки и рассылает событие подписчикам. Вызывается под s.mu.
+func (s *ProgressStream) publish(warning string) {
+	event := &s.event
+	event.Time = time.Now()
+	event.Percent = overallPercent(event.Phase, event.PhasePercent)
+	if event.Status == StatusCompleted {
+		event.Percent = 100
+	}
+	event.Remaining = 0
+	if done := event.Percent - s.startPercent; !s.started.IsZero() && done >= 1 && event.Percent < 100 {
+		elapsed := time.Since(s.started)
+		event.Remaining = time.Duration(float64(elapsed) * (100 - event.Percent) / done).Round(time.Second)
+	}
+	event.Text = statusText(*event)
+
+	sent := *event
+	sent.Warning = warning
+	for _, sub := range s.subscribers {
+		sub.push(sent)
+	}
+}
+
+// overallPercent возвращает ход всей установки по ходу этапа: выполненные этапы идут целиком,
+// текущий — пропорционально своему ходу.
+func overallPercent(phase string, phasePercent float64) float64 {
+	var done float64
+	for _, name := range phases {
+		if name == phase {
+			return done + phaseWeights[name]*phasePercent/100
+		}
+		done += phaseWeights[name]
+	}
+	return 0
+}
+
+// statusText возвращает описание состояния для пользователя.
+func statusText(event ProgressEvent) string {
+	switch event.Status {
 	case StatusCreatedCommit:
 		return lib.T_("Creating ostree repository")
 	case StatusDownloadImage:
-		return lib.T_(fmt.Sprintf("Downloading image: %s", s.progress))
+		if event.BytesTotal == 0 {
+			return lib.T_("Downloading image")
+		}
+		return fmt.Sprintf(lib.T_("Downloading image: %s of %s, %s/s"),
+			formatBytes(event.BytesDone), formatBytes(event.BytesTotal), formatBytes(event.Speed))
+	case StatusImportingImage:
+		return lib.T_("Copying image from installation media")
 	case StatusNotStarted:
 		return lib.T_("Starting installation")
 	case StatusCheckingEnvironment:
 		return lib.T_("Checking required commands and environment")
 	case StatusRemountingTmp:
 		return lib.T_("Checking temporary directory")
+	case StatusResuming:
+		return lib.T_("Resuming interrupted installation")
 	case StatusPreparingDisk:
 		return lib.T_("Preparing disk: cleaning, partitioning")
+	case StatusWipingDisk:
+		step := event.Step
+		if event.BytesTotal > 0 {
+			step = fmt.Sprintf(lib.T_("%s: %d%%, about %s left"), event.Step, event.BytesDone*100/event.BytesTotal, event.ETA.Round(time.Second))
+		}
+		return fmt.Sprintf(lib.T_("Erasing disk: %s"), step)
 	case StatusInstallingSystem:
 		return lib.T_("Installing system")
 	case StatusConfiguringSystem:
@@ -96,28 +264,91 @@ func (s *SafeStatus) GetStatusText() string {
 		return lib.T_("Finalizing installation, verification and cleanup")
 	case StatusCompleted:
 		return lib.T_("Installation completed successfully")
+	case StatusRollingBack:
+		return lib.T_("Undoing changes after installation error")
 	case StatusError:
 		return lib.T_("Installation error")
+	case StatusCancelled:
+		return lib.T_("Installation cancelled")
 	default:
 		return lib.T_("Unknown status")
 	}
 }
 
-// GetStatus возвращает текущий статус установки.
-func (s *SafeStatus) GetStatus() InstallerStatus {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.status
+// formatBytes возвращает размер в двоичных единицах: 512 B, 12.3 MiB, 1.5 GiB.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exp])
+}
+
+// subscriber доставляет события одному подписчику через собственную очередь без ограничения длины.
+type subscriber struct {
+	mu     sync.Mutex
+	queue  []ProgressEvent
+	wake   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	events chan ProgressEvent
 }
 
-// NotifyChan возвращает канал уведомлений об изменении статуса.
-func (s *SafeStatus) NotifyChan() <-chan struct{} {
-	return s.notify
+func newSubscriber() *subscriber {
+	return &subscriber{
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		events: make(chan ProgressEvent),
+	}
 }
 
-func (s *SafeStatus) notifyChange() {
+// push ставит событие в очередь, не дожидаясь подписчика.
+func (sub *subscriber) push(event ProgressEvent) {
+	sub.mu.Lock()
+	sub.queue = append(sub.queue, event)
+	sub.mu.Unlock()
 	select {
-	case s.notify <- struct{}{}:
+	case sub.wake <- struct{}{}:
 	default:
 	}
 }
+
+// close прекращает доставку событий; канал подписчика закрывается.
+func (sub *subscriber) close() {
+	sub.once.Do(func() { close(sub.done) })
+}
+
+// pump передаёт события из очереди в канал подписчика, пока тот не отпишется или не придёт завершающий статус.
+// После этого detach удаляет подписчика из потока, чтобы события больше не копились в его очереди.
+func (sub *subscriber) pump(detach func()) {
+	defer close(sub.events)
+	defer detach()
+	for {
+		sub.mu.Lock()
+		queue := sub.queue
+		sub.queue = nil
+		sub.mu.Unlock()
+
+		for _, event := range queue {
+			select {
+			case sub.events <- event:
+			case <-sub.done:
+				return
+			}
+			if event.Status.Final() {
+				return
+			}
+		}
+
+		select {
+		case <-sub.wake:
+		case <-sub.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import "testing"
+
+// Подписчик, получивший завершающий статус, удаляется из потока: поток переиспользуется после отката
+// и повторной попытки, и события не должны копиться в очередях закрытых подписчиков.
+func TestSubscriberDetachedAfterFinalStatus(t *testing.T) {
+	stream := NewProgressStream()
+	events, _ := stream.Subscribe()
+
+	stream.SetStatus(StatusPreparingDisk)
+	stream.SetStatus(StatusError)
+
+	var last ProgressEvent
+	for event := range events {
+		last = event
+	}
+	if last.Status != StatusError {
+		t.Fatalf("last event status = %v, want %v", last.Status, StatusError)
+	}
+
+	stream.SetStatus(StatusPreparingDisk)
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if len(stream.subscribers) != 0 {
+		t.Errorf("%d subscribers left after final status, want 0", len(stream.subscribers))
+	}
+}
+
+func TestUnsubscribeClosesEvents(t *testing.T) {
+	stream := NewProgressStream()
+	events, unsubscribe := stream.Subscribe()
+
+	if event := <-events; event.Status != StatusNotStarted {
+		t.Fatalf("first event status = %v, want current status %v", event.Status, StatusNotStarted)
+	}
+	unsubscribe()
+	for range events {
+	}
+	// Повторная отписка после закрытия канала ничего не делает
+	unsubscribe()
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if len(stream.subscribers) != 0 {
+		t.Errorf("%d subscribers left after unsubscribe, want 0", len(stream.subscribers))
+	}
+}
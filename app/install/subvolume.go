@@ -0,0 +1,135 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"fmt"
+	"installer/lib"
+	"path"
+	"sort"
+	"strings"
+)
+
+// builtinSubVolumes используются, если подтомы не заданы ни в файле ответов, ни в конфигурации.
+var builtinSubVolumes = []lib.SubVolumeSpec{
+	{Name: "@", MountPoint: "/", Options: "compress=zstd:1"},
+	{Name: "@home", MountPoint: "/home", Options: "compress=zstd:1"},
+	{Name: "@var", MountPoint: "/var", Options: "compress=zstd:1"},
+}
+
+// requiredMountPoints — точки монтирования, которые всегда размещаются в отдельных подтомах:
+// /var и /home атомарной системы не входят в развёртывание ostree.
+var requiredMountPoints = []string{"/", "/var", "/home"}
+
+// DefaultSubVolumes возвращает подтомы корневой btrfs из конфигурации или встроенный набор.
+func DefaultSubVolumes() []lib.SubVolumeSpec {
+	if len(lib.Env.BtrfsSubVolumes) > 0 {
+		return lib.Env.BtrfsSubVolumes
+	}
+	return builtinSubVolumes
+}
+
+// ValidateSubVolumes проверяет имена и точки монтирования подтомов.
+func ValidateSubVolumes(subVolumes []lib.SubVolumeSpec) error {
+	names := make(map[string]bool)
+	mountPoints := make(map[string]bool)
+	for idx, subVol := range subVolumes {
+		switch {
+		case subVol.Name == "" || strings.Contains(subVol.Name, "/"):
+			return fmt.Errorf("подтом %d: некорректное имя %q", idx+1, subVol.Name)
+		case subVol.Name == containerSubVolume:
+			return fmt.Errorf("имя подтома %s зарезервировано для загрузки образа", containerSubVolume)
+		case names[subVol.Name]:
+			return fmt.Errorf("подтом %s указан несколько раз", subVol.Name)
+		case !path.IsAbs(subVol.MountPoint) || path.Clean(subVol.MountPoint) != subVol.MountPoint:
+			return fmt.Errorf("подтом %s: некорректная точка монтирования %q", subVol.Name, subVol.MountPoint)
+		case mountPoints[subVol.MountPoint]:
+			return fmt.Errorf("точка монтирования %s указана у нескольких подтомов", subVol.MountPoint)
+		}
+		for _, option := range strings.Split(subVol.Options, ",") {
+			if strings.HasPrefix(option, "subvol=") || strings.HasPrefix(option, "subvolid=") {
+				return fmt.Errorf("подтом %s: параметр %s задаётся установщиком", subVol.Name, option)
+			}
+		}
+		names[subVol.Name] = true
+		mountPoints[subVol.MountPoint] = true
+	}
+
+	for _, mountPoint := range requiredMountPoints {
+		if !mountPoints[mountPoint] {
+			return fmt.Errorf("нет подтома с точкой монтирования %s", mountPoint)
+		}
+	}
+	return nil
+}
+
+// subVolumeLayout возвращает подтомы, выбранные для установки.
+func (i *InstallerService) subVolumeLayout() []lib.SubVolumeSpec {
+	subVolumes := DefaultSubVolumes()
+	if len(i.data.SubVolumes) > 0 {
+		subVolumes = i.data.SubVolumes
+	}
+	if i.data.Snapshots {
+		subVolumes = withSnapshotSubVolume(subVolumes)
+	}
+	if i.data.Swap.Mode == SwapFile {
+		subVolumes = withSwapSubVolume(subVolumes)
+	}
+	return subVolumes
+}
+
+// rootSubVolume возвращает подтом, монтируемый в корень.
+func rootSubVolume(subVolumes []lib.SubVolumeSpec) lib.SubVolumeSpec {
+	for _, subVol := range subVolumes {
+		if subVol.MountPoint == "/" {
+			return subVol
+		}
+	}
+	return builtinSubVolumes[0]
+}
+
+// rootSubVolumes возвращает подтомы корневой btrfs в порядке монтирования. Подтомы для /home и /var не нужны,
+// если для них в плане есть отдельные разделы.
+func rootSubVolumes(subVolumes []lib.SubVolumeSpec, planned []plannedPartition) []lib.SubVolumeSpec {
+	var result []lib.SubVolumeSpec
+	for _, subVol := range subVolumes {
+		if subVol.MountPoint == "/home" && hasRole(planned, RoleHome) || subVol.MountPoint == "/var" && hasRole(planned, RoleVar) {
+			continue
+		}
+		result = append(result, subVol)
+	}
+	sort.SliceStable(result, func(a, b int) bool { return result[a].MountPoint < result[b].MountPoint })
+	return result
+}
+
+// subVolumeMountOptions возвращает параметры монтирования подтома для fstab.
+func subVolumeMountOptions(subVol lib.SubVolumeSpec) string {
+	options := []string{"subvol=" + subVol.Name}
+	if subVol.Options != "" {
+		options = append(options, subVol.Options)
+	}
+	return strings.Join(append(options, "x-systemd.device-timeout=0"), ",")
+}
+
+// SubVolumeNames возвращает имена подтомов через запятую для описания схемы.
+func SubVolumeNames(subVolumes []lib.SubVolumeSpec) string {
+	names := make([]string, 0, len(subVolumes))
+	for _, subVol := range subVolumes {
+		names = append(names, subVol.Name)
+	}
+	return strings.Join(names, ", ")
+}
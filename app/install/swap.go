@@ -0,0 +1,339 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"fmt"
+	"installer/lib"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Способы подкачки
+const (
+	// SwapNone — установщик не настраивает подкачку, настройки образа не меняются
+	SwapNone = "none"
+	// SwapZram — сжатая подкачка в памяти через zram-generator
+	SwapZram = "zram"
+	// SwapPartition — раздел подкачки, а при LVM — логический том swap
+	SwapPartition = "partition"
+	// SwapFile — файл подкачки в подтоме btrfs без копирования при записи
+	SwapFile = "file"
+)
+
+// SwapOptions — способ подкачки и гибернация.
+type SwapOptions struct {
+	Mode string
+	// Hibernate — размер подкачки увеличивается на объём памяти, в параметры ядра добавляется resume=
+	Hibernate bool
+	// RandomKey — при LUKS раздел подкачки шифруется случайным ключом при каждой загрузке, а не ключом root;
+	// образ памяти при этом не переживает перезагрузку, поэтому с гибернацией вариант несовместим
+	RandomKey bool
+}
+
+// cryptSwapName — имя открытого устройства LUKS раздела подкачки.
+const cryptSwapName = "cryptswap"
+
+// swapSubVolume — подтом для файла подкачки: btrfs допускает файл подкачки только без копирования при записи и сжатия.
+var swapSubVolume = lib.SubVolumeSpec{Name: "@swap", MountPoint: "/var/swap", Options: "noatime", NoCow: true}
+
+const swapFilePath = "/var/swap/swapfile"
+
+// zramConfig — настройка zram-generator: половина памяти, но не больше 8 ГиБ.
+const zramConfig = `[zram0]
+zram-size = min(ram / 2, 8192)
+compression-algorithm = zstd
+`
+
+// withSwapSubVolume добавляет подтом для файла подкачки, если его нет в схеме.
+func withSwapSubVolume(subVolumes []lib.SubVolumeSpec) []lib.SubVolumeSpec {
+	for _, subVol := range subVolumes {
+		if subVol.Name == swapSubVolume.Name {
+			return subVolumes
+		}
+	}
+	return append(append([]lib.SubVolumeSpec(nil), subVolumes...), swapSubVolume)
+}
+
+// SwapModes возвращает способы подкачки, доступные для файловой системы root и способа разметки.
+func SwapModes(filesystem string, partitioning Partitioning) []string {
+	modes := []string{SwapNone, SwapZram}
+	if !partitioning.Manual() && !partitioning.Raid() {
+		modes = append(modes, SwapPartition)
+	}
+	// btrfs не поддерживает файлы подкачки на нескольких устройствах
+	if filesystem == "btrfs" && !partitioning.Raid() {
+		modes = append(modes, SwapFile)
+	}
+	return modes
+}
+
+// SwapModeTitle возвращает название способа подкачки для списка выбора.
+func SwapModeTitle(mode string) string {
+	switch mode {
+	case SwapZram:
+		return lib.T_("zram - compressed swap in memory")
+	case SwapPartition:
+		return lib.T_("Swap partition")
+	case SwapFile:
+		return lib.T_("Swap file in the @swap subvolume")
+	}
+	return lib.T_("No swap (image defaults)")
+}
+
+// Validate проверяет совместимость подкачки с файловой системой и способом разметки.
+func (o SwapOptions) Validate(filesystem string, partitioning Partitioning) error {
+	if o.Mode == "" {
+		o.Mode = SwapNone
+	}
+	if !slices.Contains(SwapModes(filesystem, partitioning), o.Mode) {
+		return fmt.Errorf("подкачка %q недоступна для файловой системы %s и выбранной разметки", o.Mode, filesystem)
+	}
+	if o.Hibernate && o.Mode != SwapPartition && o.Mode != SwapFile {
+		return fmt.Errorf("для гибернации нужен раздел или файл подкачки")
+	}
+	if o.Hibernate && o.RandomKey {
+		return fmt.Errorf("гибернация несовместима с шифрованием подкачки случайным ключом")
+	}
+	return nil
+}
+
+// Summary возвращает описание подкачки для сводки.
+func (o SwapOptions) Summary() string {
+	var parts []string
+	switch o.Mode {
+	case SwapZram:
+		parts = append(parts, "zram")
+	case SwapPartition:
+		parts = append(parts, lib.T_("Swap partition"))
+	case SwapFile:
+		parts = append(parts, lib.T_("Swap file"))
+	default:
+		return lib.T_("No")
+	}
+	if o.Hibernate {
+		parts = append(parts, lib.T_("hibernation"))
+	}
+	if o.RandomKey {
+		parts = append(parts, lib.T_("random key"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// swapSizeMiB возвращает размер подкачки: квадратный корень из объёма памяти в ГиБ, округлённый вверх,
+// а для гибернации к нему добавляется весь объём памяти, чтобы поместился её образ.
+func swapSizeMiB(memoryMiB int64, hibernate bool) int64 {
+	size := max(int64(math.Ceil(math.Sqrt(float64(memoryMiB)/1024))), 1) * 1024
+	if hibernate {
+		size += memoryMiB
+	}
+	return size
+}
+
+// memoryMiB возвращает объём оперативной памяти из /proc/meminfo.
+func (i *InstallerService) memoryMiB() (int64, error) {
+	content, err := i.executor.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("ошибка чтения /proc/meminfo: %v", err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kib, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("некорректный объём памяти %q: %v", fields[1], err)
+			}
+			return kib / 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("в /proc/meminfo нет MemTotal")
+}
+
+// swapSize возвращает размер подкачки для текущего объёма памяти в формате схемы разметки.
+func (i *InstallerService) swapSize() (string, error) {
+	memory, err := i.memoryMiB()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%dMiB", swapSizeMiB(memory, i.data.Swap.Hibernate)), nil
+}
+
+// withSwapPartition добавляет раздел подкачки перед root, чтобы root оставался последним и мог быть расширен.
+// Если раздел подкачки уже есть в схеме, используется он. При LVM подкачка создаётся логическим томом.
+func (i *InstallerService) withSwapPartition(layout Layout) (Layout, error) {
+	if i.data.Swap.Mode != SwapPartition || hasSpecRole(layout, RoleSwap) {
+		return layout, nil
+	}
+	size, err := i.swapSize()
+	if err != nil {
+		return nil, err
+	}
+	if i.data.Lvm.Enabled {
+		if i.data.Lvm.SwapSize == "" {
+			i.data.Lvm.SwapSize = size
+		}
+		return layout, nil
+	}
+
+	var result Layout
+	for _, part := range layout {
+		if part.Role == RoleRoot {
+			result = append(result, lib.PartitionSpec{Role: RoleSwap, Size: size, Filesystem: "swap"})
+		}
+		result = append(result, part)
+	}
+	return result, nil
+}
+
+// hasSpecRole сообщает, есть ли в схеме раздел с ролью.
+func hasSpecRole(layout Layout, role string) bool {
+	for _, part := range layout {
+		if part.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptedSwap сообщает, что раздел подкачки шифруется вместе с root. Логический том swap уже находится внутри LUKS.
+func (i *InstallerService) encryptedSwap(planned []plannedPartition) bool {
+	if !i.data.IsCryptoFilesystem {
+		return false
+	}
+	for _, part := range planned {
+		if part.Role == RoleSwap && !part.Logical {
+			return true
+		}
+	}
+	return false
+}
+
+// setupSwapEncryption создаёт LUKS на разделе подкачки с паролем root. При случайном ключе раздел
+// шифруется при каждой загрузке по записи crypttab, поэтому при установке он не изменяется.
+func (i *InstallerService) setupSwapEncryption(ctx context.Context, partitions map[string]PartitionInfo) error {
+	swapPath := partitions[RoleSwap].OriginalPath
+	if i.data.Swap.RandomKey {
+		return nil
+	}
+
+	lib.Log.Infof("Настройка LUKS шифрования для раздела подкачки %s...", swapPath)
+	formatCmd := Command{
+		Name:  "cryptsetup",
+		Args:  i.data.Luks.formatArgs(swapPath),
+		Stdin: i.data.LuksPassword,
+	}
+	if err := i.executor.Run(ctx, formatCmd); err != nil {
+		return fmt.Errorf("ошибка создания LUKS раздела подкачки: %v", err)
+	}
+	if err := i.luksOpen(ctx, swapPath, cryptSwapName); err != nil {
+		return fmt.Errorf("ошибка открытия LUKS раздела подкачки: %v", err)
+	}
+	return nil
+}
+
+// swapCrypttabLine возвращает запись crypttab для раздела подкачки. При случайном ключе UUID меняется при каждой
+// загрузке, поэтому раздел указывается по PARTUUID.
+func (i *InstallerService) swapCrypttabLine(ctx context.Context, partitions map[string]PartitionInfo) string {
+	swapPath := partitions[RoleSwap].OriginalPath
+	if i.data.Swap.RandomKey {
+		output, err := i.output(ctx, "blkid", "-s", "PARTUUID", "-o", "value", swapPath)
+		if err != nil {
+			lib.Log.Errorf("Ошибка получения PARTUUID для %s: %v", swapPath, err)
+		}
+		return fmt.Sprintf("%s PARTUUID=%s /dev/urandom swap,cipher=aes-xts-plain64,size=512\n", cryptSwapName, strings.TrimSpace(string(output)))
+	}
+	return fmt.Sprintf("%s UUID=%s none %s\n", cryptSwapName, i.getUUID(ctx, swapPath), i.luksOptions())
+}
+
+// createSwapFile создаёт файл подкачки в подтоме @swap, а для гибернации запоминает его смещение на устройстве.
+func (i *InstallerService) createSwapFile(ctx context.Context, rootPartition string) error {
+	size, err := i.swapSize()
+	if err != nil {
+		return err
+	}
+
+	mountPoint := "/mnt/btrfs-swap"
+	if err = i.mountDisk(ctx, rootPartition, mountPoint, "rw,subvol=/"); err != nil {
+		return fmt.Errorf("ошибка монтирования btrfs для файла подкачки: %v", err)
+	}
+	defer i.releaseMountPoint(ctx, mountPoint)
+
+	swapFile := filepath.Join(mountPoint, swapSubVolume.Name, filepath.Base(swapFilePath))
+	lib.Log.Infof("Создание файла подкачки %s размером %s...", swapFilePath, size)
+	if err = i.run(ctx, "btrfs", "filesystem", "mkswapfile", "--size", strings.TrimSuffix(size, "iB"), swapFile); err != nil {
+		return fmt.Errorf("ошибка создания файла подкачки: %v", err)
+	}
+
+	if i.data.Swap.Hibernate {
+		output, err := i.output(ctx, "btrfs", "inspect-internal", "map-swapfile", "-r", swapFile)
+		if err != nil {
+			return fmt.Errorf("ошибка определения смещения файла подкачки: %v", err)
+		}
+		i.swapOffset = strings.TrimSpace(string(output))
+	}
+	return nil
+}
+
+// resumeKargs возвращает параметры ядра для пробуждения после гибернации.
+func (i *InstallerService) resumeKargs(ctx context.Context, partitions map[string]PartitionInfo) []string {
+	if !i.data.Swap.Hibernate {
+		return nil
+	}
+
+	switch i.data.Swap.Mode {
+	case SwapFile:
+		// Файл подкачки находится на корневой btrfs, с LUKS — на открытом cryptroot
+		return []string{
+			fmt.Sprintf("--karg=resume=UUID=%s", i.getUUID(ctx, partitions[RoleRoot].Path)),
+			fmt.Sprintf("--karg=resume_offset=%s", i.swapOffset),
+		}
+	case SwapPartition:
+		swap := partitions[RoleSwap]
+		switch {
+		case swap.Path == lvPath(RoleSwap):
+			return []string{
+				fmt.Sprintf("--karg=resume=/dev/mapper/%s-%s", LvmVolumeGroup, RoleSwap),
+				fmt.Sprintf("--karg=rd.lvm.lv=%s/%s", LvmVolumeGroup, RoleSwap),
+			}
+		case swap.OriginalPath != "":
+			// initrd должен открыть раздел подкачки до пробуждения
+			return append([]string{
+				"--karg=resume=/dev/mapper/" + cryptSwapName,
+				fmt.Sprintf("--karg=rd.luks.name=%s=%s", i.getUUID(ctx, swap.OriginalPath), cryptSwapName),
+			}, i.tpmKargs(ctx, []string{swap.OriginalPath})...)
+		default:
+			return []string{fmt.Sprintf("--karg=resume=UUID=%s", i.getUUID(ctx, swap.Path))}
+		}
+	}
+	return nil
+}
+
+// configureZram записывает настройку zram-generator в /etc развёртывания.
+func (i *InstallerService) configureZram(ostreeDeployPath string) error {
+	if i.data.Swap.Mode != SwapZram {
+		return nil
+	}
+	configPath := filepath.Join(ostreeDeployPath, "etc/systemd/zram-generator.conf")
+	if err := i.executor.WriteFile(configPath, []byte(zramConfig), 0644); err != nil {
+		return fmt.Errorf("ошибка записи %s: %v", configPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"fmt"
+	"installer/lib"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultTpmPCRs — регистры PCR по умолчанию: 7 меняется при изменении состояния Secure Boot и его ключей,
+// но не при обновлении ядра, поэтому атомарные обновления не требуют повторной привязки.
+const DefaultTpmPCRs = "7"
+
+// DefaultTpmDevice — TPM2 выбирается systemd автоматически.
+const DefaultTpmDevice = "auto"
+
+// TpmOptions — автоматическая разблокировка LUKS через TPM2.
+type TpmOptions struct {
+	Enabled bool
+	// PCRs — регистры, к которым привязывается ключ, через «+» (например, "7" или "0+7")
+	PCRs string
+	// Device — устройство TPM2 для systemd-cryptenroll: auto, путь /dev/tpmrm0 или TCTI вида swtpm:path=/run/swtpm.sock
+	// для проверки на программном TPM. В установленной системе всегда используется auto.
+	Device string
+}
+
+var pcrNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// pcrs возвращает регистры с учётом значения по умолчанию.
+func (o TpmOptions) pcrs() string {
+	if o.PCRs == "" {
+		return DefaultTpmPCRs
+	}
+	return o.PCRs
+}
+
+// device возвращает устройство TPM2 с учётом значения по умолчанию.
+func (o TpmOptions) device() string {
+	if o.Device == "" {
+		return DefaultTpmDevice
+	}
+	return o.Device
+}
+
+// Validate проверяет список регистров: номера 0–23 или имена, известные systemd (например, secure-boot-policy).
+func (o TpmOptions) Validate() error {
+	if !o.Enabled {
+		return nil
+	}
+	for _, pcr := range strings.Split(o.pcrs(), "+") {
+		if number, err := strconv.Atoi(pcr); err == nil {
+			if number < 0 || number > 23 {
+				return fmt.Errorf("TPM2: номер PCR %d вне диапазона 0–23", number)
+			}
+			continue
+		}
+		if !pcrNamePattern.MatchString(pcr) {
+			return fmt.Errorf("TPM2: некорректный PCR %q", pcr)
+		}
+	}
+	return nil
+}
+
+// Summary возвращает описание привязки для сводки.
+func (o TpmOptions) Summary() string {
+	return fmt.Sprintf("TPM2 (PCR %s)", o.pcrs())
+}
+
+// TpmAvailable сообщает, что в системе есть TPM 2.0 и утилита systemd-cryptenroll.
+func TpmAvailable() bool {
+	if _, err := exec.LookPath("systemd-cryptenroll"); err != nil {
+		return false
+	}
+	version, err := os.ReadFile("/sys/class/tpm/tpm0/tpm_version_major")
+	return err == nil && strings.TrimSpace(string(version)) == "2"
+}
+
+// detectTpm проверяет, что systemd-cryptenroll видит устройство TPM2. Явно заданное устройство
+// (например, программный TPM) не проверяется: ошибка будет видна при привязке.
+func (i *InstallerService) detectTpm(ctx context.Context) bool {
+	if i.data.Tpm.device() != DefaultTpmDevice {
+		return true
+	}
+	output, err := i.output(ctx, "systemd-cryptenroll", "--tpm2-device=list")
+	if err != nil {
+		return false
+	}
+	// Первая строка — заголовок таблицы
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return len(lines) > 1
+}
+
+// enrollTpm добавляет в LUKS разделов root и подкачки слот TPM2. Пароль остаётся в своём слоте, поэтому
+// при отсутствии TPM или ошибке привязки установка продолжается с разблокировкой по паролю.
+func (i *InstallerService) enrollTpm(ctx context.Context, partitions map[string]PartitionInfo) {
+	if !i.data.Tpm.Enabled {
+		return
+	}
+	if !i.detectTpm(ctx) {
+		lib.Log.Warning("TPM2 не найден, диск будет разблокироваться паролем")
+		i.Status.Warn(lib.T_("TPM2 not found, the disk will be unlocked with the passphrase"))
+		return
+	}
+
+	for _, device := range luksDevices(partitions, i.data.Swap.RandomKey) {
+		lib.Log.Infof("Привязка %s к TPM2 (PCR %s)...", device, i.data.Tpm.pcrs())
+		enrollCmd := Command{
+			Name: "systemd-cryptenroll",
+			Args: []string{
+				"--tpm2-device=" + i.data.Tpm.device(),
+				"--tpm2-pcrs=" + i.data.Tpm.pcrs(),
+				"--unlock-key-file=/dev/stdin",
+				device,
+			},
+			Stdin: i.data.LuksPassword,
+		}
+		if err := i.executor.Run(ctx, enrollCmd); err != nil {
+			lib.Log.Warningf("Ошибка привязки %s к TPM2, диск будет разблокироваться паролем: %v", device, err)
+			i.Status.Warn(fmt.Sprintf(lib.T_("%s could not be bound to TPM2, the disk will be unlocked with the passphrase"), device))
+			i.tpmEnrolled = false
+			return
+		}
+	}
+	i.tpmEnrolled = true
+}
+
+// luksOptions возвращает параметры crypttab для разделов, разблокируемых ключом из LUKS.
+func (i *InstallerService) luksOptions() string {
+	if i.tpmEnrolled {
+		return "luks,tpm2-device=auto"
+	}
+	return "luks"
+}
+
+// tpmKargs возвращает параметры ядра, с которыми initrd пробует TPM2 до запроса пароля.
+func (i *InstallerService) tpmKargs(ctx context.Context, devices []string) []string {
+	if !i.tpmEnrolled {
+		return nil
+	}
+	var kargs []string
+	for _, device := range devices {
+		kargs = append(kargs, fmt.Sprintf("--karg=rd.luks.options=%s=tpm2-device=auto", i.getUUID(ctx, device)))
+	}
+	return kargs
+}
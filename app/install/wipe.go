@@ -0,0 +1,192 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package install
+
+import (
+	"context"
+	"fmt"
+	"installer/lib"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Способы очистки диска перед разметкой
+const (
+	// WipeQuick — удаляются только сигнатуры файловых систем и таблица разделов (wipefs)
+	WipeQuick = "quick"
+	// WipeDiscard — все блоки SSD освобождаются через blkdiscard, старые данные больше не читаются
+	WipeDiscard = "discard"
+	// WipeLuks — у старых разделов LUKS стираются слоты ключей, зашифрованные данные нельзя расшифровать
+	WipeLuks = "luks"
+)
+
+// discardChunks — число частей, которыми освобождается диск, чтобы показывать прогресс.
+const discardChunks = 100
+
+// discardBytesPerSecond — ориентировочная скорость blkdiscard для оценки времени до первых замеров.
+const discardBytesPerSecond = 2 << 30
+
+// discardAlign — выравнивание частей blkdiscard: смещение и длина должны быть кратны размеру сектора.
+const discardAlign = 1 << 20
+
+// WipeModes возвращает способы очистки, доступные для дисков: blkdiscard — только если discard поддерживают все.
+func WipeModes(disks ...string) []string {
+	modes := []string{WipeQuick}
+	if !slices.ContainsFunc(disks, func(disk string) bool { return !DiscardSupported(disk) }) {
+		modes = append(modes, WipeDiscard)
+	}
+	return append(modes, WipeLuks)
+}
+
+// WipeModeTitle возвращает название способа очистки для интерфейса.
+func WipeModeTitle(mode string) string {
+	switch mode {
+	case WipeDiscard:
+		return lib.T_("Discard all blocks (SSD)")
+	case WipeLuks:
+		return lib.T_("Erase old LUKS headers")
+	}
+	return lib.T_("Quick wipe")
+}
+
+// DiscardSupported сообщает, поддерживает ли диск discard (TRIM): ненулевой queue/discard_max_bytes в sysfs.
+func DiscardSupported(disk string) bool {
+	content, err := os.ReadFile(discardMaxBytesPath(disk))
+	return err == nil && parseDiscardMaxBytes(content) > 0
+}
+
+func discardMaxBytesPath(disk string) string {
+	return filepath.Join("/sys/block", filepath.Base(disk), "queue/discard_max_bytes")
+}
+
+func parseDiscardMaxBytes(content []byte) int64 {
+	value, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// validateWipe проверяет способ очистки: полная очистка возможна, только если диск размечается заново.
+func (p Partitioning) validateWipe() error {
+	switch p.Wipe {
+	case "", WipeQuick:
+		return nil
+	case WipeDiscard, WipeLuks:
+		if !p.Erase() {
+			return fmt.Errorf("очистка диска %q доступна только при полной переразметке диска", p.Wipe)
+		}
+		return nil
+	}
+	return fmt.Errorf("неизвестный способ очистки диска %q, допустимы: %s, %s, %s", p.Wipe, WipeQuick, WipeDiscard, WipeLuks)
+}
+
+// wipeDisks очищает основной и дополнительные диски выбранным способом перед созданием новой таблицы разделов.
+// Быстрая очистка выполняется вместе с разметкой (wipefs в partedCommands).
+func (i *InstallerService) wipeDisks(ctx context.Context) error {
+	if !i.data.Partitioning.Erase() {
+		return nil
+	}
+	disks := append([]string{i.data.Disk}, i.data.Partitioning.Mirrors...)
+	for _, disk := range disks {
+		var err error
+		switch i.data.Partitioning.Wipe {
+		case WipeDiscard:
+			err = i.discardDisk(ctx, disk)
+		case WipeLuks:
+			err = i.eraseLuksHeaders(ctx, disk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardDisk освобождает все блоки диска через blkdiscard частями, чтобы показывать прогресс и оставшееся время.
+func (i *InstallerService) discardDisk(ctx context.Context, disk string) error {
+	content, err := i.executor.ReadFile(discardMaxBytesPath(disk))
+	if err != nil || parseDiscardMaxBytes(content) == 0 {
+		return fmt.Errorf("диск %s не поддерживает discard", disk)
+	}
+	sizeMiB, err := i.diskSizeMiB(ctx, disk)
+	if err != nil {
+		return err
+	}
+	size := sizeMiB * 1024 * 1024
+
+	estimate := time.Duration(size/discardBytesPerSecond) * time.Second
+	lib.Log.Infof("Освобождение всех блоков диска %s (%d MiB), ожидаемое время: около %s", disk, sizeMiB, estimate)
+	i.Status.SetStatus(StatusWipingDisk)
+	i.Status.SetProgress(Progress{Step: disk, BytesTotal: size, ETA: estimate})
+
+	chunk := max(size/discardChunks/discardAlign*discardAlign, discardAlign)
+	start := time.Now()
+	for offset := int64(0); offset < size; offset += chunk {
+		length := min(chunk, size-offset)
+		if err = i.run(ctx, "blkdiscard", "--offset", strconv.FormatInt(offset, 10), "--length", strconv.FormatInt(length, 10), disk); err != nil {
+			return fmt.Errorf("ошибка освобождения блоков диска %s: %v", disk, err)
+		}
+
+		// Оставшееся время пересчитывается по фактической скорости
+		done := offset + length
+		elapsed := time.Since(start)
+		remaining := time.Duration(float64(elapsed) * float64(size-done) / float64(done))
+		speed := int64(float64(done) / max(elapsed.Seconds(), 1e-3))
+		i.Status.SetProgress(Progress{Step: disk, BytesDone: done, BytesTotal: size, Speed: speed, ETA: remaining})
+		// Очистка — основная часть подготовки диска, на разметку и форматирование остаётся пятая часть этапа
+		i.Status.SetPhasePercent(float64(done) * 80 / float64(size))
+	}
+	lib.Log.Infof("Блоки диска %s освобождены за %s", disk, time.Since(start).Round(time.Second))
+	i.Status.SetStatus(StatusPreparingDisk)
+	return nil
+}
+
+// eraseLuksHeaders стирает слоты ключей всех разделов LUKS на диске: без них старые данные не расшифровать,
+// даже зная пароль. Стирается только заголовок, поэтому операция занимает секунды.
+func (i *InstallerService) eraseLuksHeaders(ctx context.Context, disk string) error {
+	output, err := i.output(ctx, "lsblk", "-ln", "-o", "PATH,FSTYPE", disk)
+	if err != nil {
+		return fmt.Errorf("ошибка поиска разделов LUKS на диске %s: %v", disk, err)
+	}
+	var devices []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "crypto_LUKS" {
+			devices = append(devices, fields[0])
+		}
+	}
+	if len(devices) == 0 {
+		lib.Log.Infof("На диске %s нет разделов LUKS", disk)
+		return nil
+	}
+
+	i.Status.SetStatus(StatusWipingDisk)
+	for idx, device := range devices {
+		i.Status.SetProgress(Progress{Step: fmt.Sprintf("%s %d/%d", device, idx+1, len(devices))})
+		lib.Log.Infof("Стирание заголовка LUKS %s...", device)
+		if err = i.run(ctx, "cryptsetup", "erase", "--batch-mode", device); err != nil {
+			return fmt.Errorf("ошибка стирания заголовка LUKS %s: %v", device, err)
+		}
+	}
+	i.Status.SetStatus(StatusPreparingDisk)
+	return nil
+}
@@ -0,0 +1,129 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package steps
+
+import (
+	"fmt"
+	"installer/app/install"
+	"installer/app/utility"
+	"installer/lib"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gdk/v4"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+)
+
+// Ответы диалога ключа восстановления, не закрывающие его
+const (
+	responseSaveKey     = 1
+	responseSaveHeaders = 2
+)
+
+// showRecoveryDialog один раз показывает ключ восстановления LUKS с QR-кодом и предлагает сохранить
+// его и резервные копии заголовков LUKS на внешний носитель. После закрытия ключ больше не показывается.
+func showRecoveryDialog(parent *gtk.Window, service *install.InstallerService) {
+	key := service.RecoveryKey()
+	headers := service.HeaderBackups()
+	if key == "" && len(headers) == 0 {
+		return
+	}
+
+	dialog := gtk.NewMessageDialog(
+		parent,
+		gtk.DialogModal,
+		gtk.MessageWarning,
+		gtk.ButtonsNone,
+	)
+	dialog.SetTitle(lib.T_("Disk recovery"))
+
+	content := dialog.MessageArea().(*gtk.Box)
+	var secondary []string
+	if key != "" {
+		dialog.Object.SetObjectProperty("text", lib.T_("Write down the recovery key"))
+		secondary = append(secondary, lib.T_("The key unlocks the disk if the password is lost. It is shown only once."))
+
+		png, err := utility.QRCodePNG(key, 256)
+		if err != nil {
+			lib.Log.Errorf("QR code error: %v", err)
+		} else if texture, err := gdk.NewTextureFromBytes(glib.NewBytesWithGo(png)); err == nil {
+			qr := gtk.NewPictureForPaintable(texture)
+			qr.SetSizeRequest(256, 256)
+			qr.SetHAlign(gtk.AlignCenter)
+			content.Append(qr)
+		}
+
+		keyLabel := gtk.NewLabel("")
+		keyLabel.SetUseMarkup(true)
+		keyLabel.SetLabel(fmt.Sprintf("<tt><b>%s</b></tt>", key))
+		keyLabel.SetSelectable(true)
+		keyLabel.SetWrap(true)
+		content.Append(keyLabel)
+
+		dialog.AddButton(lib.T_("Save recovery key"), responseSaveKey)
+	} else {
+		dialog.Object.SetObjectProperty("text", lib.T_("LUKS header backup"))
+	}
+	if len(headers) > 0 {
+		secondary = append(secondary, lib.T_("A damaged LUKS header makes the data unrecoverable. Save its backup to removable media."))
+		dialog.AddButton(lib.T_("Save LUKS header backup"), responseSaveHeaders)
+	}
+	dialog.Object.SetObjectProperty("secondary-text", strings.Join(secondary, "\n\n"))
+	dialog.AddButton(lib.T_("Close"), int(gtk.ResponseClose))
+
+	resultLabel := gtk.NewLabel("")
+	resultLabel.SetWrap(true)
+	resultLabel.SetVisible(false)
+	content.Append(resultLabel)
+
+	dialog.ConnectResponse(func(responseID int) {
+		if responseID != responseSaveKey && responseID != responseSaveHeaders {
+			dialog.Destroy()
+			return
+		}
+
+		chooser := gtk.NewFileChooserNative(
+			lib.T_("Select removable media"),
+			&dialog.Window,
+			gtk.FileChooserActionSelectFolder,
+			lib.T_("Save"),
+			lib.T_("Cancel"),
+		)
+		chooser.SetModal(true)
+		chooser.ConnectResponse(func(chooserResponse int) {
+			defer chooser.Destroy()
+			if chooserResponse != int(gtk.ResponseAccept) || chooser.File() == nil {
+				return
+			}
+			dir := chooser.File().Path()
+
+			resultLabel.SetVisible(true)
+			if err := service.ExportRecovery(dir, responseID == responseSaveKey, responseID == responseSaveHeaders); err != nil {
+				lib.Log.Errorf("Recovery export error: %v", err)
+				resultLabel.SetLabel(fmt.Sprintf("%s: %v", lib.T_("Save error"), err))
+				resultLabel.RemoveCSSClass("success")
+				resultLabel.AddCSSClass("error")
+				return
+			}
+			resultLabel.SetLabel(fmt.Sprintf("%s: %s", lib.T_("Saved"), dir))
+			resultLabel.RemoveCSSClass("error")
+			resultLabel.AddCSSClass("success")
+		})
+		chooser.Show()
+	})
+	dialog.Show()
+}
@@ -18,8 +18,8 @@ package steps
 
 import (
 	"installer/app/image"
+	"installer/app/utility"
 	"installer/lib"
-	"os"
 	"strings"
 
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
@@ -51,7 +51,7 @@ func CreateBootLoaderStep(onBootModeSelected func(string)) gtk.Widgetter {
 	outerBox.Append(centerBox)
 
 	// Проверяем поддержку UEFI
-	uefiSupported := checkUEFISupport()
+	uefiSupported := utility.CheckUEFISupport()
 
 	var choices []string
 	if uefiSupported {
@@ -125,9 +125,3 @@ func CreateBootLoaderStep(onBootModeSelected func(string)) gtk.Widgetter {
 
 	return outerBox
 }
-
-// checkUEFISupport – упрощённая проверка наличия каталога /sys/firmware/efi/efivars
-func checkUEFISupport() bool {
-	_, err := os.Stat("/sys/firmware/efi/efivars")
-	return err == nil
-}
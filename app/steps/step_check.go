@@ -17,13 +17,10 @@
 package steps
 
 import (
-	"context"
 	"fmt"
 	"installer/app/image"
 	"installer/app/utility"
 	"installer/lib"
-	"os/exec"
-	"strings"
 	"time"
 
 	"github.com/diamondburned/gotk4/pkg/glib/v2"
@@ -77,14 +74,14 @@ func CreateCheckDeviceStep(onNext func()) gtk.Widgetter {
 	spinner.Start()
 
 	go func() {
-		diskAvailable, err := checkDiskSize()
+		diskAvailable, err := utility.CheckDiskSize()
 		if err != nil || !diskAvailable {
 			glib.IdleAdd(func() bool {
 				spinner.Stop()
 				if err != nil {
 					label.SetMarkup("<span size='xx-large'><b>" + err.Error() + "</b></span>")
 				} else {
-					label.SetMarkup("<span size='xx-large'><b>" + lib.T_("Insufficient disk space. At least 60GB required") + "</b></span>")
+					label.SetMarkup("<span size='xx-large'><b>" + fmt.Sprintf(lib.T_("Insufficient disk space. At least %dGB required"), utility.MinDiskSizeGB) + "</b></span>")
 				}
 				label.AddCSSClass("error")
 				chooseBtn.SetSensitive(false)
@@ -93,13 +90,16 @@ func CreateCheckDeviceStep(onNext func()) gtk.Widgetter {
 			return
 		}
 
+		// С образами на установочном носителе сеть не нужна, она проверяется при выборе образа из реестра
+		offline := len(utility.GetOfflineImages()) > 0
+
 		firstRun := true
 		for {
 			if firstRun {
 				time.Sleep(2 * time.Second)
 				firstRun = false
 			}
-			connected := utility.CheckInternet()
+			connected := offline || utility.CheckInternet()
 			glib.IdleAdd(func() bool {
 				if connected {
 					spinner.Stop()
@@ -131,35 +131,3 @@ func CreateCheckDeviceStep(onNext func()) gtk.Widgetter {
 
 	return box
 }
-
-func checkDiskSize() (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "lsblk", "-o", "NAME,SIZE,TYPE,MODEL", "-d", "-n")
-	out, err := cmd.Output()
-	if err != nil {
-		return false, fmt.Errorf("%s: %w", lib.T_("Error getting disk list"), err)
-	}
-
-	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	diskAvailable := false
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
-			continue
-		}
-		sizeStr := fields[1]
-
-		sizeGB, err := parseSize(sizeStr)
-		if err != nil {
-			continue
-		}
-		if sizeGB >= 60 {
-			diskAvailable = true
-			break
-		}
-	}
-
-	return diskAvailable, nil
-}
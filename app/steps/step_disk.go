@@ -19,23 +19,17 @@ package steps
 import (
 	"fmt"
 	"installer/app/image"
+	"installer/app/install"
+	"installer/app/utility"
 	"installer/lib"
-	"os/exec"
-	"strconv"
+	"slices"
 	"strings"
 
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 )
 
-type DiskInfo struct {
-	Path   string
-	Size   string
-	Model  string
-	SizeGB float64
-}
-
 // CreateDiskStep – виджет для выбора диска
-func CreateDiskStep(onDiskSelected func(string, bool, string)) gtk.Widgetter {
+func CreateDiskStep(onDiskSelected func(string, install.Partitioning, bool, string, install.TpmOptions, install.LuksOptions, string)) gtk.Widgetter {
 	outerBox := gtk.NewBox(gtk.OrientationVertical, 12)
 	outerBox.SetMarginTop(20)
 	outerBox.SetMarginBottom(20)
@@ -60,9 +54,9 @@ func CreateDiskStep(onDiskSelected func(string, bool, string)) gtk.Widgetter {
 	outerBox.Append(centerBox)
 
 	// Получаем список дисков
-	disks := getAvailableDisks()
+	disks := utility.GetAvailableDisks(utility.MinDiskSizeGB)
 	if len(disks) == 0 {
-		lib.Log.Error("No suitable disks (≥ 60 GB) found.")
+		lib.Log.Errorf("No suitable disks (≥ %d GB) found.", utility.MinDiskSizeGB)
 	}
 
 	combo := gtk.NewComboBoxText()
@@ -78,12 +72,94 @@ func CreateDiskStep(onDiskSelected func(string, bool, string)) gtk.Widgetter {
 	}
 	centerBox.Append(combo)
 
-	descLabel := gtk.NewLabel(fmt.Sprintf("%s ≥ 60 ГБ", lib.T_("Disk size")))
+	descLabel := gtk.NewLabel(fmt.Sprintf("%s ≥ %d ГБ", lib.T_("Disk size"), utility.MinDiskSizeGB))
 	descLabel.SetHAlign(gtk.AlignStart)
 	descLabel.SetMarginTop(10)
 	descLabel.SetHAlign(gtk.AlignCenter)
 	centerBox.Append(descLabel)
 
+	// Режим разметки: очистка диска или установка рядом с существующими системами
+	modeCombo := gtk.NewComboBoxText()
+	modeCombo.AppendText(lib.T_("Erase disk and install"))
+	modeCombo.AppendText(lib.T_("Install alongside existing systems"))
+	modeCombo.AppendText(lib.T_("Manual partitioning"))
+	modeCombo.SetActive(0)
+	modeCombo.SetMarginTop(10)
+	centerBox.Append(modeCombo)
+
+	alongsideBox := gtk.NewBox(gtk.OrientationVertical, 8)
+	alongsideBox.SetHAlign(gtk.AlignCenter)
+	alongsideBox.SetVisible(false)
+	centerBox.Append(alongsideBox)
+
+	shrinkCombo := gtk.NewComboBoxText()
+	alongsideBox.Append(shrinkCombo)
+
+	sizeBox := gtk.NewBox(gtk.OrientationHorizontal, 10)
+	sizeBox.SetHAlign(gtk.AlignCenter)
+	sizeBox.Append(gtk.NewLabel(lib.T_("New partition size, GiB") + ":"))
+	sizeSpin := gtk.NewSpinButtonWithRange(1, 1, 1)
+	sizeBox.Append(sizeSpin)
+	alongsideBox.Append(sizeBox)
+
+	// Зеркалирование на дополнительные диски (btrfs RAID1) при очистке диска
+	mirrorCheck := gtk.NewCheckButtonWithLabel(lib.T_("Mirror the system to additional disks (btrfs RAID1)"))
+	mirrorCheck.SetHAlign(gtk.AlignCenter)
+	mirrorCheck.SetVisible(len(disks) > 1)
+	centerBox.Append(mirrorCheck)
+
+	mirrorBox := gtk.NewBox(gtk.OrientationVertical, 4)
+	mirrorBox.SetHAlign(gtk.AlignCenter)
+	mirrorBox.SetVisible(false)
+	centerBox.Append(mirrorBox)
+
+	// Очистка старых данных перед разметкой, только при очистке диска
+	wipeBox := gtk.NewBox(gtk.OrientationHorizontal, 10)
+	wipeBox.SetHAlign(gtk.AlignCenter)
+	wipeBox.Append(gtk.NewLabel(lib.T_("Disk preparation") + ":"))
+	wipeCombo := gtk.NewComboBoxText()
+	wipeBox.Append(wipeCombo)
+	centerBox.Append(wipeBox)
+	var wipeModes []string
+
+	// Ручная разметка: каждому разделу назначается роль и признак форматирования
+	manualGrid := gtk.NewGrid()
+	manualGrid.SetColumnSpacing(12)
+	manualGrid.SetRowSpacing(4)
+	manualGrid.SetHAlign(gtk.AlignCenter)
+	manualGrid.SetVisible(false)
+	centerBox.Append(manualGrid)
+
+	layoutBox := gtk.NewBox(gtk.OrientationVertical, 8)
+	layoutBox.SetHAlign(gtk.AlignCenter)
+	layoutBox.SetVisible(false)
+	centerBox.Append(layoutBox)
+
+	layoutTitle := gtk.NewLabel(lib.T_("Resulting layout") + ":")
+	layoutTitle.SetHAlign(gtk.AlignStart)
+	layoutBox.Append(layoutTitle)
+
+	layoutLabel := gtk.NewLabel("")
+	layoutLabel.SetHAlign(gtk.AlignStart)
+	layoutLabel.SetSelectable(true)
+	layoutLabel.AddCSSClass("monospace")
+	layoutBox.Append(layoutLabel)
+
+	// Разделы выбранного диска, которые можно уменьшить; первый пункт — только свободное место
+	var shrinkable []install.ExistingPartition
+	layoutValid := false
+
+	// Строки ручной разметки: раздел, выбранная роль (0 — не используется) и форматирование
+	type manualRow struct {
+		path   string
+		role   *gtk.ComboBoxText
+		format *gtk.CheckButton
+	}
+	var manualRows []manualRow
+
+	// Флажки дополнительных дисков RAID1 по индексу в disks; у выбранного основного диска флажка нет
+	mirrorChecks := make(map[int]*gtk.CheckButton)
+
 	// Галочка шифрования диска
 	encryptBox := gtk.NewBox(gtk.OrientationHorizontal, 10)
 	encryptBox.SetHAlign(gtk.AlignCenter)
@@ -108,12 +184,169 @@ func CreateDiskStep(onDiskSelected func(string, bool, string)) gtk.Widgetter {
 	passwordEntry := gtk.NewPasswordEntry()
 	passwordEntry.SetSizeRequest(300, -1)
 	passwordEntry.SetShowPeekIcon(true)
-	passwordEntry.Object.SetObjectProperty("placeholder-text", lib.T_("Minimum 4 characters"))
-
+	passwordEntry.Object.SetObjectProperty("placeholder-text", lib.T_("Several unrelated words"))
+
+	// Повтор пароля: опечатка в пароле LUKS сделает диск недоступным
+	confirmEntry := gtk.NewPasswordEntry()
+	confirmEntry.SetSizeRequest(300, -1)
+	confirmEntry.SetShowPeekIcon(true)
+	confirmEntry.Object.SetObjectProperty("placeholder-text", lib.T_("Repeat password"))
+
+	// Оценка надёжности пароля: слишком простой блокирует продолжение, слабый — только предупреждает
+	strengthLabel := gtk.NewLabel("")
+	strengthLabel.SetWrap(true)
+	strengthLabel.SetMaxWidthChars(40)
+	strengthLabel.SetHAlign(gtk.AlignStart)
+	strengthLabel.SetVisible(false)
+
+	// Раскладка консоли: с ней пароль вводится при загрузке, она же записывается в установленную систему
+	keymaps := slices.Clone(install.Keymaps)
+	if keymap := install.DetectKeymap(chosenLangCode()); !slices.Contains(keymaps, keymap) {
+		keymaps = append(keymaps, keymap)
+	}
+	keymapLabel := gtk.NewLabel(lib.T_("Keyboard layout for the password prompt at boot"))
+	keymapLabel.SetHAlign(gtk.AlignStart)
+	keymapCombo := gtk.NewComboBoxText()
+	for _, keymap := range keymaps {
+		keymapCombo.AppendText(fmt.Sprintf("%s (%s)", install.KeymapTitle(keymap), keymap))
+	}
+	keymapCombo.SetActive(slices.Index(keymaps, install.DetectKeymap(chosenLangCode())))
+
+	// Предупреждение о символах, которые при загрузке могут набираться иначе
+	keymapWarning := gtk.NewLabel("")
+	keymapWarning.SetWrap(true)
+	keymapWarning.SetMaxWidthChars(40)
+	keymapWarning.SetHAlign(gtk.AlignStart)
+	keymapWarning.AddCSSClass("warning")
+	keymapWarning.SetVisible(false)
+
+	passwordBox.Append(keymapLabel)
+	passwordBox.Append(keymapCombo)
 	passwordBox.Append(passwordLabel)
 	passwordBox.Append(passwordEntry)
+	passwordBox.Append(confirmEntry)
+	passwordBox.Append(strengthLabel)
+	passwordBox.Append(keymapWarning)
 	centerBox.Append(passwordBox)
 
+	// Экспертные параметры luksFormat; «По умолчанию» оставляет выбор за cryptsetup
+	luksExpander := gtk.NewExpander(lib.T_("Expert encryption settings"))
+	luksExpander.SetMarginTop(10)
+	luksGrid := gtk.NewGrid()
+	luksGrid.SetRowSpacing(6)
+	luksGrid.SetColumnSpacing(12)
+	luksGrid.SetMarginTop(6)
+
+	cipherCombo := gtk.NewComboBoxText()
+	cipherCombo.AppendText(lib.T_("Default"))
+	for _, cipher := range install.LuksCiphers {
+		cipherCombo.AppendText(cipher)
+	}
+	cipherCombo.SetActive(0)
+
+	keySizes := []int{256, 512}
+	keySizeCombo := gtk.NewComboBoxText()
+	keySizeCombo.AppendText(lib.T_("Default"))
+	for _, size := range keySizes {
+		keySizeCombo.AppendText(fmt.Sprintf("%d", size))
+	}
+	keySizeCombo.SetActive(0)
+
+	pbkdfCombo := gtk.NewComboBoxText()
+	pbkdfCombo.AppendText(lib.T_("Default"))
+	for _, pbkdf := range install.LuksPbkdfs {
+		pbkdfCombo.AppendText(pbkdf)
+	}
+	pbkdfCombo.SetActive(0)
+
+	// Ноль в полях ниже означает значение cryptsetup по умолчанию
+	memorySpin := gtk.NewSpinButtonWithRange(0, 4096, 64)
+	parallelSpin := gtk.NewSpinButtonWithRange(0, 32, 1)
+	iterTimeSpin := gtk.NewSpinButtonWithRange(0, 60000, 500)
+
+	sectorCombo := gtk.NewComboBoxText()
+	sectorCombo.AppendText(lib.T_("Default"))
+	for _, size := range install.LuksSectorSizes {
+		sectorCombo.AppendText(fmt.Sprintf("%d", size))
+	}
+	sectorCombo.SetActive(0)
+
+	luksRows := []struct {
+		title  string
+		widget gtk.Widgetter
+	}{
+		{lib.T_("Cipher"), cipherCombo},
+		{lib.T_("Key size, bit"), keySizeCombo},
+		{lib.T_("PBKDF"), pbkdfCombo},
+		{lib.T_("PBKDF memory, MiB"), memorySpin},
+		{lib.T_("PBKDF threads"), parallelSpin},
+		{lib.T_("PBKDF unlock time, ms"), iterTimeSpin},
+		{lib.T_("Sector size, bytes"), sectorCombo},
+	}
+	for row, luksRow := range luksRows {
+		label := gtk.NewLabel(luksRow.title)
+		label.SetHAlign(gtk.AlignStart)
+		luksGrid.Attach(label, 0, row, 1, 1)
+		luksGrid.Attach(luksRow.widget, 1, row, 1, 1)
+	}
+	luksHint := gtk.NewLabel(lib.T_("0 means the cryptsetup default"))
+	luksHint.AddCSSClass("dim-label")
+	luksHint.SetHAlign(gtk.AlignStart)
+	luksGrid.Attach(luksHint, 0, len(luksRows), 2, 1)
+	luksExpander.SetChild(luksGrid)
+	passwordBox.Append(luksExpander)
+
+	luksOptions := func() install.LuksOptions {
+		var luks install.LuksOptions
+		if idx := cipherCombo.Active(); idx > 0 {
+			luks.Cipher = install.LuksCiphers[idx-1]
+		}
+		if idx := keySizeCombo.Active(); idx > 0 {
+			luks.KeySize = keySizes[idx-1]
+		}
+		if idx := pbkdfCombo.Active(); idx > 0 {
+			luks.Pbkdf = install.LuksPbkdfs[idx-1]
+		}
+		if luks.Pbkdf != install.PbkdfPbkdf2 {
+			luks.PbkdfMemory = memorySpin.ValueAsInt() * 1024
+			luks.PbkdfParallel = parallelSpin.ValueAsInt()
+		}
+		luks.IterTime = iterTimeSpin.ValueAsInt()
+		if idx := sectorCombo.Active(); idx > 0 {
+			luks.SectorSize = install.LuksSectorSizes[idx-1]
+		}
+		return luks
+	}
+
+	// Память и потоки задаются только для argon2
+	pbkdfCombo.ConnectChanged(func() {
+		isArgon2 := pbkdfCombo.Active() <= 0 || install.LuksPbkdfs[pbkdfCombo.Active()-1] != install.PbkdfPbkdf2
+		memorySpin.SetSensitive(isArgon2)
+		parallelSpin.SetSensitive(isArgon2)
+	})
+
+	// Привязка к TPM2 предлагается, только если он есть в системе; пароль остаётся запасным способом
+	tpmAvailable := install.TpmAvailable()
+	tpmCheck := gtk.NewCheckButtonWithLabel(lib.T_("Unlock disk automatically with TPM2"))
+	tpmCheck.SetMarginTop(10)
+	pcrsEntry := gtk.NewEntry()
+	pcrsEntry.SetText(install.DefaultTpmPCRs)
+	pcrsEntry.SetTooltipText(lib.T_("TPM2 PCRs (separated by +)"))
+	pcrsEntry.SetVisible(false)
+	tpmCheck.ConnectToggled(func() {
+		pcrsEntry.SetVisible(tpmCheck.Active())
+	})
+	if tpmAvailable {
+		passwordBox.Append(tpmCheck)
+		passwordBox.Append(pcrsEntry)
+	}
+	tpmOptions := func() install.TpmOptions {
+		if !tpmAvailable || !tpmCheck.Active() {
+			return install.TpmOptions{}
+		}
+		return install.TpmOptions{Enabled: true, PCRs: strings.TrimSpace(pcrsEntry.Text())}
+	}
+
 	// Показать/скрыть поле пароля в зависимости от галочки
 	updatePasswordVisibility := func() {
 		isEncrypted := encryptCheck.Active()
@@ -141,19 +374,241 @@ func CreateDiskStep(onDiskSelected func(string, bool, string)) gtk.Widgetter {
 		isEncrypted := encryptCheck.Active()
 		luksPassword := passwordEntry.Text()
 
-		isValid := true
+		isValid := modeCombo.Active() == 0 || layoutValid
+		// Для RAID1 нужен хотя бы один дополнительный диск
+		if modeCombo.Active() == 0 && mirrorCheck.Active() {
+			hasMirror := false
+			for _, check := range mirrorChecks {
+				hasMirror = hasMirror || check.Active()
+			}
+			isValid = isValid && hasMirror
+		}
+		strengthLabel.SetVisible(isEncrypted && luksPassword != "")
+		strengthLabel.RemoveCSSClass("error")
+		strengthLabel.RemoveCSSClass("warning")
+		strengthLabel.RemoveCSSClass("success")
 		if isEncrypted {
-			// Если шифрование включено, пароль должен быть минимум 4 символа
-			if len(luksPassword) < 4 {
+			// Слишком простой пароль и несовпадающий повтор не позволяют продолжить
+			level, tip := utility.CheckPassphrase(luksPassword)
+			switch {
+			case level == utility.PassphraseBlocked:
+				strengthLabel.AddCSSClass("error")
+				isValid = false
+			case confirmEntry.Text() != luksPassword:
+				tip = lib.T_("Passwords do not match")
+				strengthLabel.AddCSSClass("error")
+				isValid = false
+			case level == utility.PassphraseWeak:
+				strengthLabel.AddCSSClass("warning")
+			default:
+				strengthLabel.AddCSSClass("success")
+			}
+			strengthLabel.SetLabel(tip)
+
+			if tpmOptions().Validate() != nil || luksOptions().Validate() != nil {
 				isValid = false
 			}
 		}
 
+		// Символы, которые при загрузке могут набираться иначе, только предупреждают
+		layoutTip := ""
+		if isEncrypted {
+			layoutTip = utility.CheckPassphraseLayout(luksPassword, keymaps[max(keymapCombo.Active(), 0)])
+		}
+		keymapWarning.SetLabel(layoutTip)
+		keymapWarning.SetVisible(layoutTip != "")
+
 		chooseBtn.SetSensitive(isValid)
 	}
 
+	// Текущий способ разметки по состоянию формы
+	partitioning := func() install.Partitioning {
+		if modeCombo.Active() == 2 {
+			p := install.Partitioning{Mode: install.ModeManual}
+			for _, row := range manualRows {
+				if idx := row.role.Active(); idx > 0 {
+					p.Assignments = append(p.Assignments, install.PartitionAssignment{
+						Role:   install.ManualRoles[idx-1],
+						Path:   row.path,
+						Format: row.format.Active(),
+					})
+				}
+			}
+			return p
+		}
+		if modeCombo.Active() != 1 {
+			p := install.Partitioning{Mode: install.ModeErase}
+			if idx := wipeCombo.Active(); idx >= 0 && idx < len(wipeModes) {
+				p.Wipe = wipeModes[idx]
+			}
+			if mirrorCheck.Active() {
+				for idx, check := range mirrorChecks {
+					if check.Active() {
+						p.Mirrors = append(p.Mirrors, disks[idx].Path)
+					}
+				}
+				slices.Sort(p.Mirrors)
+			}
+			return p
+		}
+		p := install.Partitioning{Mode: install.ModeAlongside}
+		if idx := shrinkCombo.Active(); idx > 0 && idx <= len(shrinkable) {
+			p.ShrinkPartition = shrinkable[idx-1].Path
+			p.ShrinkToMiB = int64(sizeSpin.Value() * 1024)
+		}
+		return p
+	}
+
+	// Способы очистки зависят от дисков: blkdiscard доступен, только если discard поддерживают все выбранные диски
+	updateWipeModes := func() {
+		if combo.Active() < 0 {
+			return
+		}
+		selected := []string{disks[combo.Active()].Path}
+		if mirrorCheck.Active() {
+			for idx, check := range mirrorChecks {
+				if check.Active() {
+					selected = append(selected, disks[idx].Path)
+				}
+			}
+		}
+		current := install.WipeQuick
+		if idx := wipeCombo.Active(); idx >= 0 && idx < len(wipeModes) {
+			current = wipeModes[idx]
+		}
+		wipeModes = install.WipeModes(selected...)
+		wipeCombo.RemoveAll()
+		for _, mode := range wipeModes {
+			wipeCombo.AppendText(install.WipeModeTitle(mode))
+		}
+		wipeCombo.SetActive(max(slices.Index(wipeModes, current), 0))
+	}
+
+	// Предпросмотр итоговой разметки диска
+	updateLayout := func() {
+		updateWipeModes()
+		wipeBox.SetVisible(modeCombo.Active() == 0)
+		alongsideBox.SetVisible(modeCombo.Active() == 1)
+		manualGrid.SetVisible(modeCombo.Active() == 2)
+		mirrorCheck.SetVisible(modeCombo.Active() == 0 && len(disks) > 1)
+		mirrorBox.SetVisible(modeCombo.Active() == 0 && mirrorCheck.Active())
+		layoutBox.SetVisible(modeCombo.Active() != 0)
+		sizeBox.SetVisible(shrinkCombo.Active() > 0)
+		layoutValid = false
+		if modeCombo.Active() != 0 && combo.Active() >= 0 {
+			lines, err := install.PreviewLayout(disks[combo.Active()].Path, partitioning())
+			if err != nil {
+				layoutLabel.SetLabel(err.Error())
+				layoutLabel.AddCSSClass("error")
+			} else {
+				layoutLabel.SetLabel(strings.Join(lines, "\n"))
+				layoutLabel.RemoveCSSClass("error")
+				layoutValid = true
+			}
+		}
+		validateForm()
+	}
+
+	// При смене диска заново читаем его таблицу разделов
+	loadDiskTable := func() {
+		shrinkable = nil
+		shrinkCombo.RemoveAll()
+		shrinkCombo.AppendText(lib.T_("Use free space only"))
+		if active := combo.Active(); active >= 0 {
+			table, err := install.ReadDiskTable(disks[active].Path)
+			if err != nil {
+				lib.Log.Warningf("Error reading partition table of %s: %v", disks[active].Path, err)
+			} else {
+				for _, part := range table.Partitions {
+					if !part.Shrinkable() {
+						continue
+					}
+					shrinkable = append(shrinkable, part)
+					shrinkCombo.AppendText(fmt.Sprintf(lib.T_("Shrink %s (%s, %.1f GiB)"), part.Path, part.Filesystem, float64(part.SizeMiB())/1024))
+				}
+			}
+		}
+		shrinkCombo.SetActive(0)
+
+		for child := mirrorBox.FirstChild(); child != nil; child = mirrorBox.FirstChild() {
+			mirrorBox.Remove(child)
+		}
+		mirrorChecks = make(map[int]*gtk.CheckButton)
+		for idx, d := range disks {
+			if idx == combo.Active() {
+				continue
+			}
+			check := gtk.NewCheckButtonWithLabel(fmt.Sprintf("%s (%s)", d.Path, d.Size))
+			check.ConnectToggled(updateLayout)
+			mirrorBox.Append(check)
+			mirrorChecks[idx] = check
+		}
+
+		for child := manualGrid.FirstChild(); child != nil; child = manualGrid.FirstChild() {
+			manualGrid.Remove(child)
+		}
+		manualRows = nil
+		if active := combo.Active(); active >= 0 {
+			parts, err := utility.GetDiskPartitions(disks[active].Path)
+			if err != nil {
+				lib.Log.Warningf("Error listing partitions of %s: %v", disks[active].Path, err)
+			}
+			for idx, part := range parts {
+				display := fmt.Sprintf("%s (%s", part.Path, part.Size)
+				if part.Filesystem != "" {
+					display += ", " + part.Filesystem
+				}
+				if part.Label != "" {
+					display += ", " + part.Label
+				}
+				partLabel := gtk.NewLabel(display + ")")
+				partLabel.SetHAlign(gtk.AlignStart)
+
+				roleCombo := gtk.NewComboBoxText()
+				roleCombo.AppendText(lib.T_("Not used"))
+				for _, role := range install.ManualRoles {
+					roleCombo.AppendText(install.RoleTitle(role))
+				}
+				roleCombo.SetActive(0)
+
+				formatCheck := gtk.NewCheckButtonWithLabel(lib.T_("Format"))
+				formatCheck.SetActive(part.Filesystem == "")
+
+				// Корневой раздел всегда форматируется: bootc устанавливает систему только на пустую файловую систему
+				roleCombo.ConnectChanged(func() {
+					isRoot := roleCombo.Active() > 0 && install.ManualRoles[roleCombo.Active()-1] == install.RoleRoot
+					if isRoot {
+						formatCheck.SetActive(true)
+					}
+					formatCheck.SetSensitive(!isRoot)
+					updateLayout()
+				})
+				formatCheck.ConnectToggled(updateLayout)
+
+				manualGrid.Attach(partLabel, 0, idx, 1, 1)
+				manualGrid.Attach(roleCombo, 1, idx, 1, 1)
+				manualGrid.Attach(formatCheck, 2, idx, 1, 1)
+				manualRows = append(manualRows, manualRow{path: part.Path, role: roleCombo, format: formatCheck})
+			}
+		}
+		updateLayout()
+	}
+
+	combo.ConnectChanged(loadDiskTable)
+	modeCombo.ConnectChanged(updateLayout)
+	mirrorCheck.ConnectToggled(updateLayout)
+	shrinkCombo.ConnectChanged(func() {
+		if idx := shrinkCombo.Active(); idx > 0 && idx <= len(shrinkable) {
+			sizeMiB := shrinkable[idx-1].SizeMiB()
+			sizeSpin.SetRange(1, float64(sizeMiB/1024))
+			sizeSpin.SetValue(float64(max((sizeMiB-utility.MinDiskSizeGB*1024)/1024, 1)))
+		}
+		updateLayout()
+	})
+	sizeSpin.ConnectValueChanged(updateLayout)
+
 	// Изначальная проверка
-	validateForm()
+	loadDiskTable()
 
 	// Проверка при изменении галочки шифрования
 	encryptCheck.ConnectToggled(func() {
@@ -165,6 +620,26 @@ func CreateDiskStep(onDiskSelected func(string, bool, string)) gtk.Widgetter {
 	passwordEntry.ConnectChanged(func() {
 		validateForm()
 	})
+	confirmEntry.ConnectChanged(func() {
+		validateForm()
+	})
+	keymapCombo.ConnectChanged(validateForm)
+
+	// Проверка экспертных параметров LUKS
+	for _, luksCombo := range []*gtk.ComboBoxText{cipherCombo, keySizeCombo, pbkdfCombo, sectorCombo} {
+		luksCombo.ConnectChanged(validateForm)
+	}
+	for _, luksSpin := range []*gtk.SpinButton{memorySpin, parallelSpin, iterTimeSpin} {
+		luksSpin.ConnectValueChanged(validateForm)
+	}
+
+	// Проверка при изменении регистров TPM2
+	tpmCheck.ConnectToggled(func() {
+		validateForm()
+	})
+	pcrsEntry.ConnectChanged(func() {
+		validateForm()
+	})
 
 	chooseBtn.ConnectClicked(func() {
 		active := combo.Active()
@@ -174,77 +649,16 @@ func CreateDiskStep(onDiskSelected func(string, bool, string)) gtk.Widgetter {
 		chosenDisk := disks[active].Path
 		isEncrypted := encryptCheck.Active()
 		luksPassword := passwordEntry.Text()
-		onDiskSelected(chosenDisk, isEncrypted, luksPassword)
+		var tpm install.TpmOptions
+		var luks install.LuksOptions
+		var keymap string
+		if isEncrypted {
+			tpm = tpmOptions()
+			luks = luksOptions()
+			keymap = keymaps[max(keymapCombo.Active(), 0)]
+		}
+		onDiskSelected(chosenDisk, partitioning(), isEncrypted, luksPassword, tpm, luks, keymap)
 	})
 
 	return outerBox
 }
-
-func getAvailableDisks() []DiskInfo {
-	out, err := exec.Command("lsblk", "-o", "NAME,SIZE,TYPE,MODEL", "-d", "-n").Output()
-	if err != nil {
-		lib.Log.Error("Error getting disk list:", err)
-		return nil
-	}
-
-	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	var result []DiskInfo
-
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		var name, sizeStr, devType, model string
-
-		if len(fields) == 3 {
-			name, sizeStr, devType = fields[0], fields[1], fields[2]
-			model = lib.T_("unknown model")
-		} else if len(fields) >= 4 {
-			name, sizeStr, devType, model = fields[0], fields[1], fields[2], fields[3]
-		}
-
-		if devType != "disk" {
-			continue
-		}
-		if strings.HasPrefix(name, "zram") || strings.HasPrefix(name, "loop") {
-			continue
-		}
-		sizeGB, err := parseSize(sizeStr)
-		if err != nil {
-			continue
-		}
-		if sizeGB < 60 {
-			continue
-		}
-		path := "/dev/" + name
-		info := DiskInfo{
-			Path:   path,
-			Size:   sizeStr,
-			Model:  model,
-			SizeGB: sizeGB,
-		}
-		result = append(result, info)
-	}
-	return result
-}
-
-func parseSize(sizeStr string) (float64, error) {
-	if len(sizeStr) < 2 {
-		return 0, fmt.Errorf("unknown size format: %s", sizeStr)
-	}
-	sizeStr = strings.ReplaceAll(sizeStr, ",", ".")
-	unit := sizeStr[len(sizeStr)-1]
-	valStr := sizeStr[:len(sizeStr)-1]
-	val, err := strconv.ParseFloat(valStr, 64)
-	if err != nil {
-		return 0, fmt.Errorf("error parsing number from %s: %w", valStr, err)
-	}
-	switch unit {
-	case 'G':
-		return val, nil
-	case 'M':
-		return val / 1024.0, nil
-	case 'T':
-		return val * 1024.0, nil
-	default:
-		return 0, fmt.Errorf("unknown unit: %c", unit)
-	}
-}
@@ -18,6 +18,7 @@ package steps
 
 import (
 	"installer/app/image"
+	"installer/app/install"
 	"installer/lib"
 	"strings"
 
@@ -25,7 +26,9 @@ import (
 )
 
 // CreateFilesystemStep возвращает GUI-шаг выбора файловой системы.
-func CreateFilesystemStep(onFsSelected func(string)) gtk.Widgetter {
+// Если btrfsOnlyNote не пуст (RAID1), доступна только btrfs, а вместо описания показывается причина.
+// При ручной разметке и RAID1 LVM недоступен. Способы подкачки зависят от файловой системы и разметки, случайный ключ — от шифрования.
+func CreateFilesystemStep(btrfsOnlyNote string, partitioning install.Partitioning, crypto bool, onFsSelected func(fs string, lvm install.LvmOptions, snapshots bool, swap install.SwapOptions)) gtk.Widgetter {
 	outerBox := gtk.NewBox(gtk.OrientationVertical, 12)
 	outerBox.SetMarginTop(20)
 	outerBox.SetMarginBottom(20)
@@ -51,8 +54,19 @@ func CreateFilesystemStep(onFsSelected func(string)) gtk.Widgetter {
 
 	// Список вариантов (для ComboBoxText)
 	fsChoices := []string{
-		"btrfs subvolume:@, @home, @var",
+		"btrfs subvolume:" + install.SubVolumeNames(install.DefaultSubVolumes()),
 		"ext4 ",
+		"xfs ",
+	}
+	if btrfsOnlyNote != "" {
+		fsChoices = fsChoices[:1]
+	}
+	fsNameAt := func(idx int) string {
+		fsName := fsChoices[idx]
+		if i := strings.Index(fsName, " "); i != -1 {
+			fsName = fsName[:i]
+		}
+		return fsName
 	}
 
 	combo := gtk.NewComboBoxText()
@@ -71,20 +85,124 @@ func CreateFilesystemStep(onFsSelected func(string)) gtk.Widgetter {
 
 	// Изначально для btrfs
 	noteLabel.SetLabel(lib.T_("btrfs - recommended choice, works well with atomic image"))
+	if btrfsOnlyNote != "" {
+		noteLabel.SetLabel(btrfsOnlyNote)
+	}
+
+	// Снимки /home и /var доступны только для btrfs
+	snapshotsCheck := gtk.NewCheckButtonWithLabel(lib.T_("Take snapshots of /home and /var"))
+	snapshotsCheck.SetMarginTop(10)
+	centerBox.Append(snapshotsCheck)
 
 	// Меняем описание при смене выбора
 	combo.ConnectChanged(func() {
+		snapshotsCheck.SetVisible(combo.Active() == 0)
 		activeIndex := combo.Active()
 		if activeIndex < 0 {
 			noteLabel.SetLabel("")
 			return
 		}
-		if activeIndex == 0 {
+		noteLabel.RemoveCSSClass("error")
+		switch activeIndex {
+		case 0:
 			noteLabel.SetLabel(lib.T_("btrfs - recommended choice, works well with atomic image"))
-		} else {
+		case 1:
 			noteLabel.SetLabel(lib.T_("ext4 - classic, proven file system"))
+		default:
+			noteLabel.SetLabel(lib.T_("xfs - high-performance file system for servers"))
+		}
+	})
+
+	// LVM: root занимает всё свободное место группы, остальные тома создаются только при заданном размере
+	lvmAvailable := btrfsOnlyNote == "" && !partitioning.Manual()
+	lvmCheck := gtk.NewCheckButtonWithLabel(lib.T_("Use LVM"))
+	lvmCheck.SetMarginTop(10)
+	lvmBox := gtk.NewBox(gtk.OrientationVertical, 6)
+	lvmBox.SetVisible(false)
+
+	lvmHint := gtk.NewLabel(lib.T_("Enter the size of a separate volume (for example, 20GiB or 30%), leave empty to skip it"))
+	lvmHint.SetWrap(true)
+	lvmHint.SetMaxWidthChars(50)
+	lvmBox.Append(lvmHint)
+
+	lvmGrid := gtk.NewGrid()
+	lvmGrid.SetRowSpacing(6)
+	lvmGrid.SetColumnSpacing(12)
+	varEntry := gtk.NewEntry()
+	homeEntry := gtk.NewEntry()
+	swapEntry := gtk.NewEntry()
+	for row, volume := range []struct {
+		title string
+		entry *gtk.Entry
+	}{
+		{lib.T_("Size of /var"), varEntry},
+		{lib.T_("Size of /home"), homeEntry},
+		{lib.T_("Size of swap"), swapEntry},
+	} {
+		label := gtk.NewLabel(volume.title)
+		label.SetHAlign(gtk.AlignStart)
+		volume.entry.SetPlaceholderText("20GiB")
+		lvmGrid.Attach(label, 0, row, 1, 1)
+		lvmGrid.Attach(volume.entry, 1, row, 1, 1)
+	}
+	lvmBox.Append(lvmGrid)
+
+	lvmErrorLabel := gtk.NewLabel("")
+	lvmErrorLabel.AddCSSClass("error")
+	lvmBox.Append(lvmErrorLabel)
+
+	lvmCheck.ConnectToggled(func() {
+		lvmBox.SetVisible(lvmCheck.Active())
+	})
+	if lvmAvailable {
+		centerBox.Append(lvmCheck)
+		centerBox.Append(lvmBox)
+	}
+
+	// Подкачка: файл подкачки доступен только на btrfs, поэтому список пересобирается при смене файловой системы
+	swapLabel := gtk.NewLabel(lib.T_("Swap"))
+	swapLabel.SetHAlign(gtk.AlignStart)
+	swapLabel.SetMarginTop(10)
+	centerBox.Append(swapLabel)
+
+	swapCombo := gtk.NewComboBoxText()
+	centerBox.Append(swapCombo)
+	hibernateCheck := gtk.NewCheckButtonWithLabel(lib.T_("Enable hibernation"))
+	centerBox.Append(hibernateCheck)
+	randomKeyCheck := gtk.NewCheckButtonWithLabel(lib.T_("Encrypt swap with a new random key on every boot"))
+	centerBox.Append(randomKeyCheck)
+
+	var swapModes []string
+	selectedSwapMode := func() string {
+		if idx := swapCombo.Active(); idx >= 0 && idx < len(swapModes) {
+			return swapModes[idx]
+		}
+		return install.SwapNone
+	}
+	// Гибернация нужна только разделу или файлу подкачки; логический том swap уже находится внутри LUKS
+	updateSwapOptions := func() {
+		mode := selectedSwapMode()
+		hibernateCheck.SetVisible(mode == install.SwapPartition || mode == install.SwapFile)
+		randomKeyCheck.SetVisible(crypto && mode == install.SwapPartition && !lvmCheck.Active() && !hibernateCheck.Active())
+	}
+	updateSwapModes := func(fsName string) {
+		swapModes = install.SwapModes(fsName, partitioning)
+		swapCombo.RemoveAll()
+		for _, mode := range swapModes {
+			swapCombo.AppendText(install.SwapModeTitle(mode))
+		}
+		swapCombo.SetActive(0)
+		updateSwapOptions()
+	}
+	swapCombo.ConnectChanged(updateSwapOptions)
+	hibernateCheck.ConnectToggled(updateSwapOptions)
+	lvmCheck.ConnectToggled(updateSwapOptions)
+	combo.ConnectChanged(func() {
+		if activeIndex := combo.Active(); activeIndex >= 0 {
+			updateSwapModes(fsNameAt(activeIndex))
 		}
 	})
+	updateSwapModes(fsNameAt(0))
 
 	// Горизонтальный контейнер для кнопок внизу
 	buttonBox := gtk.NewBox(gtk.OrientationHorizontal, 20)
@@ -105,13 +223,42 @@ func CreateFilesystemStep(onFsSelected func(string)) gtk.Widgetter {
 			return
 		}
 
-		chosenStr := fsChoices[activeIndex]
-		fsName := chosenStr
-		if idx := strings.Index(fsName, " "); idx != -1 {
-			fsName = fsName[:idx]
+		fsName := fsNameAt(activeIndex)
+
+		// Утилиты xfs могут отсутствовать в установочном образе
+		if err := install.CheckFilesystemCommands(fsName); err != nil {
+			noteLabel.SetLabel(err.Error())
+			noteLabel.AddCSSClass("error")
+			return
+		}
+
+		var lvm install.LvmOptions
+		if lvmAvailable && lvmCheck.Active() {
+			lvm = install.LvmOptions{
+				Enabled:  true,
+				VarSize:  strings.TrimSpace(varEntry.Text()),
+				HomeSize: strings.TrimSpace(homeEntry.Text()),
+				SwapSize: strings.TrimSpace(swapEntry.Text()),
+			}
+			if err := lvm.Validate(); err != nil {
+				lvmErrorLabel.SetLabel(err.Error())
+				return
+			}
+		}
+		lvmErrorLabel.SetLabel("")
+
+		swap := install.SwapOptions{
+			Mode:      selectedSwapMode(),
+			Hibernate: hibernateCheck.Visible() && hibernateCheck.Active(),
+			RandomKey: randomKeyCheck.Visible() && randomKeyCheck.Active(),
+		}
+		if err := swap.Validate(fsName, partitioning); err != nil {
+			noteLabel.SetLabel(err.Error())
+			noteLabel.AddCSSClass("error")
+			return
 		}
 
-		onFsSelected(fsName)
+		onFsSelected(fsName, lvm, fsName == "btrfs" && snapshotsCheck.Active(), swap)
 	})
 
 	return outerBox
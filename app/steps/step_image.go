@@ -17,12 +17,10 @@
 package steps
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
 	"installer/app/image"
+	"installer/app/utility"
 	"installer/lib"
-	"os/exec"
 	"strings"
 	"sync"
 
@@ -30,64 +28,9 @@ import (
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 )
 
-// ImagePodman – структура для парсинга "podman images --format json"
-type ImagePodman struct {
-	Names []string `json:"Names"`
-}
-
-// Choice – элемент списка доступных образов
-type Choice struct {
-	Name        string
-	ShortText   string
-	Description string
-}
-
-// getAvailableImages – заглушка вместо реального podman.
-func getAvailableImages() []Choice {
-	var images []Choice
-	return addDefaultImage(images)
-}
-
-// addDefaultImage – добавляет «стандартные» образы
-func addDefaultImage(images []Choice) []Choice {
-	if images == nil {
-		images = []Choice{}
-	}
-	images = append(
-		images,
-		Choice{
-			Name:        "altlinux.space/alt-atomic/onyx:stable",
-			ShortText:   "Onyx",
-			Description: lib.T_("GNOME Image. Recommended"),
-		},
-		Choice{
-			Name:        "altlinux.space/alt-atomic/onyx:stable-nv",
-			ShortText:   "Onyx with NVIDIA",
-			Description: lib.T_("GNOME image for NVIDIA"),
-		},
-	)
-	return images
-}
-
-// validateImage – проверяем образ через `skopeo inspect`.
-func validateImage(image string) (string, error) {
-	cmd := exec.Command("skopeo", "inspect", "docker://"+image)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	output, err := cmd.Output()
-	if err != nil {
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			return stderr.String(), err
-		}
-		return lib.T_("Error executing command (check that skopeo is installed)"), err
-	}
-	return string(output), nil
-}
-
 // CreateImageStep – виджет для шага выбора образа.
-func CreateImageStep(onImageSelected func(string)) gtk.Widgetter {
+// Для образов из реестра перед продолжением проверяется подключение к интернету.
+func CreateImageStep(onImageSelected func(utility.ImageChoice)) gtk.Widgetter {
 	// ВЕРТИКАЛЬНЫЙ box – «корневой»
 	outerBox := gtk.NewBox(gtk.OrientationVertical, 12)
 	outerBox.SetMarginStart(20)
@@ -113,10 +56,10 @@ func CreateImageStep(onImageSelected func(string)) gtk.Widgetter {
 	outerBox.Append(centerBox)
 
 	// Получаем список «стандартных» образов
-	images := getAvailableImages()
+	images := utility.GetAvailableImages()
 
 	// Добавляем пункт «кастомный» (последним)
-	images = append(images, Choice{
+	images = append(images, utility.ImageChoice{
 		Name:        lib.T_("Add your image"),
 		Description: "",
 	})
@@ -236,7 +179,7 @@ func CreateImageStep(onImageSelected func(string)) gtk.Widgetter {
 		go func(img string) {
 			var mu sync.Mutex
 			mu.Lock()
-			out, err := validateImage(img)
+			out, err := utility.ValidateImage(img)
 			mu.Unlock()
 
 			// Возврат в UI-поток
@@ -257,7 +200,7 @@ func CreateImageStep(onImageSelected func(string)) gtk.Widgetter {
 					checkResultLabel.RemoveCSSClass("error")
 					customImageValid = imageName
 
-					images = append(images, Choice{
+					images = append(images, utility.ImageChoice{
 						Name:        imageName,
 						Description: "",
 					})
@@ -278,21 +221,39 @@ func CreateImageStep(onImageSelected func(string)) gtk.Widgetter {
 			return
 		}
 
-		var resultImage string
+		var resultImage utility.ImageChoice
 		if activeIndex == customChoiceIndex {
 			if customImageValid == "" {
 				checkResultLabel.SetLabel(lib.T_("First check the entered image"))
 				checkResultLabel.SetVisible(true)
 				return
 			}
-			resultImage = customImageValid
-		} else if activeIndex >= len(images) {
-			resultImage = images[activeIndex].Name
+			resultImage = utility.ImageChoice{Name: customImageValid}
 		} else {
-			resultImage = images[activeIndex].Name
+			resultImage = images[activeIndex]
+		}
+
+		// Образ с установочного носителя не требует сети
+		if resultImage.Source != "" {
+			onImageSelected(resultImage)
+			return
 		}
 
-		onImageSelected(resultImage)
+		chooseBtn.SetSensitive(false)
+		go func() {
+			connected := utility.CheckInternet()
+			glib.IdleAdd(func() bool {
+				chooseBtn.SetSensitive(true)
+				if !connected {
+					checkResultLabel.SetLabel(lib.T_("Check your internet connection"))
+					checkResultLabel.SetVisible(true)
+					checkResultLabel.AddCSSClass("error")
+					return false
+				}
+				onImageSelected(resultImage)
+				return false
+			})
+		}()
 	})
 
 	// Изначальное описание (для пункта 0)
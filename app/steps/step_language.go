@@ -28,6 +28,14 @@ import (
 // Изначально chosenLangIndex = -1 означает, что выбор еще не задан
 var chosenLangIndex int = -1
 
+// chosenLangCode возвращает код выбранного языка интерфейса.
+func chosenLangCode() string {
+	if chosenLangIndex == 1 {
+		return "en"
+	}
+	return "ru"
+}
+
 // CreateLanguageStep – шаг выбора языка.
 func CreateLanguageStep(updateStep func(), onLanguageSelected func(string)) gtk.Widgetter {
 	box := gtk.NewBox(gtk.OrientationVertical, 12)
@@ -30,10 +30,12 @@ import (
 )
 
 var statusLabel *gtk.Label
+var progressBar *gtk.ProgressBar
+var warningLabel *gtk.Label
 var logView *gtk.TextView
 
-// CreateInstallProgressStep – шаг, запускающий и показывающий процесс установки.
-func CreateInstallProgressStep(window *adw.ApplicationWindow, chosenLang, chosenImage, chosenDisk, chosenFilesystem, chosenBootMode, chosenUsername, chosenPassword string, chosenCrypto bool, chosenLuksPassword string, onCancel func()) gtk.Widgetter {
+// CreateInstallProgressStep – шаг, запускающий и показывающий процесс установки с параметрами installData.
+func CreateInstallProgressStep(window *adw.ApplicationWindow, installData install.InstallerData, onCancel func()) gtk.Widgetter {
 	outerBox := gtk.NewBox(gtk.OrientationVertical, 12)
 	outerBox.SetMarginTop(20)
 	outerBox.SetMarginBottom(20)
@@ -60,6 +62,21 @@ func CreateInstallProgressStep(window *adw.ApplicationWindow, chosenLang, chosen
 	statusLabel.SetVAlign(gtk.AlignStart)
 	outerBox.Append(statusLabel)
 
+	// Общий ход установки по всем этапам и оценка оставшегося времени
+	progressBar = gtk.NewProgressBar()
+	progressBar.SetShowText(true)
+	progressBar.SetText("0%")
+	progressBar.SetMarginStart(40)
+	progressBar.SetMarginEnd(40)
+	outerBox.Append(progressBar)
+
+	warningLabel = gtk.NewLabel("")
+	warningLabel.SetWrap(true)
+	warningLabel.SetHAlign(gtk.AlignCenter)
+	warningLabel.AddCSSClass("warning")
+	warningLabel.SetVisible(false)
+	outerBox.Append(warningLabel)
+
 	scrolledWindow := gtk.NewScrolledWindow()
 	scrolledWindow.SetHExpand(true)
 	scrolledWindow.SetVExpand(true)
@@ -82,10 +99,23 @@ func CreateInstallProgressStep(window *adw.ApplicationWindow, chosenLang, chosen
 	cancelBtn.SetSizeRequest(150, 45)
 	buttonBox.Append(cancelBtn)
 
+	installService := install.NewInstallerService(installData)
+
 	parent := castToGtkWindow(window)
 
-	// Обработчик для отмены установки (до завершения установки)
+	// Обработчик для отмены установки (до завершения установки); после отмены кнопка закрывает установщик
+	var cancelled bool
 	cancelBtn.ConnectClicked(func() {
+		if cancelled {
+			if onCancel != nil {
+				onCancel()
+			}
+			return
+		}
+		if installService.Status.GetStatus() == install.StatusCompleted {
+			return
+		}
+
 		dialog := gtk.NewMessageDialog(
 			parent,
 			gtk.DialogModal,
@@ -99,47 +129,88 @@ func CreateInstallProgressStep(window *adw.ApplicationWindow, chosenLang, chosen
 		dialog.AddButton(lib.T_("Yes"), int(gtk.ResponseOK))
 
 		dialog.ConnectResponse(func(responseID int) {
-			if responseID == int(gtk.ResponseOK) {
-				if onCancel != nil {
-					onCancel()
-				}
-			}
 			dialog.Destroy()
+			if responseID != int(gtk.ResponseOK) {
+				return
+			}
+			// Отмена ждёт остановки контейнера и отката изменений, поэтому выполняется вне главного потока
+			cancelBtn.SetSensitive(false)
+			go func() {
+				installService.Cancel()
+				glib.IdleAdd(func() {
+					cancelled = true
+					cancelBtn.SetLabel(lib.T_("Close"))
+					cancelBtn.SetSensitive(true)
+				})
+			}()
 		})
 		dialog.Show()
 	})
 
-	user := install.User{
-		Login:    chosenUsername,
-		Password: chosenPassword,
-	}
+	watchNewLog()
+	watchStatus(installService, cancelBtn, parent)
 
-	installData := install.InstallerData{
-		Image:              chosenImage,
-		Disk:               chosenDisk,
-		TypeFilesystem:     chosenFilesystem,
-		TypeBoot:           chosenBootMode,
-		IsCryptoFilesystem: chosenCrypto,
-		LuksPassword:       chosenLuksPassword,
-		User:               user,
+	// Прерванную установку с теми же параметрами можно продолжить без повторной разметки и загрузки образа
+	if phase := installService.ResumePhase(); phase != "" {
+		showResumeDialog(parent, phase, func(resume bool) {
+			installService.SetResume(resume)
+			go installService.RunInstall()
+		})
+	} else {
+		go installService.RunInstall()
 	}
 
-	installService := install.NewInstallerService(installData)
-	watchNewLog()
-	watchStatus(installService, cancelBtn)
-	go installService.RunInstall()
-
 	return outerBox
 }
 
-// watchStatus обновляет статус и, при достижении StatusCompleted, меняет кнопку "Отмена" на "Перезагрузка".
-func watchStatus(service *install.InstallerService, cancelBtn *gtk.Button) {
+// showResumeDialog предлагает продолжить прерванную установку с этапа phase или начать её заново.
+func showResumeDialog(parent *gtk.Window, phase string, onChoice func(resume bool)) {
+	dialog := gtk.NewMessageDialog(
+		parent,
+		gtk.DialogModal,
+		gtk.MessageQuestion,
+		gtk.ButtonsNone,
+	)
+	dialog.SetTitle(lib.T_("Installation"))
+	dialog.Object.SetObjectProperty("text", lib.T_("Resume interrupted installation?"))
+	dialog.Object.SetObjectProperty("secondary-text", fmt.Sprintf(
+		lib.T_("The previous installation attempt with the same settings was interrupted at the stage: %s"),
+		install.PhaseTitle(phase),
+	))
+
+	dialog.AddButton(lib.T_("Start over"), int(gtk.ResponseCancel))
+	dialog.AddButton(lib.T_("Resume"), int(gtk.ResponseOK))
+	dialog.SetDefaultResponse(int(gtk.ResponseOK))
+
+	dialog.ConnectResponse(func(responseID int) {
+		dialog.Destroy()
+		onChoice(responseID == int(gtk.ResponseOK))
+	})
+	dialog.Show()
+}
+
+// watchStatus обновляет статус, общий ход установки и предупреждения, а при достижении StatusCompleted
+// меняет кнопку "Отмена" на "Перезагрузка" и один раз показывает ключ восстановления LUKS.
+func watchStatus(service *install.InstallerService, cancelBtn *gtk.Button, parent *gtk.Window) {
+	var completed bool
+	events, _ := service.Status.Subscribe()
 	go func() {
-		for range service.Status.NotifyChan() {
-			currentStatus := service.Status.GetStatusText()
+		for event := range events {
 			glib.IdleAdd(func() {
-				statusLabel.SetLabel(fmt.Sprintf("<big><b>%s</b></big>", currentStatus))
-				if service.Status.GetStatus() == install.StatusCompleted {
+				if event.Warning != "" {
+					warningLabel.SetLabel(event.Warning)
+					warningLabel.SetVisible(true)
+					return
+				}
+				statusLabel.SetLabel(fmt.Sprintf("<big><b>%s</b></big>", event.Text))
+				progressBar.SetFraction(event.Percent / 100)
+				progressText := fmt.Sprintf("%.0f%%", event.Percent)
+				if event.Remaining > 0 {
+					progressText += " — " + fmt.Sprintf(lib.T_("about %s left"), event.Remaining)
+				}
+				progressBar.SetText(progressText)
+				if event.Status == install.StatusCompleted && !completed {
+					completed = true
 					cancelBtn.SetLabel(lib.T_("Restart"))
 					cancelBtn.AddCSSClass("blue-button")
 					cancelBtn.ConnectClicked(func() {
@@ -147,6 +218,7 @@ func watchStatus(service *install.InstallerService, cancelBtn *gtk.Button) {
 							exec.Command("reboot").Run()
 						}()
 					})
+					showRecoveryDialog(parent, service)
 				}
 			})
 		}
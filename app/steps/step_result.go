@@ -17,19 +17,20 @@
 package steps
 
 import (
+	"fmt"
+	"installer/app/answer"
 	"installer/app/image"
+	"installer/app/install"
+	"installer/app/utility"
 	"installer/lib"
 	"strings"
 
+	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 )
 
-// CreateSummaryStep – финальный шаг, отображающий все выбранные параметры.
-func CreateSummaryStep(
-	chosenLang, chosenImage, chosenDisk, chosenFilesystem, chosenBootMode, chosenUsername, chosenPassword string,
-	chosenCrypto bool,
-	onInstall func(),
-) gtk.Widgetter {
+// CreateSummaryStep – финальный шаг, отображающий все выбранные параметры установки и язык системы.
+func CreateSummaryStep(window *adw.ApplicationWindow, data install.InstallerData, chosenLang string, onInstall func()) gtk.Widgetter {
 	outerBox := gtk.NewBox(gtk.OrientationVertical, 12)
 	outerBox.SetMarginTop(20)
 	outerBox.SetMarginBottom(20)
@@ -74,20 +75,118 @@ func CreateSummaryStep(
 		row++
 	}
 
-	stars := strings.Repeat("*", len(chosenPassword))
-	addRow(lib.T_("User"), chosenUsername)
+	stars := strings.Repeat("*", len(data.User.Password))
+	addRow(lib.T_("User"), data.User.Login)
 	addRow(lib.T_("Password"), stars)
-	addRow(lib.T_("Bootloader"), chosenBootMode)
-	addRow(lib.T_("Selected image"), chosenImage)
+	addRow(lib.T_("Bootloader"), data.TypeBoot)
+	addRow(lib.T_("Selected image"), data.Image)
+	if data.ImageSource != "" {
+		addRow(lib.T_("Image source"), data.ImageSource)
+	}
 	addRow(lib.T_("System language"), chosenLang)
-	addRow(lib.T_("Selected disk"), chosenDisk)
-	addRow(lib.T_("Filesystem"), chosenFilesystem)
+	addRow(lib.T_("Selected disk"), data.Disk)
+	if data.Partitioning.Alongside() {
+		addRow(lib.T_("Installation mode"), lib.T_("Install alongside existing systems"))
+	}
+	if data.Partitioning.Raid() {
+		addRow(lib.T_("RAID1 disks"), strings.Join(data.Partitioning.Mirrors, ", "))
+	}
+	if data.Partitioning.Wipe != "" && data.Partitioning.Wipe != install.WipeQuick {
+		addRow(lib.T_("Disk preparation"), install.WipeModeTitle(data.Partitioning.Wipe))
+	}
+	if data.Partitioning.Manual() {
+		addRow(lib.T_("Installation mode"), lib.T_("Manual partitioning"))
+		for _, assignment := range data.Partitioning.Assignments {
+			addRow(install.RoleTitle(assignment.Role), assignment.Path)
+		}
+	}
+	addRow(lib.T_("Filesystem"), data.TypeFilesystem)
+	if data.Lvm.Enabled {
+		addRow("LVM", data.Lvm.Summary())
+	}
+	if data.Snapshots {
+		addRow(lib.T_("Snapshots"), lib.T_("Yes"))
+	}
+	addRow(lib.T_("Swap"), data.Swap.Summary())
 
 	cryptoText := lib.T_("No")
-	if chosenCrypto {
+	if data.IsCryptoFilesystem {
 		cryptoText = lib.T_("Yes")
+		if luks := data.Luks.Summary(); luks != "" {
+			cryptoText += ", " + luks
+		}
+		if data.Tpm.Enabled {
+			cryptoText += ", " + data.Tpm.Summary()
+		}
 	}
 	addRow(lib.T_("Disk encryption"), cryptoText)
+	if data.Keymap != "" {
+		addRow(lib.T_("Keyboard layout"), install.KeymapTitle(data.Keymap))
+	}
+
+	// Сохранение выбранных параметров в файл ответов для повторной установки
+	profileBox := gtk.NewBox(gtk.OrientationHorizontal, 10)
+	profileBox.SetHAlign(gtk.AlignCenter)
+	profileBox.SetMarginTop(20)
+	centerBox.Append(profileBox)
+
+	diskRuleLabel := gtk.NewLabel(lib.T_("Disk in profile") + ":")
+	profileBox.Append(diskRuleLabel)
+
+	diskRules := []string{"", utility.DiskRuleLargest, utility.DiskRuleSmallest, utility.DiskRuleFirst}
+	diskRuleCombo := gtk.NewComboBoxText()
+	diskRuleCombo.AppendText(data.Disk)
+	diskRuleCombo.AppendText(lib.T_("Largest disk"))
+	diskRuleCombo.AppendText(lib.T_("Smallest suitable disk"))
+	diskRuleCombo.AppendText(lib.T_("First suitable disk"))
+	diskRuleCombo.SetActive(0)
+	profileBox.Append(diskRuleCombo)
+
+	saveProfileBtn := gtk.NewButtonWithLabel(lib.T_("Save as profile"))
+	profileBox.Append(saveProfileBtn)
+
+	profileResultLabel := gtk.NewLabel("")
+	profileResultLabel.SetVisible(false)
+	centerBox.Append(profileResultLabel)
+
+	saveProfileBtn.ConnectClicked(func() {
+		chooser := gtk.NewFileChooserNative(
+			lib.T_("Save as profile"),
+			castToGtkWindow(window),
+			gtk.FileChooserActionSave,
+			lib.T_("Save"),
+			lib.T_("Cancel"),
+		)
+		chooser.SetModal(true)
+		chooser.SetCurrentName("atomic-profile.yml")
+
+		chooser.ConnectResponse(func(responseID int) {
+			defer chooser.Destroy()
+			if responseID != int(gtk.ResponseAccept) || chooser.File() == nil {
+				return
+			}
+			path := chooser.File().Path()
+
+			profile, err := answer.FromInstallerData(data, diskRules[max(diskRuleCombo.Active(), 0)])
+			if err == nil {
+				err = profile.Save(path)
+			}
+
+			profileResultLabel.SetVisible(true)
+			if err != nil {
+				lib.Log.Errorf("Error saving profile: %v", err)
+				profileResultLabel.SetLabel(fmt.Sprintf("%s: %v", lib.T_("Error saving profile"), err))
+				profileResultLabel.RemoveCSSClass("success")
+				profileResultLabel.AddCSSClass("error")
+				return
+			}
+			lib.Log.Infof("Profile saved to %s", path)
+			profileResultLabel.SetLabel(fmt.Sprintf("%s: %s", lib.T_("Profile saved"), path))
+			profileResultLabel.RemoveCSSClass("error")
+			profileResultLabel.AddCSSClass("success")
+		})
+		chooser.Show()
+	})
 
 	buttonBox := gtk.NewBox(gtk.OrientationHorizontal, 20)
 	buttonBox.SetHAlign(gtk.AlignCenter)
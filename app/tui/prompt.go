@@ -0,0 +1,151 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tui
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"installer/lib"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// errAborted возвращается, когда ввод закончился (Ctrl+D) и мастер нужно прервать.
+var errAborted = errors.New("input aborted")
+
+// prompt — построчный ввод и вывод в терминале.
+type prompt struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPrompt() *prompt {
+	return &prompt{
+		in:  bufio.NewReader(os.Stdin),
+		out: os.Stdout,
+	}
+}
+
+func (p *prompt) printf(format string, args ...any) {
+	_, _ = fmt.Fprintf(p.out, format, args...)
+}
+
+// header выводит заголовок шага.
+func (p *prompt) header(title string) {
+	p.printf("\n=== %s ===\n\n", title)
+}
+
+// readLine читает строку без завершающего перевода строки.
+func (p *prompt) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errAborted
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// ask запрашивает строку, пустой ввод заменяется значением по умолчанию.
+func (p *prompt) ask(question, def string) (string, error) {
+	if def != "" {
+		p.printf("%s [%s]: ", question, def)
+	} else {
+		p.printf("%s: ", question)
+	}
+	answer, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// choose выводит нумерованный список и возвращает индекс выбранного варианта.
+func (p *prompt) choose(question string, options []string, def int) (int, error) {
+	for idx, option := range options {
+		p.printf("  %d) %s\n", idx+1, option)
+	}
+	for {
+		answer, err := p.ask(question, strconv.Itoa(def+1))
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.Atoi(answer)
+		if err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+		p.printf("%s\n", lib.T_("Enter the number of one of the options"))
+	}
+}
+
+// confirm задаёт вопрос да/нет.
+func (p *prompt) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		p.printf("%s [%s]: ", question, hint)
+		answer, err := p.readLine()
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes", "д", "да":
+			return true, nil
+		case "n", "no", "н", "нет":
+			return false, nil
+		}
+	}
+}
+
+// password читает строку с отключённым эхом терминала.
+func (p *prompt) password(question string) (string, error) {
+	p.printf("%s: ", question)
+
+	fd := int(os.Stdin.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		// stdin не терминал (например, ввод из pipe) — читаем как обычно
+		return p.readLine()
+	}
+
+	noEcho := *termios
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, &noEcho); err != nil {
+		return p.readLine()
+	}
+	defer func() {
+		_ = unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+		p.printf("\n")
+	}()
+
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errAborted
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
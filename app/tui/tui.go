@@ -0,0 +1,1069 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"installer/app/answer"
+	"installer/app/install"
+	"installer/app/utility"
+	"installer/lib"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// Коды выхода текстового установщика
+const (
+	ExitSuccess      = 0
+	ExitAborted      = 1
+	ExitInstallError = 3
+)
+
+// wizard — текстовый мастер установки, повторяющий шаги графического интерфейса.
+type wizard struct {
+	*prompt
+	lang string
+	// keymap — раскладка консоли по умолчанию, определённая по установочной системе и языку
+	keymap string
+	data   install.InstallerData
+	dryRun bool
+}
+
+// Run запускает текстовый мастер установки и возвращает код выхода.
+// При dryRun вместо установки выводится её план.
+func Run(dryRun bool) int {
+	w := &wizard{prompt: newPrompt(), dryRun: dryRun}
+
+	steps := []func() error{
+		w.stepLanguage,
+		w.stepCheck,
+		w.stepImage,
+		w.stepDisk,
+		w.stepFilesystem,
+		w.stepBoot,
+		w.stepUser,
+	}
+
+	for {
+		for _, step := range steps {
+			if err := step(); err != nil {
+				return w.abort(err)
+			}
+		}
+
+		start, err := w.stepSummary()
+		if err != nil {
+			return w.abort(err)
+		}
+		if start {
+			break
+		}
+	}
+
+	return w.stepInstall()
+}
+
+func (w *wizard) abort(err error) int {
+	if !errors.Is(err, errAborted) {
+		w.printf("%s\n", err)
+		lib.Log.Error(err.Error())
+	}
+	w.printf("%s\n", lib.T_("Installation cancelled"))
+	return ExitAborted
+}
+
+// stepLanguage – выбор языка интерфейса.
+func (w *wizard) stepLanguage() error {
+	w.header(lib.T_("Language selection"))
+
+	languages := []string{"Русский", "English"}
+	codes := []string{"ru", "en"}
+
+	def := 0
+	if utility.GetSystemLocale() == language.English {
+		def = 1
+	}
+
+	idx, err := w.choose(lib.T_("Language selection"), languages, def)
+	if err != nil {
+		return err
+	}
+
+	w.lang = languages[idx]
+	lib.SetLanguage(codes[idx])
+	w.keymap = install.DetectKeymap(codes[idx])
+	return nil
+}
+
+// stepCheck – проверка дисков и подключения к интернету.
+func (w *wizard) stepCheck() error {
+	w.header(lib.T_("Device check"))
+	w.printf("%s\n", lib.T_("Checking device..."))
+
+	diskAvailable, err := utility.CheckDiskSize()
+	if err != nil {
+		return err
+	}
+	if !diskAvailable {
+		return fmt.Errorf(lib.T_("Insufficient disk space. At least %dGB required"), utility.MinDiskSizeGB)
+	}
+
+	// С образами на установочном носителе сеть не нужна, она проверяется при выборе образа из реестра
+	if len(utility.GetOfflineImages()) > 0 {
+		w.printf("%s\n", lib.T_("The device is ready for installation!"))
+		return nil
+	}
+
+	for !utility.CheckInternet() {
+		w.printf("%s\n", lib.T_("Check your internet connection"))
+		retry, err := w.confirm(lib.T_("Retry?"), true)
+		if err != nil {
+			return err
+		}
+		if !retry {
+			return errAborted
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	w.printf("%s\n", lib.T_("The device is ready for installation!"))
+	return nil
+}
+
+// stepImage – выбор образа из списка или ввод своего.
+func (w *wizard) stepImage() error {
+	w.header(lib.T_("Image selection"))
+
+	images := utility.GetAvailableImages()
+	var options []string
+	for _, img := range images {
+		options = append(options, fmt.Sprintf("%s  %s — %s", img.Name, img.ShortText, img.Description))
+	}
+	options = append(options, lib.T_("Add your image"))
+
+	for {
+		idx, err := w.choose(lib.T_("Image selection"), options, 0)
+		if err != nil {
+			return err
+		}
+		if idx < len(images) && images[idx].Source != "" {
+			w.data.Image = images[idx].Name
+			w.data.ImageSource = images[idx].Source
+			return nil
+		}
+
+		if !utility.CheckInternet() {
+			w.printf("%s\n", lib.T_("Check your internet connection"))
+			continue
+		}
+
+		w.data.ImageSource = ""
+		if idx < len(images) {
+			w.data.Image = images[idx].Name
+			return nil
+		}
+		return w.askCustomImage()
+	}
+}
+
+// askCustomImage – ввод и проверка своего образа из реестра.
+func (w *wizard) askCustomImage() error {
+	for {
+		imageName, err := w.ask(lib.T_("Enter the image link"), "")
+		if err != nil {
+			return err
+		}
+		if imageName == "" {
+			w.printf("%s\n", lib.T_("Please enter a valid image name"))
+			continue
+		}
+
+		w.printf("%s\n", lib.T_("Checking image..."))
+		if out, err := utility.ValidateImage(imageName); err != nil {
+			w.printf("%s:\n%s\n", lib.T_("Image verification error"), out)
+			continue
+		}
+
+		w.data.Image = imageName
+		return nil
+	}
+}
+
+// stepDisk – выбор диска и настройка шифрования.
+func (w *wizard) stepDisk() error {
+	w.header(lib.T_("Disk selection"))
+
+	disks := utility.GetAvailableDisks(utility.MinDiskSizeGB)
+	if len(disks) == 0 {
+		return fmt.Errorf(lib.T_("Insufficient disk space. At least %dGB required"), utility.MinDiskSizeGB)
+	}
+
+	var options []string
+	for _, d := range disks {
+		options = append(options, describeDisk(d))
+	}
+
+	idx, err := w.choose(lib.T_("Disk selection"), options, 0)
+	if err != nil {
+		return err
+	}
+	w.data.Disk = disks[idx].Path
+
+	// Если на диске уже есть разделы, систему можно установить рядом с ними
+	w.data.Partitioning = install.Partitioning{Mode: install.ModeErase}
+	if table, err := install.ReadDiskTable(w.data.Disk); err == nil && len(table.Partitions) > 0 {
+		modes := []string{lib.T_("Erase disk and install"), lib.T_("Install alongside existing systems"), lib.T_("Manual partitioning")}
+		mode, err := w.choose(lib.T_("Installation mode"), modes, 0)
+		if err != nil {
+			return err
+		}
+		switch mode {
+		case 1:
+			err = w.stepAlongside(table)
+		case 2:
+			err = w.stepManual()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.data.Partitioning.Erase() && len(disks) > 1 {
+		if err = w.stepMirrors(disks); err != nil {
+			return err
+		}
+	}
+	if w.data.Partitioning.Erase() {
+		if err = w.stepWipe(); err != nil {
+			return err
+		}
+	}
+
+	w.data.IsCryptoFilesystem, err = w.confirm(lib.T_("Encrypt disk with LUKS"), true)
+	if err != nil {
+		return err
+	}
+	w.data.RecoveryKey = w.data.IsCryptoFilesystem
+
+	w.data.LuksPassword = ""
+	w.data.Keymap = ""
+	if w.data.IsCryptoFilesystem {
+		if err = w.stepKeymap(); err != nil {
+			return err
+		}
+	}
+	for w.data.IsCryptoFilesystem {
+		password, err := w.password(lib.T_("LUKS password:"))
+		if err != nil {
+			return err
+		}
+		level, tip := utility.CheckPassphrase(password)
+		if level == utility.PassphraseBlocked {
+			w.printf("%s\n", tip)
+			continue
+		}
+		// Слабый пароль и символы, которые при загрузке набираются иначе, допустимы после подтверждения
+		var warnings []string
+		if level == utility.PassphraseWeak {
+			warnings = append(warnings, tip)
+		}
+		if layoutTip := utility.CheckPassphraseLayout(password, w.data.Keymap); layoutTip != "" {
+			warnings = append(warnings, layoutTip)
+		}
+		if len(warnings) > 0 {
+			w.printf("%s\n", strings.Join(warnings, "\n"))
+			accept, err := w.confirm(lib.T_("Use this password anyway?"), false)
+			if err != nil {
+				return err
+			}
+			if !accept {
+				continue
+			}
+		}
+		repeat, err := w.password(lib.T_("Repeat password"))
+		if err != nil {
+			return err
+		}
+		if password != repeat {
+			w.printf("%s\n", lib.T_("Passwords do not match. Try again."))
+			continue
+		}
+		w.data.LuksPassword = password
+		break
+	}
+
+	if err = w.stepLuks(); err != nil {
+		return err
+	}
+	return w.stepTpm()
+}
+
+// stepKeymap – раскладка консоли, с которой пароль LUKS будет вводиться при загрузке.
+func (w *wizard) stepKeymap() error {
+	keymaps := slices.Clone(install.Keymaps)
+	if !slices.Contains(keymaps, w.keymap) {
+		keymaps = append(keymaps, w.keymap)
+	}
+	options := make([]string, len(keymaps))
+	for idx, keymap := range keymaps {
+		options[idx] = fmt.Sprintf("%s (%s)", install.KeymapTitle(keymap), keymap)
+	}
+	idx, err := w.choose(lib.T_("Keyboard layout for the password prompt at boot"), options, slices.Index(keymaps, w.keymap))
+	if err != nil {
+		return err
+	}
+	w.data.Keymap = keymaps[idx]
+	return nil
+}
+
+// stepLuks – экспертные параметры luksFormat; пустой ввод оставляет значение cryptsetup по умолчанию.
+func (w *wizard) stepLuks() error {
+	w.data.Luks = install.LuksOptions{}
+	if !w.data.IsCryptoFilesystem {
+		return nil
+	}
+	expert, err := w.confirm(lib.T_("Change LUKS encryption parameters (expert)?"), false)
+	if err != nil || !expert {
+		return err
+	}
+	w.printf("%s\n", lib.T_("Leave a field empty to use the cryptsetup default"))
+
+	for {
+		var luks install.LuksOptions
+		if luks.Cipher, err = w.ask(lib.T_("Cipher"), ""); err != nil {
+			return err
+		}
+		if luks.KeySize, err = w.askNumber(lib.T_("Key size, bit")); err != nil {
+			return err
+		}
+		if luks.Pbkdf, err = w.ask(fmt.Sprintf("%s (%s)", lib.T_("PBKDF"), strings.Join(install.LuksPbkdfs, ", ")), ""); err != nil {
+			return err
+		}
+		if luks.Pbkdf != install.PbkdfPbkdf2 {
+			memoryMiB, err := w.askNumber(lib.T_("PBKDF memory, MiB"))
+			if err != nil {
+				return err
+			}
+			luks.PbkdfMemory = memoryMiB * 1024
+			if luks.PbkdfParallel, err = w.askNumber(lib.T_("PBKDF threads")); err != nil {
+				return err
+			}
+		}
+		if luks.IterTime, err = w.askNumber(lib.T_("PBKDF unlock time, ms")); err != nil {
+			return err
+		}
+		if luks.SectorSize, err = w.askNumber(lib.T_("Sector size, bytes")); err != nil {
+			return err
+		}
+		if err = luks.Validate(); err != nil {
+			w.printf("%v\n", err)
+			continue
+		}
+		w.data.Luks = luks
+		return nil
+	}
+}
+
+// askNumber запрашивает неотрицательное целое число, пустой ввод означает 0.
+func (w *wizard) askNumber(question string) (int, error) {
+	for {
+		answer, err := w.ask(question, "")
+		if err != nil || answer == "" {
+			return 0, err
+		}
+		n, err := strconv.Atoi(answer)
+		if err == nil && n > 0 {
+			return n, nil
+		}
+		w.printf("%s\n", lib.T_("Enter a positive number"))
+	}
+}
+
+// stepTpm – автоматическая разблокировка LUKS через TPM2, если он есть в системе.
+func (w *wizard) stepTpm() error {
+	w.data.Tpm = install.TpmOptions{}
+	if !w.data.IsCryptoFilesystem || !install.TpmAvailable() {
+		return nil
+	}
+	enabled, err := w.confirm(lib.T_("Unlock disk automatically with TPM2"), false)
+	if err != nil || !enabled {
+		return err
+	}
+	for {
+		pcrs, err := w.ask(lib.T_("TPM2 PCRs (separated by +)"), install.DefaultTpmPCRs)
+		if err != nil {
+			return err
+		}
+		tpm := install.TpmOptions{Enabled: true, PCRs: pcrs}
+		if err = tpm.Validate(); err != nil {
+			w.printf("%v\n", err)
+			continue
+		}
+		w.data.Tpm = tpm
+		return nil
+	}
+}
+
+// stepWipe – способ очистки дисков перед разметкой.
+func (w *wizard) stepWipe() error {
+	modes := install.WipeModes(append([]string{w.data.Disk}, w.data.Partitioning.Mirrors...)...)
+	options := make([]string, len(modes))
+	for idx, mode := range modes {
+		options[idx] = install.WipeModeTitle(mode)
+	}
+	idx, err := w.choose(lib.T_("Disk preparation"), options, 0)
+	if err != nil {
+		return err
+	}
+	w.data.Partitioning.Wipe = modes[idx]
+	return nil
+}
+
+// describeDisk возвращает строку диска для списка выбора.
+func describeDisk(d utility.DiskInfo) string {
+	display := fmt.Sprintf("%s (%s)", d.Path, d.Size)
+	if d.Model != "" {
+		display += " - " + d.Model
+	}
+	return display
+}
+
+// stepMirrors – выбор дополнительных дисков для btrfs RAID1.
+func (w *wizard) stepMirrors(disks []utility.DiskInfo) error {
+	mirror, err := w.confirm(lib.T_("Mirror the system to additional disks (btrfs RAID1)?"), false)
+	if err != nil || !mirror {
+		return err
+	}
+
+	for {
+		var options []string
+		var candidates []utility.DiskInfo
+		if len(w.data.Partitioning.Mirrors) > 0 {
+			options = append(options, lib.T_("Done"))
+		}
+		for _, d := range disks {
+			if d.Path == w.data.Disk || slices.Contains(w.data.Partitioning.Mirrors, d.Path) {
+				continue
+			}
+			candidates = append(candidates, d)
+			options = append(options, describeDisk(d))
+		}
+		if len(candidates) == 0 {
+			return nil
+		}
+
+		idx, err := w.choose(lib.T_("Additional disk for RAID1"), options, 0)
+		if err != nil {
+			return err
+		}
+		if len(w.data.Partitioning.Mirrors) > 0 {
+			if idx == 0 {
+				return nil
+			}
+			idx--
+		}
+		w.data.Partitioning.Mirrors = append(w.data.Partitioning.Mirrors, candidates[idx].Path)
+	}
+}
+
+// stepAlongside – выбор места для установки рядом с существующими системами и предпросмотр разметки.
+func (w *wizard) stepAlongside(table *install.DiskTable) error {
+	options := []string{lib.T_("Use free space only")}
+	var shrinkable []install.ExistingPartition
+	for _, part := range table.Partitions {
+		if part.Shrinkable() {
+			shrinkable = append(shrinkable, part)
+			options = append(options, fmt.Sprintf(lib.T_("Shrink %s (%s, %.1f GiB)"), part.Path, part.Filesystem, float64(part.SizeMiB())/1024))
+		}
+	}
+
+	for {
+		idx, err := w.choose(lib.T_("Free space for installation"), options, 0)
+		if err != nil {
+			return err
+		}
+
+		partitioning := install.Partitioning{Mode: install.ModeAlongside}
+		if idx > 0 {
+			part := shrinkable[idx-1]
+			def := max((part.SizeMiB()-utility.MinDiskSizeGB*1024)/1024, 1)
+			answer, err := w.ask(lib.T_("New partition size, GiB"), strconv.FormatInt(def, 10))
+			if err != nil {
+				return err
+			}
+			sizeGB, err := strconv.ParseFloat(strings.ReplaceAll(answer, ",", "."), 64)
+			if err != nil || sizeGB <= 0 {
+				w.printf("%s\n", lib.T_("Enter a positive number"))
+				continue
+			}
+			partitioning.ShrinkPartition = part.Path
+			partitioning.ShrinkToMiB = int64(sizeGB * 1024)
+		}
+
+		lines, err := install.PreviewLayout(w.data.Disk, partitioning)
+		if err != nil {
+			w.printf("%v\n", err)
+			continue
+		}
+		w.printf("\n%s:\n", lib.T_("Resulting layout"))
+		for _, line := range lines {
+			w.printf("  %s\n", line)
+		}
+
+		accept, err := w.confirm(lib.T_("Use this layout?"), true)
+		if err != nil {
+			return err
+		}
+		if accept {
+			w.data.Partitioning = partitioning
+			return nil
+		}
+	}
+}
+
+// stepManual – назначение ролей существующим разделам диска.
+func (w *wizard) stepManual() error {
+	partitions, err := utility.GetDiskPartitions(w.data.Disk)
+	if err != nil {
+		return err
+	}
+
+	for {
+		partitioning := install.Partitioning{Mode: install.ModeManual}
+		used := make(map[string]bool)
+		for _, role := range install.ManualRoles {
+			// Без ESP, /boot и / установка невозможна, остальные роли можно не назначать
+			required := role == install.RoleESP || role == install.RoleBoot || role == install.RoleRoot
+			var options []string
+			var candidates []utility.PartitionDetails
+			if !required {
+				options = append(options, lib.T_("Not used"))
+			}
+			for _, part := range partitions {
+				if used[part.Path] {
+					continue
+				}
+				candidates = append(candidates, part)
+				options = append(options, describePartition(part))
+			}
+			if len(candidates) == 0 {
+				if required {
+					return fmt.Errorf(lib.T_("Not enough partitions on %s"), w.data.Disk)
+				}
+				continue
+			}
+
+			idx, err := w.choose(install.RoleTitle(role), options, 0)
+			if err != nil {
+				return err
+			}
+			if !required {
+				if idx == 0 {
+					continue
+				}
+				idx--
+			}
+			part := candidates[idx]
+			used[part.Path] = true
+
+			// Корневой раздел всегда форматируется: bootc устанавливает систему только на пустую файловую систему
+			format := true
+			if role != install.RoleRoot {
+				format, err = w.confirm(fmt.Sprintf(lib.T_("Format %s?"), part.Path), part.Filesystem == "" || role == install.RoleBoot)
+				if err != nil {
+					return err
+				}
+			}
+			partitioning.Assignments = append(partitioning.Assignments, install.PartitionAssignment{Role: role, Path: part.Path, Format: format})
+		}
+
+		lines, err := install.PreviewLayout(w.data.Disk, partitioning)
+		if err != nil {
+			w.printf("%v\n", err)
+			continue
+		}
+		w.printf("\n%s:\n", lib.T_("Resulting layout"))
+		for _, line := range lines {
+			w.printf("  %s\n", line)
+		}
+
+		accept, err := w.confirm(lib.T_("Use this layout?"), true)
+		if err != nil {
+			return err
+		}
+		if accept {
+			w.data.Partitioning = partitioning
+			return nil
+		}
+	}
+}
+
+// describePartition возвращает строку раздела для списка выбора.
+func describePartition(part utility.PartitionDetails) string {
+	display := fmt.Sprintf("%s (%s", part.Path, part.Size)
+	if part.Filesystem != "" {
+		display += ", " + part.Filesystem
+	}
+	if part.Label != "" {
+		display += ", " + part.Label
+	}
+	return display + ")"
+}
+
+// stepFilesystem – выбор файловой системы.
+func (w *wizard) stepFilesystem() error {
+	w.header(lib.T_("Filesystem selection"))
+
+	// LVM не поддерживается вместе с ручной разметкой и RAID1
+	if w.data.Partitioning.Manual() || w.data.Partitioning.Raid() {
+		w.data.Lvm = install.LvmOptions{}
+	}
+	if w.data.Partitioning.Raid() {
+		w.data.TypeFilesystem = "btrfs"
+		w.printf("%s\n", lib.T_("RAID1 requires btrfs"))
+		if err := w.stepSnapshots(); err != nil {
+			return err
+		}
+		return w.stepSwap()
+	}
+
+	filesystems := []string{"btrfs", "ext4", "xfs"}
+	options := []string{
+		lib.T_("btrfs - recommended choice, works well with atomic image"),
+		lib.T_("ext4 - classic, proven file system"),
+		lib.T_("xfs - high-performance file system for servers"),
+	}
+
+	for {
+		idx, err := w.choose(lib.T_("Filesystem selection"), options, 0)
+		if err != nil {
+			return err
+		}
+		if !w.dryRun {
+			if err = install.CheckFilesystemCommands(filesystems[idx]); err != nil {
+				w.printf("%v\n", err)
+				continue
+			}
+		}
+
+		w.data.TypeFilesystem = filesystems[idx]
+		if err = w.stepSnapshots(); err != nil {
+			return err
+		}
+		if !w.data.Partitioning.Manual() {
+			if err = w.stepLvm(); err != nil {
+				return err
+			}
+		}
+		return w.stepSwap()
+	}
+}
+
+// stepSnapshots – снимки /home и /var, доступны только для btrfs.
+func (w *wizard) stepSnapshots() error {
+	if w.data.TypeFilesystem != "btrfs" {
+		w.data.Snapshots = false
+		return nil
+	}
+	var err error
+	w.data.Snapshots, err = w.confirm(lib.T_("Take snapshots of /home and /var"), w.data.Snapshots)
+	return err
+}
+
+// stepLvm – размещение root в LVM и размеры дополнительных логических томов.
+func (w *wizard) stepLvm() error {
+	enabled, err := w.confirm(lib.T_("Use LVM"), w.data.Lvm.Enabled)
+	if err != nil {
+		return err
+	}
+	w.data.Lvm = install.LvmOptions{Enabled: enabled}
+	if !enabled {
+		return nil
+	}
+
+	w.printf("%s\n", lib.T_("Enter the size of a separate volume (for example, 20GiB or 30%), leave empty to skip it"))
+	for {
+		lvm := install.LvmOptions{Enabled: true}
+		for _, volume := range []struct {
+			title string
+			size  *string
+		}{
+			{lib.T_("Size of /var"), &lvm.VarSize},
+			{lib.T_("Size of /home"), &lvm.HomeSize},
+			{lib.T_("Size of swap"), &lvm.SwapSize},
+		} {
+			if *volume.size, err = w.ask(volume.title, ""); err != nil {
+				return err
+			}
+		}
+		if err = lvm.Validate(); err != nil {
+			w.printf("%v\n", err)
+			continue
+		}
+		w.data.Lvm = lvm
+		return nil
+	}
+}
+
+// stepSwap – способ подкачки, гибернация и шифрование раздела подкачки случайным ключом.
+func (w *wizard) stepSwap() error {
+	modes := install.SwapModes(w.data.TypeFilesystem, w.data.Partitioning)
+	options := make([]string, len(modes))
+	current := 0
+	for idx, mode := range modes {
+		options[idx] = install.SwapModeTitle(mode)
+		if mode == w.data.Swap.Mode {
+			current = idx
+		}
+	}
+	idx, err := w.choose(lib.T_("Swap"), options, current)
+	if err != nil {
+		return err
+	}
+
+	swap := install.SwapOptions{Mode: modes[idx]}
+	if swap.Mode == install.SwapPartition || swap.Mode == install.SwapFile {
+		if swap.Hibernate, err = w.confirm(lib.T_("Enable hibernation"), w.data.Swap.Hibernate); err != nil {
+			return err
+		}
+	}
+	// Логический том swap уже находится внутри LUKS вместе с root
+	if swap.Mode == install.SwapPartition && w.data.IsCryptoFilesystem && !w.data.Lvm.Enabled && !swap.Hibernate {
+		if swap.RandomKey, err = w.confirm(lib.T_("Encrypt swap with a new random key on every boot"), w.data.Swap.RandomKey); err != nil {
+			return err
+		}
+	}
+	w.data.Swap = swap
+	return nil
+}
+
+// stepBoot – выбор режима загрузки.
+func (w *wizard) stepBoot() error {
+	w.header(lib.T_("Bootloader selection"))
+
+	modes := []string{"LEGACY"}
+	options := []string{lib.T_("LEGACY (UEFI not supported)")}
+	if utility.CheckUEFISupport() {
+		modes = []string{"UEFI", "LEGACY"}
+		options = []string{
+			lib.T_("UEFI (recommended for modern systems)"),
+			lib.T_("LEGACY (compatible variant)"),
+		}
+	}
+
+	idx, err := w.choose(lib.T_("Bootloader selection"), options, 0)
+	if err != nil {
+		return err
+	}
+
+	w.data.TypeBoot = modes[idx]
+	return nil
+}
+
+// stepUser – создание пользователя.
+func (w *wizard) stepUser() error {
+	w.header(lib.T_("User selection"))
+
+	for {
+		login, err := w.ask(lib.T_("Login"), w.data.User.Login)
+		if err != nil {
+			return err
+		}
+		if valid, tip := utility.IsValidUsername(login, false); !valid || login == "" {
+			if login == "" {
+				tip = lib.T_("Username and password cannot be empty.")
+			}
+			w.printf("%s\n", tip)
+			continue
+		}
+		w.data.User.Login = login
+		break
+	}
+
+	for {
+		password, err := w.password(lib.T_("Password"))
+		if err != nil {
+			return err
+		}
+		repeat, err := w.password(lib.T_("Repeat password"))
+		if err != nil {
+			return err
+		}
+		if password == "" {
+			w.printf("%s\n", lib.T_("Username and password cannot be empty."))
+			continue
+		}
+		if password != repeat {
+			w.printf("%s\n", lib.T_("Passwords do not match. Try again."))
+			continue
+		}
+		w.data.User.Password = password
+		return nil
+	}
+}
+
+// stepSummary – сводка выбранных параметров. Возвращает true, если нужно начать установку.
+func (w *wizard) stepSummary() (bool, error) {
+	for {
+		w.header(lib.T_("Summary"))
+
+		cryptoText := lib.T_("No")
+		if w.data.IsCryptoFilesystem {
+			cryptoText = lib.T_("Yes")
+			if luks := w.data.Luks.Summary(); luks != "" {
+				cryptoText += ", " + luks
+			}
+			if w.data.Tpm.Enabled {
+				cryptoText += ", " + w.data.Tpm.Summary()
+			}
+		}
+
+		rows := [][2]string{
+			{lib.T_("User"), w.data.User.Login},
+			{lib.T_("Password"), strings.Repeat("*", len(w.data.User.Password))},
+			{lib.T_("Bootloader"), w.data.TypeBoot},
+			{lib.T_("Selected image"), w.data.Image},
+			{lib.T_("System language"), w.lang},
+			{lib.T_("Selected disk"), w.data.Disk},
+			{lib.T_("Filesystem"), w.data.TypeFilesystem},
+			{lib.T_("Disk encryption"), cryptoText},
+		}
+		if w.data.Keymap != "" {
+			rows = append(rows, [2]string{lib.T_("Keyboard layout"), install.KeymapTitle(w.data.Keymap)})
+		}
+		if w.data.ImageSource != "" {
+			rows = append(rows, [2]string{lib.T_("Image source"), w.data.ImageSource})
+		}
+		if w.data.Partitioning.Alongside() {
+			rows = append(rows, [2]string{lib.T_("Installation mode"), lib.T_("Install alongside existing systems")})
+		}
+		if w.data.Partitioning.Raid() {
+			rows = append(rows, [2]string{lib.T_("RAID1 disks"), strings.Join(w.data.Partitioning.Mirrors, ", ")})
+		}
+		if w.data.Partitioning.Wipe != "" && w.data.Partitioning.Wipe != install.WipeQuick {
+			rows = append(rows, [2]string{lib.T_("Disk preparation"), install.WipeModeTitle(w.data.Partitioning.Wipe)})
+		}
+		if w.data.Lvm.Enabled {
+			rows = append(rows, [2]string{"LVM", w.data.Lvm.Summary()})
+		}
+		if w.data.Snapshots {
+			rows = append(rows, [2]string{lib.T_("Snapshots"), lib.T_("Yes")})
+		}
+		rows = append(rows, [2]string{lib.T_("Swap"), w.data.Swap.Summary()})
+		if w.data.Partitioning.Manual() {
+			rows = append(rows, [2]string{lib.T_("Installation mode"), lib.T_("Manual partitioning")})
+			for _, assignment := range w.data.Partitioning.Assignments {
+				rows = append(rows, [2]string{install.RoleTitle(assignment.Role), assignment.Path})
+			}
+		}
+		for _, row := range rows {
+			w.printf("  %-20s %s\n", row[0]+":", row[1])
+		}
+		if w.data.Partitioning.Manual() {
+			w.printf("\n%s\n\n", lib.T_("Only partitions marked for formatting will be erased"))
+		} else if w.data.Partitioning.Alongside() {
+			w.printf("\n%s\n\n", fmt.Sprintf(lib.T_("Existing partitions on %s will be kept"), w.data.Disk))
+		} else {
+			disks := append([]string{w.data.Disk}, w.data.Partitioning.Mirrors...)
+			w.printf("\n%s\n\n", fmt.Sprintf(lib.T_("All data on %s will be erased!"), strings.Join(disks, ", ")))
+		}
+
+		options := []string{
+			lib.T_("Start install"),
+			lib.T_("Save as profile"),
+			lib.T_("Change settings"),
+			lib.T_("Cancel"),
+		}
+		idx, err := w.choose(lib.T_("Summary"), options, 0)
+		if err != nil {
+			return false, err
+		}
+
+		switch idx {
+		case 0:
+			return true, nil
+		case 1:
+			if err = w.saveProfile(); err != nil {
+				w.printf("%s: %v\n", lib.T_("Error saving profile"), err)
+			}
+		case 2:
+			return false, nil
+		default:
+			return false, errAborted
+		}
+	}
+}
+
+// saveProfile сохраняет выбранные параметры в файл ответов.
+func (w *wizard) saveProfile() error {
+	rules := []string{"", utility.DiskRuleLargest, utility.DiskRuleSmallest, utility.DiskRuleFirst}
+	options := []string{
+		w.data.Disk,
+		lib.T_("Largest disk"),
+		lib.T_("Smallest suitable disk"),
+		lib.T_("First suitable disk"),
+	}
+	idx, err := w.choose(lib.T_("Disk in profile"), options, 0)
+	if err != nil {
+		return err
+	}
+
+	path, err := w.ask(lib.T_("Profile file"), "atomic-profile.yml")
+	if err != nil {
+		return err
+	}
+
+	profile, err := answer.FromInstallerData(w.data, rules[idx])
+	if err != nil {
+		return err
+	}
+	if err = profile.Save(path); err != nil {
+		return err
+	}
+
+	lib.Log.Infof("Profile saved to %s", path)
+	w.printf("%s: %s\n", lib.T_("Profile saved"), path)
+	return nil
+}
+
+// stepInstall – запуск установки с выводом смены статусов и журнала.
+func (w *wizard) stepInstall() int {
+	w.header(lib.T_("Installation"))
+
+	var executor install.Executor = install.NewLoggingExecutor(install.NewSystemExecutor())
+	plan := install.NewDryRunExecutor()
+	if w.dryRun {
+		executor = plan
+	}
+
+	service := install.NewInstallerServiceWithExecutor(w.data, executor)
+	if phase := service.ResumePhase(); phase != "" {
+		w.printf("%s\n", fmt.Sprintf(lib.T_("The previous installation attempt with the same settings was interrupted at the stage: %s"), install.PhaseTitle(phase)))
+		resume, err := w.confirm(lib.T_("Resume from this stage instead of starting over?"), true)
+		service.SetResume(err == nil && resume)
+	}
+	done := make(chan struct{})
+
+	// Ctrl+C останавливает установку с откатом изменений, а не завершает установщик посреди записи на диск
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+	go func() {
+		select {
+		case <-interrupts:
+			w.printf(">>> %s\n", lib.T_("Cancelling installation, undoing changes..."))
+			service.Cancel()
+		case <-done:
+		}
+	}()
+
+	// Поток событий закрывается после завершающего статуса, поэтому итог установки выводится до результата
+	events, unsubscribe := service.Status.Subscribe()
+	defer unsubscribe()
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		var lastText string
+		for event := range events {
+			if event.Warning != "" {
+				w.printf("!!! %s\n", event.Warning)
+				continue
+			}
+			if event.Text == lastText {
+				continue
+			}
+			lastText = event.Text
+			line := fmt.Sprintf("[%3.0f%%] %s", event.Percent, event.Text)
+			if event.Remaining > 0 {
+				line += " (" + fmt.Sprintf(lib.T_("about %s left"), event.Remaining) + ")"
+			}
+			w.printf(">>> %s\n", line)
+		}
+	}()
+
+	err := service.RunInstall()
+	close(done)
+	<-watched
+	if errors.Is(err, install.ErrCancelled) {
+		return ExitAborted
+	}
+	if err != nil {
+		w.printf("%v\n", err)
+		return ExitInstallError
+	}
+
+	if w.dryRun {
+		w.header(lib.T_("Installation plan"))
+		plan.PrintPlan(w.out)
+		return ExitSuccess
+	}
+
+	w.stepRecovery(service)
+
+	restart, err := w.confirm(lib.T_("Restart"), true)
+	if err == nil && restart {
+		if err = exec.Command("reboot").Run(); err != nil {
+			lib.Log.Errorf("Reboot error: %v", err)
+		}
+	}
+	return ExitSuccess
+}
+
+// stepRecovery – однократный показ ключа восстановления LUKS и сохранение его вместе с резервными
+// копиями заголовков LUKS на внешний носитель.
+func (w *wizard) stepRecovery(service *install.InstallerService) {
+	key := service.RecoveryKey()
+	headers := service.HeaderBackups()
+	if key == "" && len(headers) == 0 {
+		return
+	}
+
+	w.header(lib.T_("Disk recovery"))
+	if key != "" {
+		w.printf("%s\n", lib.T_("The key unlocks the disk if the password is lost. It is shown only once."))
+		w.printf("\n%s\n\n", key)
+		if qr, err := utility.QRCodeText(key); err == nil {
+			w.printf("%s\n", qr)
+		}
+	}
+	if len(headers) > 0 {
+		w.printf("%s\n", lib.T_("A damaged LUKS header makes the data unrecoverable. Save its backup to removable media."))
+	}
+
+	for {
+		dir, err := w.ask(lib.T_("Directory on removable media to save to (empty to skip)"), "")
+		if err != nil || dir == "" {
+			return
+		}
+		if err = service.ExportRecovery(dir, true, true); err != nil {
+			lib.Log.Errorf("Recovery export error: %v", err)
+			w.printf("%s: %v\n", lib.T_("Save error"), err)
+			continue
+		}
+		w.printf("%s: %s\n", lib.T_("Saved"), dir)
+		return
+	}
+}
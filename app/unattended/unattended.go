@@ -0,0 +1,176 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package unattended
+
+import (
+	"errors"
+	"fmt"
+	"installer/app/answer"
+	"installer/app/install"
+	"installer/app/utility"
+	"installer/lib"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Коды выхода автоматической установки
+const (
+	ExitSuccess         = 0
+	ExitValidationError = 2
+	ExitInstallError    = 3
+	ExitCancelled       = 4
+)
+
+// Run выполняет установку по файлу ответов без графического интерфейса и возвращает код выхода.
+// При dryRun диски не изменяются, а план установки выводится в stdout.
+func Run(path string, dryRun bool) int {
+	file, err := answer.Load(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		lib.Log.Errorf("Answer file error: %v", err)
+		return ExitValidationError
+	}
+
+	if err = file.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		lib.Log.Errorf("Answer file %s failed validation", path)
+		return ExitValidationError
+	}
+
+	data, err := file.ToInstallerData()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		lib.Log.Errorf("Answer file error: %v", err)
+		return ExitValidationError
+	}
+
+	// Пароль, который при загрузке может набираться иначе, не мешает установке, но о нём нужно предупредить
+	if data.IsCryptoFilesystem {
+		if tip := utility.CheckPassphraseLayout(data.LuksPassword, data.Keymap); tip != "" {
+			fmt.Fprintln(os.Stderr, tip)
+			lib.Log.Warning(tip)
+		}
+	}
+
+	// Утилиты для отдельных файловых систем проверяются только при реальной установке
+	if !dryRun {
+		if err = install.CheckFilesystemCommands(data.TypeFilesystem); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			lib.Log.Errorf("Filesystem tools check failed: %v", err)
+			return ExitValidationError
+		}
+	}
+
+	var executor install.Executor = install.NewLoggingExecutor(install.NewSystemExecutor())
+	plan := install.NewDryRunExecutor()
+	if dryRun {
+		lib.Log.Infof("Building installation plan from %s", path)
+		executor = plan
+	} else {
+		lib.Log.Infof("Starting unattended installation from %s", path)
+	}
+
+	service := install.NewInstallerServiceWithExecutor(data, executor)
+	if phase := service.ResumePhase(); phase != "" {
+		if file.Resume {
+			lib.Log.Infof("Resuming interrupted installation from phase %s", phase)
+		} else {
+			lib.Log.Infof("Interrupted installation found, starting over (set resume: true to continue from phase %s)", phase)
+		}
+		service.SetResume(file.Resume)
+	}
+	if err = service.CheckVolumeGroup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		lib.Log.Errorf("LVM volume group check failed: %v", err)
+		return ExitValidationError
+	}
+	watchStatus(service)
+	cancelOnSignal(service)
+
+	if err = service.RunInstall(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, install.ErrCancelled) {
+			return ExitCancelled
+		}
+		return ExitInstallError
+	}
+
+	if dryRun {
+		plan.PrintPlan(os.Stdout)
+		return ExitSuccess
+	}
+	exportRecovery(service, file.Encryption.ExportDir)
+	return ExitSuccess
+}
+
+// exportRecovery сохраняет ключ восстановления и резервные копии заголовков LUKS в exportDir.
+// Если каталог не задан или сохранить не удалось, ключ выводится в stdout — другой возможности узнать его не будет.
+func exportRecovery(service *install.InstallerService, exportDir string) {
+	key := service.RecoveryKey()
+	if exportDir != "" {
+		err := service.ExportRecovery(exportDir, key != "", true)
+		if err == nil {
+			lib.Log.Infof("Recovery data saved to %s", exportDir)
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		lib.Log.Errorf("Recovery export error: %v", err)
+	}
+
+	if key != "" {
+		fmt.Fprintf(os.Stdout, "LUKS recovery key: %s\n", key)
+	}
+	for _, header := range service.HeaderBackups() {
+		fmt.Fprintf(os.Stdout, "LUKS header backup: %s\n", header)
+	}
+}
+
+// cancelOnSignal отменяет установку по SIGINT или SIGTERM: выполняемые команды останавливаются,
+// а изменения откатываются до выхода.
+func cancelOnSignal(service *install.InstallerService) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		lib.Log.Warningf("Received %s, cancelling installation", sig)
+		service.Cancel()
+	}()
+}
+
+// watchStatus пишет в лог каждую смену статуса установки с общим ходом и предупреждения.
+func watchStatus(service *install.InstallerService) {
+	events, _ := service.Status.Subscribe()
+	go func() {
+		var lastText string
+		for event := range events {
+			if event.Warning != "" {
+				lib.Log.Warning(event.Warning)
+				continue
+			}
+			if event.Text == lastText {
+				continue
+			}
+			lastText = event.Text
+			if event.Remaining > 0 {
+				lib.Log.Infof("[%3.0f%%] %s (about %s left)", event.Percent, event.Text, event.Remaining)
+			} else {
+				lib.Log.Infof("[%3.0f%%] %s", event.Percent, event.Text)
+			}
+		}
+	}()
+}
@@ -0,0 +1,154 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package utility
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"strconv"
+	"strings"
+)
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Порядок байтов дайджеста при кодировании результата SHA-512 crypt
+var sha512CryptOrder = [][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+	{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+	{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+}
+
+// HashPassword возвращает хэш пароля в формате SHA-512 crypt ($6$), который понимает chpasswd -e.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	for i := range salt {
+		salt[i] = cryptAlphabet[int(salt[i])%len(cryptAlphabet)]
+	}
+
+	return sha512Crypt([]byte(password), salt, 0), nil
+}
+
+// IsPasswordHash сообщает, похожа ли строка на хэш crypt(3), а не на открытый пароль.
+func IsPasswordHash(value string) bool {
+	parts := strings.Split(value, "$")
+	return len(parts) >= 4 && parts[0] == "" && (parts[1] == "6" || parts[1] == "5" || parts[1] == "y")
+}
+
+// Число раундов SHA-512 crypt: по умолчанию и допустимые пределы
+const (
+	sha512CryptDefaultRounds = 5000
+	sha512CryptMinRounds     = 1000
+	sha512CryptMaxRounds     = 999999999
+)
+
+// sha512Crypt реализует алгоритм SHA-512 crypt. При rounds == 0 используется 5000 раундов
+// и они не указываются в результате; иначе число раундов приводится к допустимому и записывается как rounds=N.
+// Соль длиннее 16 символов обрезается.
+func sha512Crypt(password, salt []byte, rounds int) string {
+	customRounds := rounds != 0
+	if !customRounds {
+		rounds = sha512CryptDefaultRounds
+	}
+	rounds = min(max(rounds, sha512CryptMinRounds), sha512CryptMaxRounds)
+	salt = salt[:min(len(salt), 16)]
+
+	alternate := sha512.New()
+	alternate.Write(password)
+	alternate.Write(salt)
+	alternate.Write(password)
+	altSum := alternate.Sum(nil)
+
+	digest := sha512.New()
+	digest.Write(password)
+	digest.Write(salt)
+	for n := len(password); n > 0; n -= 64 {
+		digest.Write(altSum[:min(n, 64)])
+	}
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			digest.Write(altSum)
+		} else {
+			digest.Write(password)
+		}
+	}
+	result := digest.Sum(nil)
+
+	passwordDigest := sha512.New()
+	for range password {
+		passwordDigest.Write(password)
+	}
+	pSequence := repeatDigest(passwordDigest.Sum(nil), len(password))
+
+	saltDigest := sha512.New()
+	for n := 0; n < 16+int(result[0]); n++ {
+		saltDigest.Write(salt)
+	}
+	sSequence := repeatDigest(saltDigest.Sum(nil), len(salt))
+
+	for r := 0; r < rounds; r++ {
+		round := sha512.New()
+		if r%2 != 0 {
+			round.Write(pSequence)
+		} else {
+			round.Write(result)
+		}
+		if r%3 != 0 {
+			round.Write(sSequence)
+		}
+		if r%7 != 0 {
+			round.Write(pSequence)
+		}
+		if r%2 != 0 {
+			round.Write(result)
+		} else {
+			round.Write(pSequence)
+		}
+		result = round.Sum(nil)
+	}
+
+	var encoded strings.Builder
+	encoded.WriteString("$6$")
+	if customRounds {
+		encoded.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	encoded.Write(salt)
+	encoded.WriteString("$")
+	for _, group := range sha512CryptOrder {
+		encodeCrypt64(&encoded, uint(result[group[0]])<<16|uint(result[group[1]])<<8|uint(result[group[2]]), 4)
+	}
+	encodeCrypt64(&encoded, uint(result[63]), 2)
+
+	return encoded.String()
+}
+
+func repeatDigest(sum []byte, length int) []byte {
+	sequence := make([]byte, 0, length)
+	for len(sequence) < length {
+		sequence = append(sequence, sum[:min(length-len(sequence), len(sum))]...)
+	}
+	return sequence
+}
+
+func encodeCrypt64(out *strings.Builder, value uint, count int) {
+	for ; count > 0; count-- {
+		out.WriteByte(cryptAlphabet[value&0x3f])
+		value >>= 6
+	}
+}
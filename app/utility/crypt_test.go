@@ -0,0 +1,101 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package utility
+
+import (
+	"strings"
+	"testing"
+)
+
+// Контрольные значения из спецификации SHA-512 crypt (Ulrich Drepper, «Unix crypt using SHA-256 and SHA-512»)
+func TestSha512CryptKnownAnswers(t *testing.T) {
+	tests := []struct {
+		salt     string
+		rounds   int
+		password string
+		want     string
+	}{
+		{"saltstring", 0, "Hello world!",
+			"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"saltstringsaltstring", 10000, "Hello world!",
+			"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+		{"toolongsaltstring", 5000, "This is just a test",
+			"$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+		{"anotherlongsaltstring", 1400, "a very much longer text to encrypt.  This one even stretches over morethan one line.",
+			"$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1"},
+		{"short", 77777, "we have a short salt string but not a short password",
+			"$6$rounds=77777$short$WuQyW2YR.hBNpjjRhpYD/ifIw05xdfeEyQoMxIXbkvr0gge1a1x3yRULJ5CCaUeOxFmtlcGZelFl5CxtgfiAc0"},
+		{"asaltof16chars..", 123456, "a short string",
+			"$6$rounds=123456$asaltof16chars..$BtCwjqMJGx5hrJhZywWvt0RLE8uZ4oPwcelCjmw2kSYu.Ec6ycULevoBK25fs2xXgMNrCzIMVcgEJAstJeonj1"},
+		{"roundstoolow", 10, "the minimum number is still observed",
+			"$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX."},
+	}
+
+	for _, test := range tests {
+		if got := sha512Crypt([]byte(test.password), []byte(test.salt), test.rounds); got != test.want {
+			t.Errorf("sha512Crypt(%q, %q, %d) = %s, want %s", test.password, test.salt, test.rounds, got, test.want)
+		}
+	}
+}
+
+func TestHashPasswordVerifies(t *testing.T) {
+	const password = "correct horse battery staple"
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPasswordHash(hash) {
+		t.Fatalf("IsPasswordHash(%s) = false", hash)
+	}
+	if strings.Contains(hash, password) {
+		t.Fatalf("hash %s contains the password", hash)
+	}
+
+	// Хэш проверяется так же, как это делает crypt(3): повторным вычислением с солью из самого хэша
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || len(parts[2]) != 16 {
+		t.Fatalf("unexpected hash format %s", hash)
+	}
+	if again := sha512Crypt([]byte(password), []byte(parts[2]), 0); again != hash {
+		t.Errorf("rehash with the same salt = %s, want %s", again, hash)
+	}
+	if other := sha512Crypt([]byte(password+"!"), []byte(parts[2]), 0); other == hash {
+		t.Errorf("different password produced the same hash %s", hash)
+	}
+}
+
+func TestIsPasswordHash(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", true},
+		{"$6$rounds=5000$salt$hash", true},
+		{"$5$salt$hash", true},
+		{"$y$j9T$salt$hash", true},
+		{"$1$salt$hash", false},
+		{"$6$salt", false},
+		{"secret", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := IsPasswordHash(test.value); got != test.want {
+			t.Errorf("IsPasswordHash(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package utility
+
+import (
+	"context"
+	"fmt"
+	"installer/lib"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinDiskSizeGB — минимальный размер диска, пригодного для установки.
+const MinDiskSizeGB = 30
+
+// Правила выбора диска в файле ответов
+const (
+	DiskRuleFirst    = "first"
+	DiskRuleLargest  = "largest"
+	DiskRuleSmallest = "smallest"
+)
+
+type DiskInfo struct {
+	Path   string
+	Size   string
+	Model  string
+	SizeGB float64
+}
+
+// GetAvailableDisks возвращает диски, подходящие для установки (не менее minSizeGB).
+func GetAvailableDisks(minSizeGB float64) []DiskInfo {
+	out, err := exec.Command("lsblk", "-o", "NAME,SIZE,TYPE,MODEL", "-d", "-n").Output()
+	if err != nil {
+		lib.Log.Error("Error getting disk list:", err)
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	var result []DiskInfo
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		var name, sizeStr, devType, model string
+
+		if len(fields) == 3 {
+			name, sizeStr, devType = fields[0], fields[1], fields[2]
+			model = lib.T_("unknown model")
+		} else if len(fields) >= 4 {
+			name, sizeStr, devType, model = fields[0], fields[1], fields[2], fields[3]
+		}
+
+		if devType != "disk" {
+			continue
+		}
+		if strings.HasPrefix(name, "zram") || strings.HasPrefix(name, "loop") {
+			continue
+		}
+		sizeGB, err := ParseSize(sizeStr)
+		if err != nil {
+			continue
+		}
+		if sizeGB < minSizeGB {
+			continue
+		}
+		path := "/dev/" + name
+		info := DiskInfo{
+			Path:   path,
+			Size:   sizeStr,
+			Model:  model,
+			SizeGB: sizeGB,
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+// PartitionDetails — раздел диска по данным lsblk.
+type PartitionDetails struct {
+	Path       string
+	Size       string
+	Filesystem string
+	Label      string
+	MountPoint string
+}
+
+// GetDiskPartitions возвращает разделы диска с файловой системой, меткой и точкой монтирования.
+func GetDiskPartitions(disk string) ([]PartitionDetails, error) {
+	out, err := exec.Command("lsblk", "-lnP", "-o", "NAME,SIZE,TYPE,FSTYPE,LABEL,MOUNTPOINT", disk).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing partitions of %s: %w", disk, err)
+	}
+
+	var result []PartitionDetails
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := parseLsblkPairs(line)
+		if fields["TYPE"] != "part" {
+			continue
+		}
+		result = append(result, PartitionDetails{
+			Path:       "/dev/" + fields["NAME"],
+			Size:       fields["SIZE"],
+			Filesystem: fields["FSTYPE"],
+			Label:      fields["LABEL"],
+			MountPoint: fields["MOUNTPOINT"],
+		})
+	}
+	return result, nil
+}
+
+// parseLsblkPairs разбирает строку вывода lsblk -P вида NAME="sda1" SIZE="512M".
+func parseLsblkPairs(line string) map[string]string {
+	fields := make(map[string]string)
+	for line != "" {
+		eq := strings.Index(line, "=\"")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(line[:eq])
+		rest := line[eq+2:]
+		end := strings.Index(rest, "\"")
+		if end < 0 {
+			break
+		}
+		fields[key] = rest[:end]
+		line = rest[end+1:]
+	}
+	return fields
+}
+
+// SelectDisk выбирает диск по правилу из файла ответов.
+func SelectDisk(rule string, minSizeGB float64) (DiskInfo, error) {
+	switch rule {
+	case DiskRuleFirst, DiskRuleLargest, DiskRuleSmallest:
+	default:
+		return DiskInfo{}, fmt.Errorf("unknown disk rule %q", rule)
+	}
+
+	disks := GetAvailableDisks(minSizeGB)
+	if len(disks) == 0 {
+		return DiskInfo{}, fmt.Errorf("no disks of at least %.0f GB found", minSizeGB)
+	}
+
+	selected := disks[0]
+	for _, d := range disks[1:] {
+		if rule == DiskRuleLargest && d.SizeGB > selected.SizeGB {
+			selected = d
+		}
+		if rule == DiskRuleSmallest && d.SizeGB < selected.SizeGB {
+			selected = d
+		}
+	}
+	return selected, nil
+}
+
+// CheckDiskSize проверяет, есть ли в системе хотя бы один диск достаточного размера.
+func CheckDiskSize() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "lsblk", "-o", "NAME,SIZE,TYPE,MODEL", "-d", "-n")
+	out, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", lib.T_("Error getting disk list"), err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	diskAvailable := false
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		sizeStr := fields[1]
+
+		sizeGB, err := ParseSize(sizeStr)
+		if err != nil {
+			continue
+		}
+		if sizeGB >= MinDiskSizeGB {
+			diskAvailable = true
+			break
+		}
+	}
+
+	return diskAvailable, nil
+}
+
+// CheckUEFISupport – упрощённая проверка наличия каталога /sys/firmware/efi/efivars
+func CheckUEFISupport() bool {
+	_, err := os.Stat("/sys/firmware/efi/efivars")
+	return err == nil
+}
+
+// ParseSize переводит размер из вывода lsblk (например 238,5G) в гигабайты.
+func ParseSize(sizeStr string) (float64, error) {
+	if len(sizeStr) < 2 {
+		return 0, fmt.Errorf("unknown size format: %s", sizeStr)
+	}
+	sizeStr = strings.ReplaceAll(sizeStr, ",", ".")
+	unit := sizeStr[len(sizeStr)-1]
+	valStr := sizeStr[:len(sizeStr)-1]
+	val, err := strconv.ParseFloat(valStr, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing number from %s: %w", valStr, err)
+	}
+	switch unit {
+	case 'G':
+		return val, nil
+	case 'M':
+		return val / 1024.0, nil
+	case 'T':
+		return val * 1024.0, nil
+	default:
+		return 0, fmt.Errorf("unknown unit: %c", unit)
+	}
+}
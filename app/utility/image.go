@@ -0,0 +1,84 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package utility
+
+import (
+	"bytes"
+	"errors"
+	"installer/lib"
+	"os/exec"
+)
+
+// ImagePodman – структура для парсинга "podman images --format json"
+type ImagePodman struct {
+	Names []string `json:"Names"`
+}
+
+// ImageChoice – элемент списка доступных образов
+type ImageChoice struct {
+	// Name — имя образа в реестре, по которому установленная система получает обновления
+	Name string
+	// Source — локальный источник образа (oci-archive:, oci:, containers-storage:) для установки без сети;
+	// пустой, если образ загружается из реестра
+	Source      string
+	ShortText   string
+	Description string
+}
+
+// GetAvailableImages – образы с установочного носителя и «стандартные» образы из реестра.
+func GetAvailableImages() []ImageChoice {
+	images := GetOfflineImages()
+	return addDefaultImage(images)
+}
+
+// addDefaultImage – добавляет «стандартные» образы
+func addDefaultImage(images []ImageChoice) []ImageChoice {
+	if images == nil {
+		images = []ImageChoice{}
+	}
+	images = append(
+		images,
+		ImageChoice{
+			Name:        "altlinux.space/alt-atomic/onyx:stable",
+			ShortText:   "Onyx",
+			Description: lib.T_("GNOME Image. Recommended"),
+		},
+		ImageChoice{
+			Name:        "altlinux.space/alt-atomic/onyx:stable-nv",
+			ShortText:   "Onyx with NVIDIA",
+			Description: lib.T_("GNOME image for NVIDIA"),
+		},
+	)
+	return images
+}
+
+// ValidateImage – проверяем образ через `skopeo inspect`.
+func ValidateImage(image string) (string, error) {
+	cmd := exec.Command("skopeo", "inspect", "docker://"+image)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return stderr.String(), err
+		}
+		return lib.T_("Error executing command (check that skopeo is installed)"), err
+	}
+	return string(output), nil
+}
@@ -0,0 +1,221 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package utility
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"installer/lib"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Транспорты containers, из которых возможна установка без сети
+const (
+	TransportOCIArchive        = "oci-archive:"
+	TransportOCI               = "oci:"
+	TransportContainersStorage = "containers-storage:"
+)
+
+// annotationRefName — аннотация OCI с именем образа, под которым он был сохранён
+const annotationRefName = "org.opencontainers.image.ref.name"
+
+// ociIndex — index.json образа в формате OCI
+type ociIndex struct {
+	Manifests []struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"manifests"`
+}
+
+// storageImage — запись overlay-images/images.json хранилища containers-storage
+type storageImage struct {
+	ID    string   `json:"id"`
+	Names []string `json:"names"`
+}
+
+// IsOfflineSource проверяет, что источник образа задан локальным транспортом.
+func IsOfflineSource(source string) bool {
+	for _, transport := range []string{TransportOCIArchive, TransportOCI, TransportContainersStorage} {
+		if strings.HasPrefix(source, transport) && len(source) > len(transport) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetOfflineImages ищет образы на установочном носителе в каталогах из конфигурации.
+// Образы без имени в реестре пропускаются: без него bootc не сможет получать обновления.
+func GetOfflineImages() []ImageChoice {
+	var images []ImageChoice
+	for _, dir := range lib.Env.OfflineImageDirs {
+		found, err := scanOfflineDir(dir)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				lib.Log.Warningf("Error scanning offline images in %s: %v", dir, err)
+			}
+			continue
+		}
+		images = append(images, found...)
+	}
+	return images
+}
+
+// scanOfflineDir возвращает образы каталога: само хранилище containers-storage или oci-каталог,
+// либо вложенные oci-архивы и oci-каталоги.
+func scanOfflineDir(dir string) ([]ImageChoice, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	if isStorageRoot(dir) {
+		return storageImages(dir)
+	}
+	if isOCILayout(dir) {
+		return ociImages(TransportOCI, dir, readOCIDirIndex)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ImageChoice
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		var found []ImageChoice
+		switch {
+		case entry.IsDir() && isOCILayout(path):
+			found, err = ociImages(TransportOCI, path, readOCIDirIndex)
+		case !entry.IsDir() && (strings.HasSuffix(entry.Name(), ".tar") || strings.HasSuffix(entry.Name(), ".ociarchive")):
+			found, err = ociImages(TransportOCIArchive, path, readOCIArchiveIndex)
+		default:
+			continue
+		}
+		if err != nil {
+			lib.Log.Warningf("Skipping offline image %s: %v", path, err)
+			continue
+		}
+		images = append(images, found...)
+	}
+	return images, nil
+}
+
+func isOCILayout(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "oci-layout"))
+	return err == nil
+}
+
+func isStorageRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "overlay-images", "images.json"))
+	return err == nil
+}
+
+// ociImages создаёт по элементу списка на каждый манифест с именем образа в реестре.
+func ociImages(transport, path string, readIndex func(string) (*ociIndex, error)) ([]ImageChoice, error) {
+	index, err := readIndex(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ImageChoice
+	for _, manifest := range index.Manifests {
+		name := manifest.Annotations[annotationRefName]
+		// В ref.name может быть записан только тег, по нему образ в реестре не найти
+		if !strings.Contains(name, "/") {
+			lib.Log.Warningf("Skipping image %q in %s: %s is not a registry reference", name, path, annotationRefName)
+			continue
+		}
+		images = append(images, offlineChoice(name, transport+path+":"+name))
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images with %s annotation", annotationRefName)
+	}
+	return images, nil
+}
+
+func readOCIDirIndex(dir string) (*ociIndex, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index ociIndex
+	if err = json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("invalid index.json: %v", err)
+	}
+	return &index, nil
+}
+
+// readOCIArchiveIndex читает index.json из oci-архива, не распаковывая слои.
+func readOCIArchiveIndex(path string) (*ociIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil, errors.New("index.json not found, not an oci archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Clean(header.Name) != "index.json" {
+			continue
+		}
+
+		var index ociIndex
+		if err = json.NewDecoder(reader).Decode(&index); err != nil {
+			return nil, fmt.Errorf("invalid index.json: %v", err)
+		}
+		return &index, nil
+	}
+}
+
+// storageImages возвращает именованные образы хранилища containers-storage.
+func storageImages(root string) ([]ImageChoice, error) {
+	content, err := os.ReadFile(filepath.Join(root, "overlay-images", "images.json"))
+	if err != nil {
+		return nil, err
+	}
+	var stored []storageImage
+	if err = json.Unmarshal(content, &stored); err != nil {
+		return nil, fmt.Errorf("invalid images.json: %v", err)
+	}
+
+	var images []ImageChoice
+	for _, img := range stored {
+		for _, name := range img.Names {
+			images = append(images, offlineChoice(name, fmt.Sprintf("%s[overlay@%s]%s", TransportContainersStorage, root, name)))
+		}
+	}
+	return images, nil
+}
+
+func offlineChoice(name, source string) ImageChoice {
+	return ImageChoice{
+		Name:        name,
+		Source:      source,
+		ShortText:   lib.T_("Offline"),
+		Description: lib.T_("Image from the installation media, no network required"),
+	}
+}
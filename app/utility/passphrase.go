@@ -0,0 +1,88 @@
+// Atomic Installer
+// Copyright (C) 2025 Дмитрий Удалов dmitry@udalov.online
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package utility
+
+import (
+	"fmt"
+	"installer/lib"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/ccojocar/zxcvbn-go"
+)
+
+// Надёжность пароля LUKS
+const (
+	// PassphraseBlocked — пароль подбирается мгновенно, шифрование с ним не включается
+	PassphraseBlocked = iota
+	// PassphraseWeak — пароль допустим, но показывается предупреждение
+	PassphraseWeak
+	// PassphraseStrong — пароль достаточно надёжен
+	PassphraseStrong
+)
+
+// MinPassphraseLen — минимальная длина пароля LUKS.
+const MinPassphraseLen = 4
+
+// CheckPassphrase оценивает надёжность пароля LUKS по zxcvbn: учитываются словари, раскладка клавиатуры,
+// повторы, последовательности и даты, а не только длина и классы символов. Возвращает уровень и подсказку.
+// Данные пользователя в оценке не участвуют: в GUI пароль задаётся раньше имени пользователя, а проверка
+// должна давать одинаковый результат в GUI, TUI и при повторе сохранённого профиля.
+func CheckPassphrase(passphrase string) (int, string) {
+	if len(passphrase) < MinPassphraseLen {
+		return PassphraseBlocked, fmt.Sprintf(lib.T_("Minimum %d characters"), MinPassphraseLen)
+	}
+
+	// Оценка zxcvbn: 0 — подбирается мгновенно, 4 — за столетия
+	switch zxcvbn.PasswordStrength(passphrase, nil).Score {
+	case 0:
+		return PassphraseBlocked, lib.T_("The password is too easy to guess. Use several unrelated words or add more characters.")
+	case 1, 2:
+		return PassphraseWeak, lib.T_("The password is weak: an attacker with the disk can guess it. Consider a longer one.")
+	default:
+		return PassphraseStrong, lib.T_("Strong password")
+	}
+}
+
+// qwertyKeymaps — раскладки консоли, в которых латиница, цифры и знаки набираются так же, как в US.
+var qwertyKeymaps = []string{"", "us", "ru", "ua", "by"}
+
+// layoutSafeLetters — латинские буквы, которые стоят на одних и тех же клавишах в QWERTY, QWERTZ и AZERTY.
+const layoutSafeLetters = "bcdefghijklnoprstuvx"
+
+// CheckPassphraseLayout предупреждает о символах пароля LUKS, которые при загрузке могут набираться иначе,
+// чем в живой сессии: пароль вводится в initrd с раскладкой консоли, а не с раскладкой графического сеанса.
+// Возвращает пустую строку, если таких символов нет.
+func CheckPassphraseLayout(passphrase, keymap string) string {
+	var nonASCII, sensitive bool
+	for _, r := range passphrase {
+		if r > unicode.MaxASCII {
+			nonASCII = true
+		} else if !strings.ContainsRune(layoutSafeLetters, unicode.ToLower(r)) {
+			sensitive = true
+		}
+	}
+
+	if nonASCII {
+		return lib.T_("The password contains non-Latin characters. The boot prompt may not allow switching the layout, so the disk could not be unlocked.")
+	}
+	if sensitive && !slices.Contains(qwertyKeymaps, keymap) {
+		return fmt.Sprintf(lib.T_("The password contains digits, symbols or letters placed differently in the %s layout. If the image does not include this layout for the boot prompt, the password will have to be typed as on the US layout."), keymap)
+	}
+	return ""
+}
@@ -27,7 +27,34 @@ import (
 type Environment struct {
 	PathLocales string `yaml:"pathLocales"`
 	PathLogFile string `yaml:"pathLogFile"`
-	Language    language.Tag
+	// Layouts — схемы разметки диска по умолчанию для режимов загрузки UEFI и LEGACY
+	Layouts map[string][]PartitionSpec `yaml:"layouts"`
+	// BtrfsSubVolumes — подтомы корневой btrfs; если не заданы, создаются @, @home и @var
+	BtrfsSubVolumes []SubVolumeSpec `yaml:"btrfsSubvolumes"`
+	// OfflineImageDirs — каталоги установочного носителя, в которых ищутся образы для установки без сети
+	OfflineImageDirs []string `yaml:"offlineImageDirs"`
+	Language         language.Tag
+}
+
+// PartitionSpec — раздел в схеме разметки диска.
+type PartitionSpec struct {
+	// Role — назначение раздела: bios_grub, esp, boot, root, temp или swap
+	Role string `yaml:"role"`
+	// Size — размер в MiB/GiB/TiB, процент от размера диска ("30%") или "rest" для всего оставшегося места
+	Size string `yaml:"size"`
+	// Filesystem — файловая система; для root пустое значение означает файловую систему, выбранную пользователем
+	Filesystem string   `yaml:"filesystem"`
+	Flags      []string `yaml:"flags"`
+}
+
+// SubVolumeSpec — подтом корневой btrfs.
+type SubVolumeSpec struct {
+	Name       string `yaml:"name"`
+	MountPoint string `yaml:"mountPoint"`
+	// Options — параметры монтирования без subvol, например "compress=zstd:3,noatime"
+	Options string `yaml:"options"`
+	// NoCow отключает копирование при записи для файлов подтома (chattr +C), например для образов виртуальных машин
+	NoCow bool `yaml:"nocow"`
 }
 
 var Env Environment
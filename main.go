@@ -17,10 +17,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
 	"github.com/diamondburned/gotk4/pkg/gio/v2"
 	"installer/app"
+	"installer/app/tui"
+	"installer/app/unattended"
 	"installer/app/utility"
 	"installer/lib"
 	"log"
@@ -30,15 +33,37 @@ import (
 )
 
 func main() {
-	checkRoot()
+	answerFile := flag.String("config", "", "path to an answer file (YAML or TOML) for unattended installation")
+	textMode := flag.Bool("tui", false, "use the text-mode installer instead of the graphical one")
+	dryRun := flag.Bool("dry-run", false, "print the installation plan instead of changing disks (requires -config or -tui)")
+	flag.Parse()
+
+	if *dryRun && *answerFile == "" && !*textMode {
+		log.Fatal("-dry-run requires -config or -tui")
+	}
+
+	// В режиме плана система не изменяется, поэтому права root и установленные утилиты не нужны
+	if !*dryRun {
+		checkRoot()
+	}
 	lib.Env.Language = utility.GetSystemLocale()
 	lib.InitConfig()
 	lib.InitLocales()
 	lib.InitLogger()
 
-	err := checkCommands()
-	if err != nil {
-		log.Fatal(err)
+	if !*dryRun {
+		if err := checkCommands(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *answerFile != "" {
+		os.Exit(unattended.Run(*answerFile, *dryRun))
+	}
+
+	// Без графической сессии GTK не запустится, поэтому переходим в текстовый режим
+	if *textMode || !hasDisplay() {
+		os.Exit(tui.Run(*dryRun))
 	}
 
 	serviceInstallerView := app.NewInstallerViewService()
@@ -46,7 +71,7 @@ func main() {
 	application.ConnectActivate(func() {
 		serviceInstallerView.OnActivate(application)
 	})
-	os.Exit(application.Run(os.Args))
+	os.Exit(application.Run(os.Args[:1]))
 }
 
 // checkRoot проверка root прав
@@ -57,7 +82,13 @@ func checkRoot() {
 	}
 }
 
-// checkCommands проверяет наличие необходимых системных команд
+// hasDisplay проверяет наличие графической сессии X11 или Wayland
+func hasDisplay() bool {
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// checkCommands проверяет наличие системных команд, нужных при любой установке.
+// Утилиты отдельных файловых систем (например, mkfs.xfs) проверяются после выбора файловой системы.
 func checkCommands() error {
 	err := os.Setenv("PATH", os.Getenv("PATH")+":/usr/sbin:/sbin")
 	if err != nil {
@@ -66,6 +97,7 @@ func checkCommands() error {
 
 	commands := []string{
 		"podman",
+		"skopeo",
 		"rsync",
 		"wipefs",
 		"parted",